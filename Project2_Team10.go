@@ -1,573 +1,375 @@
 package main
 
 import (
-	"bufio"
+	"context"
 	"flag"
 	"fmt"
 	"io"
 	"os"
-	"sort"
-	"strconv"
-)
+	"path/filepath"
+	"strings"
+	"time"
 
-type Instruction struct {
-	typeOfInstruction string // instruction type "R", "I", etc..
-	rawInstruction    string // raw data (we need to run this through a function that figures out the OPcode)
-	lineValue         uint64 // linevalue = rawinstruction converted to uint64 so I could use mask and shift on it.
-	field             uint64
-	opcode            uint64 // once we know this we can figure out everything else
-	op                string // what is it? ADD, SUB, LSL, etc...
-	rd                uint8
-	rn                uint8
-	rm                uint8
-	im                uint8
-	rt                uint8
-	address           uint8
-	offset            int32
-	conditional       uint8
-	shamt             uint8
-	op2               uint8
-	cycle             int
-	programCnt        int // program counter
-}
+	"Project2_Team10/legv8"
+)
 
-// global data slice
-var dataSlice = make(map[int]int)
+func main() {
+	// the first argument can pick a mode, otherwise disassemble and simulate
+	mode := ""
+	if len(os.Args) > 1 {
+		mode = os.Args[1]
+	}
 
-// global register map
-var registerMap = make(map[uint8]int)
+	var err error
+	exitCode := 0
+	switch mode {
+	case "asm":
+		err = asmMain(os.Args[2:])
+	case "debug":
+		err = debugMain(os.Args[2:])
+	case "gdb":
+		err = gdbMain(os.Args[2:])
+	case "check":
+		exitCode, err = checkMain(os.Args[2:])
+	case "batch":
+		exitCode, err = batchMain(os.Args[2:])
+	case "compare":
+		exitCode, err = compareMain(os.Args[2:])
+	case "cosim":
+		exitCode, err = cosimMain(os.Args[2:])
+	default:
+		exitCode, err = simMain()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	os.Exit(exitCode)
+}
 
-func main() {
+// simMain runs the default mode: disassemble and simulate the input file. It
+// returns the exit code the program passed to the exit syscall.
+func simMain() (int, error) {
 	//flag.String gets pointers to command line arguments
 	cmdInFile := flag.String("i", "addtest1_bin.txt", "-i [input file path/name]")
 	cmdOutFile := flag.String("o", "team10_out.txt", "-o [output file path/name]")
+	cmdPipeline := flag.Bool("pipeline", false, "-pipeline simulate the five stage pipeline")
+	cmdForwarding := flag.Bool("forwarding", true, "-forwarding=false to stall on every data hazard in -pipeline mode")
+	cmdResolve := flag.String("resolve", "EX", "-resolve [EX|ID] stage branches resolve in for -pipeline mode")
+	cmdL1I := flag.String("l1i", "", "-l1i [on|size=,block=,ways=,replace=,write=,latency=] instruction cache")
+	cmdL1D := flag.String("l1d", "", "-l1d [on|size=,block=,ways=,replace=,write=,latency=] data cache")
+	cmdL2 := flag.String("l2", "", "-l2 [on|size=,block=,ways=,replace=,write=,latency=] shared second level cache")
+	cmdMemLatency := flag.Int("memlatency", 10, "-memlatency [cycles] main memory latency behind the caches")
+	cmdOOO := flag.Bool("ooo", false, "-ooo simulate out of order execution with Tomasulo's algorithm")
+	cmdROB := flag.Int("rob", legv8.DefaultTomasuloConfig.ROBSize, "-rob [entries] reorder buffer size for -ooo mode")
+	cmdALU := flag.String("alu", "", "-alu [stations=,units=,latency=] ALU op class for -ooo mode")
+	cmdLS := flag.String("ls", "", "-ls [stations=,units=,latency=] load/store op class for -ooo mode")
+	cmdBR := flag.String("br", "", "-br [stations=,units=,latency=] branch op class for -ooo mode")
+	cmdWidth := flag.Int("width", 0, "-width [1|2|4] simulate an in order superscalar machine issuing this many instructions a cycle")
+	cmdMemPorts := flag.Int("memports", 1, "-memports [ports] loads and stores issued per cycle in -width mode")
+	cmdLoadLatency := flag.Int("loadlatency", 2, "-loadlatency [cycles] before a loaded value can be used in -width mode")
+	cmdSaveAt := flag.Int("save-at", 0, "-save-at [cycle] save a checkpoint once this cycle retires")
+	cmdSaveOnBreak := flag.Bool("save-on-break", false, "-save-on-break save a checkpoint once BREAK retires")
+	cmdSave := flag.String("save", "", "-save [file] checkpoint file to write, default [output file]_cycle[N].ckpt")
+	cmdResume := flag.String("resume", "", "-resume [file] continue the simulation from a checkpoint of the same program")
+	cmdPredict := flag.String("predict", "", "-predict [taken|not-taken|btfnt|1bit|2bit|gshare|tournament][,table=,history=,btb=,penalty=] branch predictor")
+	cmdFormat := flag.String("format", "full", "-format [full|reference] reference writes a _sim.txt that matches the reference simulator")
+	cmdMaxCycles := flag.Int("max-cycles", 0, "-max-cycles [n] stop the program after n cycles, 0 for no limit")
+	cmdTimeout := flag.Duration("timeout", 0, "-timeout [duration] stop the program after this long, such as 10s, 0 for no limit")
+	cmdLoops := flag.Bool("loops", true, "-loops=false to keep running a program that has gone into a loop it can't leave")
+	cmdTrace := flag.String("trace", "", "-trace [jsonl|csv] write a record of every retired instruction")
+	cmdTraceOut := flag.String("trace-out", "", "-trace-out [file] trace file to write, default [output file]_trace.jsonl or .csv")
+	state := addStateFlags(flag.CommandLine)
 	flag.Parse() //flag.parse just makes things work
 
-	inFile, _ := os.Open(*cmdInFile) //*cmdInFile because we need to dereference it
-
-	//create a new array of instructions based on the data read from the inFile
-	var instructionsArray []Instruction = readFile(inFile)
-	initializeInstructions(instructionsArray) //initialize the instructions
-
-	printResults(instructionsArray, *cmdOutFile+"_dis.txt")
-
-	// begin simulation
-	simInstructions(instructionsArray, *cmdOutFile+"_sim.txt")
+	if *cmdResolve != "EX" && *cmdResolve != "ID" {
+		return 0, fmt.Errorf("-resolve must be EX or ID")
+	}
+	modes := 0
+	for _, on := range []bool{*cmdPipeline, *cmdOOO, *cmdWidth != 0} {
+		if on {
+			modes++
+		}
+	}
+	if modes > 1 {
+		return 0, fmt.Errorf("only one of -pipeline, -ooo and -width can be used")
+	}
+	checkpoints := *cmdSaveAt > 0 || *cmdSaveOnBreak || *cmdResume != ""
+	if checkpoints && modes > 0 {
+		return 0, fmt.Errorf("checkpoints only work without -pipeline, -ooo and -width")
+	}
+	if state.set() && *cmdResume != "" {
+		return 0, fmt.Errorf("-state, -reg and -mem can't be used with -resume")
+	}
+	if *cmdFormat != "full" && *cmdFormat != "reference" {
+		return 0, fmt.Errorf("-format must be full or reference")
+	}
+	if *cmdFormat == "reference" && modes > 0 {
+		return 0, fmt.Errorf("-format reference only works without -pipeline, -ooo and -width")
+	}
+	if *cmdWidth < 0 || *cmdMemPorts < 1 || *cmdLoadLatency < 1 {
+		return 0, fmt.Errorf("-width can't be negative and -memports and -loadlatency must be at least 1")
+	}
+	if *cmdMaxCycles < 0 || *cmdTimeout < 0 {
+		return 0, fmt.Errorf("-max-cycles and -timeout can't be negative")
+	}
+	if *cmdTrace != "" && *cmdTrace != "jsonl" && *cmdTrace != "csv" {
+		return 0, fmt.Errorf("-trace must be jsonl or csv")
+	}
+	if *cmdROB < 1 {
+		return 0, fmt.Errorf("-rob must be at least 1")
+	}
+	opts := options{
+		pipeline: *cmdPipeline,
+		ooo:      *cmdOOO,
+		state:    state,
+		limits:   limits{maxCycles: *cmdMaxCycles, timeout: *cmdTimeout, loops: *cmdLoops},
+		trace:    traceOptions{format: *cmdTrace, path: *cmdTraceOut},
+		checkpoint: checkpointOptions{
+			saveAt:      *cmdSaveAt,
+			saveOnBreak: *cmdSaveOnBreak,
+			path:        *cmdSave,
+			resume:      *cmdResume,
+		},
+		superscalarConfig: legv8.SuperscalarConfig{
+			Width:       *cmdWidth,
+			MemoryPorts: *cmdMemPorts,
+			LoadLatency: *cmdLoadLatency,
+		},
+		pipelineConfig: legv8.PipelineConfig{
+			Forwarding:  *cmdForwarding,
+			ResolveInID: *cmdResolve == "ID",
+		},
+	}
+	if *cmdFormat == "reference" {
+		opts.format = legv8.Reference
+	}
+	opts.tomasuloConfig = legv8.DefaultTomasuloConfig
+	opts.tomasuloConfig.ROBSize = *cmdROB
+	var err error
+	for _, class := range []struct {
+		name   string
+		spec   string
+		config *legv8.UnitConfig
+	}{
+		{"alu", *cmdALU, &opts.tomasuloConfig.ALU},
+		{"ls", *cmdLS, &opts.tomasuloConfig.LoadStore},
+		{"br", *cmdBR, &opts.tomasuloConfig.Branch},
+	} {
+		if *class.config, err = legv8.ParseUnitConfig(class.name, class.spec, *class.config); err != nil {
+			return 0, err
+		}
+	}
+	if opts.caches, err = cacheSystem(*cmdL1I, *cmdL1D, *cmdL2, *cmdMemLatency); err != nil {
+		return 0, err
+	}
+	if *cmdPredict != "" {
+		config, err := legv8.ParsePredictorConfig(*cmdPredict)
+		if err != nil {
+			return 0, err
+		}
+		if opts.branches, err = legv8.NewBranchUnit(config); err != nil {
+			return 0, err
+		}
+	}
+	exitCode, err := run(*cmdInFile, *cmdOutFile, opts)
+	if err != nil {
+		return 0, err
+	}
 
 	fmt.Println("infile:", *cmdInFile)
 	fmt.Println("outfile: ", *cmdOutFile+"_dis.txt")
 	fmt.Println("simulation outfile: ", *cmdOutFile+"_sim.txt")
+	if opts.trace.format != "" {
+		fmt.Println("trace outfile: ", opts.trace.file(*cmdOutFile))
+	}
+	return exitCode, nil
 }
 
-// reads the file and loads each line into the rawInstruction part of the Instruction
-func readFile(fileBeingRead io.Reader) (inputParsed []Instruction) {
-	index := 0
+// options are the simulation settings picked on the command line
+type options struct {
+	pipeline          bool // run the pipeline timing model instead of one instruction per cycle
+	pipelineConfig    legv8.PipelineConfig
+	ooo               bool // run the Tomasulo out of order timing model
+	tomasuloConfig    legv8.TomasuloConfig
+	superscalarConfig legv8.SuperscalarConfig // Width is 0 unless the superscalar model is picked
+	caches            *legv8.CacheSystem      // nil when no cache flags are given
+	branches          *legv8.BranchUnit       // nil when no predictor is picked
+	checkpoint        checkpointOptions
+	format            legv8.Format // what the _sim.txt of the default mode holds
+	state             *stateFlags  // initial registers and memory
+	limits            limits
+	trace             traceOptions
+}
 
-	// ^^ begins reading from the fileBeingRead (io.Reader is like an iostream) ...
-	// ... and prepares an array of Instructions that will be returned later
-	scanner := bufio.NewScanner(fileBeingRead) // "scanner" is a bufio.NewScanner of the file being read. ...
+// traceOptions pick the structured trace of retired instructions
+type traceOptions struct {
+	format string // one of legv8.TraceFormats, empty for no trace
+	path   string // empty for the default name
+}
 
-	// ...By default, a "Scan" terminates at new line.
-	for scanner.Scan() { // for each Scan (each line to read) in "scanner"
-		newInstruction := Instruction{rawInstruction: scanner.Text()} // creates a new Instruction and assigns...
-		// ...scanner.Text() to rawInstruction (scanner.Text() is the text of one line of the input file)
-		inputParsed = append(inputParsed, newInstruction) // add the newInstruction (containing the raw data) to the...
-		// ...array of instructions
+// file returns the path of the trace file for the output file name
+func (t traceOptions) file(outFileName string) string {
+	if t.path != "" {
+		return t.path
+	}
+	return outFileName + "_trace." + t.format
+}
 
-		// set the current memory value then increment by 4
-		inputParsed[index].programCnt = 96 + (4 * index)
-		index++
+// limits are what stops a program that does not reach BREAK on its own
+type limits struct {
+	maxCycles int           // 0 for no limit
+	timeout   time.Duration // 0 for no limit
+	loops     bool          // stop on a repeated machine state
+}
 
+// apply sets the limits on a machine, the returned cancel func releases the
+// timeout
+func (l limits) apply(m *legv8.Machine) context.CancelFunc {
+	m.MaxCycles = l.maxCycles
+	if l.loops {
+		m.Loops = legv8.NewLoopDetector()
 	}
-	fmt.Println(scanner.Text())
-	if err := scanner.Err(); err != nil {
-		fmt.Println(err)
+	if l.timeout == 0 {
+		return func() {}
 	}
-
-	return
+	ctx, cancel := context.WithTimeout(context.Background(), l.timeout)
+	m.Context = ctx
+	return cancel
 }
 
-// intialize all values of the struct array based on the line value
-// mask bits using with bitwise-AND value to hex value of mask
-// ie. "lineValue & 0xFF00" with a 32-bit mask 0xFF00 == 00000000000000001111111100000000
-// shift bits using >> operation
-func initializeInstructions(instArray []Instruction) {
-	for i := 0; i < len(instArray); i++ {
-		//the below converts 32 characters from a base 2 string to base 10 uint64
-
-		lineValue, _ := strconv.ParseUint(instArray[i].rawInstruction, 2, 32)
-
-		if lineValue > 335544320 || lineValue == 0 {
-			// assign lineValue and 11 bit opcode for setting the instruction
-			instArray[i].lineValue = lineValue
-			instArray[i].opcode = lineValue >> 21
-
-			setInstructionType(instArray, i)
-
-			// set values for instruction type "R" | opcode | Rm | Shamt | Rn | Rd |
-			if instArray[i].typeOfInstruction == "R" {
-				instArray[i].rn = uint8((lineValue & 0x3E0) >> 5)
-				instArray[i].rm = uint8((lineValue & 0x1F0000) >> 16)
-				instArray[i].rd = uint8(lineValue & 0x1F)
-				instArray[i].shamt = uint8((lineValue & 0xFC00) >> 11)
-			}
-
-			// set values for instruction type "D" | opcode | address | op2 | Rn | Rt |
-			if instArray[i].typeOfInstruction == "D" {
-				instArray[i].rn = uint8((lineValue & 0x3E0) >> 5)
-				instArray[i].address = uint8((lineValue & 0x1FF000) >> 12)
-				instArray[i].op2 = uint8((lineValue & 0xC00) >> 10)
-				instArray[i].rt = uint8(lineValue & 0x1F)
-			}
-
-			// set values for instruction type "I" | opcode | immediate | Rn | Rd |
-			if instArray[i].typeOfInstruction == "I" {
-				instArray[i].opcode = lineValue >> 22
-				instArray[i].rn = uint8((lineValue & 0x3E0) >> 5)
-				instArray[i].im = uint8(signedVariable(lineValue&0x3FFC00>>10, 12))
-				instArray[i].rd = uint8(lineValue & 0x1F)
-			}
-
-			// set values for instruction type "B" | opcode | offset |
-			if instArray[i].typeOfInstruction == "B" {
-				instArray[i].opcode = lineValue >> 26
-				instArray[i].offset = signedVariable(lineValue&0x3FFFFFF, 26)
-			}
-
-			// set values for instruction type "CB" (conditional B) | opcode | offset |
-			if instArray[i].typeOfInstruction == "CB" {
-				instArray[i].opcode = lineValue >> 24
-				instArray[i].offset = signedVariable(lineValue&0xFFFFE0>>5, 19)
-				instArray[i].conditional = uint8(lineValue & 0x1F)
-			}
-
-			// set values for instruction type "IM" | opcode | shift | field | Rd |
-			if instArray[i].typeOfInstruction == "IM" {
-				instArray[i].opcode = lineValue >> 23
-				instArray[i].shamt = uint8(lineValue & 0x600000 >> 21)
-				instArray[i].field = lineValue & 0x1FFFE0 >> 5
-				instArray[i].rd = uint8(lineValue & 0x1F)
-			}
-
-			if instArray[i].op == "BREAK" {
-				break
-			}
+// cacheSystem builds the caches from the -l1i, -l1d and -l2 flags, a flag
+// left empty leaves that cache out and "on" uses the default config
+func cacheSystem(l1i, l1d, l2 string, memLatency int) (*legv8.CacheSystem, error) {
+	if l1i == "" && l1d == "" && l2 == "" {
+		return nil, nil
+	}
+	var configs [3]*legv8.CacheConfig
+	for i, spec := range []string{l1i, l1d, l2} {
+		if spec == "" {
+			continue
 		}
+		if spec == "on" {
+			spec = ""
+		}
+		config, err := legv8.ParseCacheConfig([]string{"L1I", "L1D", "L2"}[i], spec)
+		if err != nil {
+			return nil, err
+		}
+		configs[i] = &config
 	}
+	return legv8.NewCacheSystem(configs[0], configs[1], configs[2], memLatency)
 }
 
-// check for signed variable to convert to negation using two's complement
-func signedVariable(value uint64, length int) int32 {
-	//var mask uint64 = 1 << (length - 1) // set mask to get sign
-	var temp = value >> (length - 1)
-
-	if temp == 1 { //
-		value = value | (0xFFFFFFFF << length)
-		//value = ^value + 1
+// run disassembles and simulates the program in inFileName, returning the
+// exit code of the program
+func run(inFileName string, outFileName string, opts options) (int, error) {
+	instructionsArray, _, err := loadProgram(inFileName)
+	if err != nil {
+		return 0, err
 	}
-	return int32(value)
-}
 
-// function that determines the type of instruction and what it is
-func setInstructionType(instrArray []Instruction, i int) {
-	var decimalOPC uint64 = instrArray[i].opcode
-	switch true { //switch defines a base case to test against.
-	case ((decimalOPC >= 160) && (decimalOPC <= 191)): //if case == switch, do stuff in that one and ignore other cases
-		instrArray[i].op = "B"
-		instrArray[i].typeOfInstruction = "B"
-	case (decimalOPC == 1104):
-		instrArray[i].op = "AND"
-		instrArray[i].typeOfInstruction = "R"
-	case (decimalOPC == 1112):
-		instrArray[i].op = "ADD"
-		instrArray[i].typeOfInstruction = "R"
-	case (decimalOPC >= 1160 && decimalOPC <= 1161):
-		instrArray[i].op = "ADDI"
-		instrArray[i].typeOfInstruction = "I"
-	case (decimalOPC == 1360):
-		instrArray[i].op = "ORR"
-		instrArray[i].typeOfInstruction = "R"
-	case (decimalOPC >= 1440 && decimalOPC <= 1447):
-		instrArray[i].op = "CBZ"
-		instrArray[i].typeOfInstruction = "CB"
-	case (decimalOPC >= 1448 && decimalOPC <= 1455):
-		instrArray[i].op = "CBNZ"
-		instrArray[i].typeOfInstruction = "CB"
-	case (decimalOPC == 1624):
-		instrArray[i].op = "SUB"
-		instrArray[i].typeOfInstruction = "R"
-	case (decimalOPC >= 1672 && decimalOPC <= 1673):
-		instrArray[i].op = "SUBI"
-		instrArray[i].typeOfInstruction = "I"
-	case (decimalOPC >= 1684 && decimalOPC <= 1687):
-		instrArray[i].op = "MOVZ"
-		instrArray[i].typeOfInstruction = "IM"
-	case (decimalOPC >= 1940 && decimalOPC <= 1943):
-		instrArray[i].op = "MOVK"
-		instrArray[i].typeOfInstruction = "IM"
-	case (decimalOPC == 1690):
-		instrArray[i].op = "LSR"
-		instrArray[i].typeOfInstruction = "R"
-	case (decimalOPC == 1691):
-		instrArray[i].op = "LSL"
-		instrArray[i].typeOfInstruction = "R"
-	case (decimalOPC == 1984):
-		instrArray[i].op = "STUR"
-		instrArray[i].typeOfInstruction = "D"
-	case (decimalOPC == 1986):
-		instrArray[i].op = "LDUR"
-		instrArray[i].typeOfInstruction = "D"
-	case (decimalOPC == 1692):
-		instrArray[i].op = "ASR"
-		instrArray[i].typeOfInstruction = "R"
-	case (decimalOPC == 1872):
-		instrArray[i].op = "EOR"
-		instrArray[i].typeOfInstruction = "R"
-	case decimalOPC == 0:
-		instrArray[i].op = "NOP"
-		instrArray[i].typeOfInstruction = "N/A"
-	case decimalOPC == 2038: //check for break
-		instrArray[i].op = "BREAK"
-		instrArray[i].typeOfInstruction = "BREAK"
-	default:
-		break
+	disFile, err := os.Create(outFileName + "_dis.txt")
+	if err != nil {
+		return 0, err
 	}
-}
-
-func printResults(instrArray []Instruction, fileName string) {
-
-	file, fileErr := os.Create(fileName)
-	if fileErr != nil {
-		fmt.Println(fileErr)
-	}
-	i := 0
-	count := 0
-	for instrArray[i].typeOfInstruction != "BREAK" { // loop through each array of structs
-		switch instrArray[i].typeOfInstruction {
-		// print results for R Type instructions == opcode (11 bits), Rm (5 bits), Shamt (6 bits), Rn (5 bits), Rd (5 bits)
-		case "R":
-			// print separated binary opcode
-			for j := 0; j < 32; j++ {
-				if j == 11 || j == 16 || j == 22 || j == 27 { // print spaces to separate
-					_, _ = file.WriteString(" ")
-				}
-				if j <= 10 { // print binary for opcode
-					_, _ = file.WriteString(string(instrArray[i].rawInstruction[j]))
-				} else if j >= 11 && j <= 15 { // print binary for Rm/R2
-					_, _ = file.WriteString(string(instrArray[i].rawInstruction[j]))
-				} else if j >= 16 && j <= 21 { // print binary for Shamt
-					_, _ = file.WriteString(string(instrArray[i].rawInstruction[j]))
-				} else if j >= 22 && j <= 26 { // print binary for Rn/R1
-					_, _ = file.WriteString(string(instrArray[i].rawInstruction[j]))
-				} else if j >= 27 && j <= 31 { // print binary for Rd/R3
-					_, _ = file.WriteString(string(instrArray[i].rawInstruction[j]))
-				}
-			}
-			_, _ = file.WriteString(" " + strconv.Itoa(instrArray[i].programCnt) + " " + instrArray[i].op + " R" +
-				strconv.Itoa(int(instrArray[i].rd)) + ", R" + strconv.Itoa(int(instrArray[i].rn)) +
-				", R" + strconv.Itoa(int(instrArray[i].rm))) // print pc, type, Rm, Shamt, Rn, Rd
-			break
-		// print results for D type instruction == opcode (11 bits), address (9 bits), op2 (2 bits), Rn (5 bits), Rt (5 bits)
-		case "D":
-			// print seperated binary opcode
-			for j := 0; j < 32; j++ {
-				if j == 11 || j == 20 || j == 22 || j == 27 {
-					_, _ = file.WriteString(" ")
-				}
-				if j <= 10 { // print binary for opcode
-					_, _ = file.WriteString(string(instrArray[i].rawInstruction[j]))
-				} else if j >= 11 && j <= 20 { // print binary for address
-					_, _ = file.WriteString(string(instrArray[i].rawInstruction[j]))
-				} else if j >= 21 && j <= 22 { // print binary for op2
-					_, _ = file.WriteString(string(instrArray[i].rawInstruction[j]))
-				} else if j >= 23 && j <= 27 { // print binary for Rn
-					_, _ = file.WriteString(string(instrArray[i].rawInstruction[j]))
-				} else if j >= 28 && j <= 31 { // print binary for Rt
-					_, _ = file.WriteString(string(instrArray[i].rawInstruction[j]))
-				}
-			}
-			_, _ = file.WriteString(" " + strconv.Itoa(instrArray[i].programCnt) + " " + instrArray[i].op + " R" +
-				strconv.Itoa(int(instrArray[i].rt)) + ", [R" + strconv.Itoa(int(instrArray[i].rn)) + ", #" +
-				strconv.Itoa(int(instrArray[i].address)) + "]") // print pc, type, Rt, Rn, address
-			break
-		// print results for I type instruction == opcode (10 bits), immediate (12 bits), Rn (5 bits), Rd (5 bits)
-		case "I":
-			// print separated binary opcode
-			for j := 0; j < 32; j++ {
-				if j == 10 || j == 22 || j == 27 {
-					_, _ = file.WriteString(" ")
-				}
-				if j <= 9 { // print binary for opcode
-					_, _ = file.WriteString(string(instrArray[i].rawInstruction[j]))
-				} else if j >= 10 && j <= 21 { // print binary for immediate
-					_, _ = file.WriteString(string(instrArray[i].rawInstruction[j]))
-				} else if j >= 22 && j <= 26 { // print binary for Rn
-					_, _ = file.WriteString(string(instrArray[i].rawInstruction[j]))
-				} else if j >= 27 && j <= 31 { // print binary for Rd
-					_, _ = file.WriteString(string(instrArray[i].rawInstruction[j]))
-				}
-			}
-			_, _ = file.WriteString(" " + strconv.Itoa(instrArray[i].programCnt) + " " + instrArray[i].op + " R" +
-				strconv.Itoa(int(instrArray[i].rd)) + ", R" + strconv.Itoa(int(instrArray[i].rn)) +
-				", #" + strconv.Itoa(int(instrArray[i].im))) // print pc, type, Rd, rn, im
-			break
-		// print results for B type instruction == opcode (6 bits), offset (26 bits)
-		case "B":
-			// print separated binary code
-			for j := 0; j < 32; j++ {
-				if j == 6 {
-					_, _ = file.WriteString(" ")
-				}
-				if j <= 6 { // print binary for opcode
-					_, _ = file.WriteString(string(instrArray[i].rawInstruction[j]))
-				} else if j >= 7 && j <= 31 { // print binary for offset
-					_, _ = file.WriteString(string(instrArray[i].rawInstruction[j]))
-				}
-			}
-			_, _ = file.WriteString(" " + strconv.Itoa(instrArray[i].programCnt) + " " + instrArray[i].op + " #" +
-				strconv.Itoa(int(instrArray[i].offset))) // print pc, type, offset
-			break
-		// print results for CB type instructions == opcode (8 bits), offset (19 bits), conditional (5 bits)
-		case "CB":
-			// print separated binary code
-			for j := 0; j < 32; j++ {
-				if j == 8 || j == 27 {
-					_, _ = file.WriteString(" ")
-				}
-				if j <= 8 { // print binary for opcode
-					_, _ = file.WriteString(string(instrArray[i].rawInstruction[j]))
-				} else if j >= 8 && j <= 26 { // print binary for offset
-					_, _ = file.WriteString(string(instrArray[i].rawInstruction[j]))
-				} else if j >= 27 && j <= 31 { // print binary for conditional
-					_, _ = file.WriteString(string(instrArray[i].rawInstruction[j]))
-				}
-			}
-			_, _ = file.WriteString(" " + strconv.Itoa(instrArray[i].programCnt) + " " + instrArray[i].op + " R" +
-				strconv.Itoa(int(instrArray[i].conditional)) + ", " + strconv.Itoa(int(instrArray[i].offset)))
-			break
-		// print results for IM type instructions == opcode (9 bits), shift code (2 bits), field (16 bits), Rd (5 bits)
-		case "IM":
-			// print seperated binary code
-			for j := 0; j < 32; j++ {
-				if j == 9 || j == 11 || j == 27 {
-					_, _ = file.WriteString(" ")
-				}
-				if j <= 9 { // print binary for opcode
-					_, _ = file.WriteString(string(instrArray[i].rawInstruction[j]))
-				} else if j >= 10 && j <= 11 { // print binary for shift code
-					_, _ = file.WriteString(string(instrArray[i].rawInstruction[j]))
-				} else if j >= 12 && j <= 27 { // print binary for field
-					_, _ = file.WriteString(string(instrArray[i].rawInstruction[j]))
-				} else if j >= 28 && j <= 31 {
-					_, _ = file.WriteString(string(instrArray[i].rawInstruction[j]))
-				}
-			}
-			_, _ = file.WriteString(" " + strconv.Itoa(instrArray[i].programCnt) + " " + instrArray[i].op + " R" +
-				strconv.Itoa(int(instrArray[i].rd)) + ", " + strconv.Itoa(int(instrArray[i].field)) + ", LSL " +
-				strconv.Itoa(int(instrArray[i].shamt)))
-			break
-		case "N/A":
-			_, _ = file.WriteString(instrArray[i].rawInstruction + " " +
-				strconv.Itoa(instrArray[i].programCnt) + " " + "NOP")
-			break
-		default:
-			println("Invalid value on line " + strconv.Itoa(i))
-		}
-
-		_, _ = file.WriteString("\n")
-		i++
+	defer disFile.Close()
+	if err := legv8.PrintResults(disFile, instructionsArray); err != nil {
+		fmt.Fprintln(os.Stderr, err)
 	}
-	_, _ = file.WriteString(instrArray[i].rawInstruction + " " + strconv.Itoa(instrArray[i].programCnt) + " BREAK\n")
-	for i = i + 1; i < len(instrArray); i++ {
 
-		lineValue, _ := strconv.ParseUint(instrArray[i].rawInstruction, 2, 32)
-		count--
-		_, _ = file.WriteString(instrArray[i].rawInstruction + " " + strconv.Itoa(instrArray[i].programCnt) +
-			" " + strconv.Itoa(int(signedVariable(lineValue, 32))) + "\n")
-		dataSlice[instrArray[i].programCnt] = int(signedVariable(lineValue, 32))
+	// begin simulation
+	simFile, err := os.Create(outFileName + "_sim.txt")
+	if err != nil {
+		return 0, err
 	}
-}
+	defer simFile.Close()
 
-// simulation functions
-func simInstructions(instrArray []Instruction, fileName string) {
-	// run function to decide outcome then assign based on cycle
-
-	// initialize all keys of map and set to 0 (empty registers)
-	for j := 0; j < 32; j++ {
-		registerMap[uint8(j)] = 0
+	machine, err := newMachine(instructionsArray)
+	if err != nil {
+		return 0, err
 	}
-
-	cycle := 0 // initiliaze cycle
-
-	// create the file and keep open until the loop closes
-	file, fileErr := os.Create(fileName)
-	if fileErr != nil {
-		fmt.Println(fileErr)
+	machine.Caches = opts.caches
+	machine.Branches = opts.branches
+	machine.Output, machine.Input = os.Stdout, os.Stdin
+	if err := opts.state.apply(machine); err != nil {
+		return 0, err
 	}
-
-	// as long as instruction is not break, loop through all cycles
-	i := 0
-	for instrArray[i].typeOfInstruction != "BREAK" {
-		count := 1
-		switch instrArray[i].op {
-		// R format instructions
-		case "SUB": // 	rd = rn - rm
-			registerMap[instrArray[i].rd] = registerMap[instrArray[i].rn] - registerMap[instrArray[i].rm]
-			break
-		case "AND": // rd = rm & rn
-			registerMap[instrArray[i].rd] = registerMap[instrArray[i].rn] & registerMap[instrArray[i].rm]
-			break
-		case "ADD": // rd = rm + rn
-			registerMap[instrArray[i].rd] = registerMap[instrArray[i].rn] + registerMap[instrArray[i].rm]
-			break
-		case "ORR": // rd = rm | rn
-			registerMap[instrArray[i].rd] = registerMap[instrArray[i].rn] | registerMap[instrArray[i].rm]
-			break
-		case "EOR": // rd = rm ^ rn
-			registerMap[instrArray[i].rd] = registerMap[instrArray[i].rn] ^ registerMap[instrArray[i].rm]
-			break
-		case "LSR": // rn shifted shamt
-			registerMap[instrArray[i].rd] = registerMap[instrArray[i].rn] >> registerMap[instrArray[i].shamt]
-			break
-		case "LSL": // rd = rn << shamt
-			registerMap[instrArray[i].rd] = (registerMap[instrArray[i].rn]) << registerMap[instrArray[i].shamt]
-			break
-		case "ASR": // rd = rn >> shamt pad with sign bit
-			registerMap[instrArray[i].rd] = registerMap[instrArray[i].rn] >> registerMap[instrArray[i].shamt]
-			break
-
-		// D format instructions
-		case "LDUR":
-			registerMap[instrArray[i].rt] = dataSlice[registerMap[instrArray[i].rn]+int(instrArray[i].address)*4]
-			break
-		case "STUR":
-			dataSlice[registerMap[instrArray[i].rn]+int(instrArray[i].address)*4] = registerMap[instrArray[i].rt]
-			break
-
-		// I format instructions
-		case "ADDI": // rd = rn + im
-			registerMap[instrArray[i].rd] = registerMap[instrArray[i].rn] + int(instrArray[i].im)
-			break
-		case "SUBI": // rd = rn - im
-			registerMap[instrArray[i].rd] = registerMap[instrArray[i].rn] - int(instrArray[i].im)
-			break
-
-		// B and CB format instructions
-		case "B": // PC = PC +- (4 * offset)
-			count = int(instrArray[i].offset)
-			break
-		case "CBZ": // if (conditional == 0) {PC = 4 * offset}
-			if instrArray[i].conditional == 0 {
-				count = int(instrArray[i].offset)
-			}
-			break
-		case "CBNZ": // if (conditional == 1) {PC = 4 * offset}
-			if instrArray[i].conditional != 0 {
-				count = int(instrArray[i].offset)
-			}
-			break
-
-		// IM format instructions
-		case "MOVZ":
-			registerMap[instrArray[i].rd] = 0
-			registerMap[instrArray[i].rd] = int(instrArray[i].field<<(instrArray[i].shamt*16)) &
-				(0xFFFFFFFF << (instrArray[i].shamt * 16))
-			break
-		case "MOVK":
-			registerMap[instrArray[i].rd] = registerMap[instrArray[i].rd] +
-				int(instrArray[i].field<<(instrArray[i].shamt*16))
-			break
-		case "NOP":
-			break
+	defer opts.limits.apply(machine)()
+	if opts.trace.format != "" {
+		traceFile, err := os.Create(opts.trace.file(outFileName))
+		if err != nil {
+			return 0, err
+		}
+		defer traceFile.Close()
+		if machine.Trace, err = legv8.NewTraceWriter(opts.trace.format, traceFile); err != nil {
+			return 0, err
 		}
-
-		cycle++                              // increment cycle
-		instrArray[i].cycle = cycle          // assign cycle to struct
-		printSimulation(instrArray[i], file) // print current struct simulation
-		i = i + count                        // increment loop counter
 	}
-	if instrArray[i].typeOfInstruction == "BREAK" {
-		cycle++
-		instrArray[i].cycle = cycle
-		printSimulation(instrArray[i], file)
+	if opts.checkpoint.resume != "" {
+		if err := resume(machine, opts.checkpoint.resume); err != nil {
+			return 0, err
+		}
 	}
-}
-
-func printSimulation(sim Instruction, f *os.File) {
-
-	fmt.Fprintln(f, "====================")
-	fmt.Fprintf(f, "Cycle:%d\t%d\t%s\n", sim.cycle, sim.programCnt, instructionString(sim))
-
-	// print current register
-	fmt.Fprint(f, "\nRegisters:\n")
-	fmt.Fprintf(f, "r00:\t%s", mapToString(registerMap, 8))
-	fmt.Fprintf(f, "\nr08:\t%s", mapToString(registerMap, 16))
-	fmt.Fprintf(f, "\nr16:\t%s", mapToString(registerMap, 24))
-	fmt.Fprintf(f, "\nr24:\t%s\n", mapToString(registerMap, 32))
-
-	// print data
-	fmt.Fprintf(f, "\nData:")
-	var keys []int
-	max := 0
-	for i, _ := range dataSlice {
-		keys = append(keys, i)
-	}
-	sort.Ints(keys) // sort data index using a temp array
-	for i, _ := range keys {
-		max = i
-	}
-	// iterate through the index  array and use to print data
-	if keys != nil {
-		for key := keys[0]; key <= keys[max]; key = key + 4 {
-			if (key-keys[0])%32 == 0 {
-				fmt.Fprintf(f, "\n%d:\t", key)
-				for i := 0; i < 32; i = i + 4 {
-					if dataSlice[key+i] != 0 {
-						fmt.Fprintf(f, "%d\t", dataSlice[key+i])
-					} else {
-						dataSlice[key+i] = 0
-						fmt.Fprintf(f, "%d\t", dataSlice[key+i])
-					}
-				}
-			}
-
+	switch {
+	case opts.pipeline:
+		err = legv8.NewPipeline(machine, opts.pipelineConfig).Run(simFile)
+	case opts.ooo:
+		err = legv8.NewTomasulo(machine, opts.tomasuloConfig).Run(simFile)
+	case opts.superscalarConfig.Width > 0:
+		err = legv8.NewSuperscalar(machine, opts.superscalarConfig).Run(simFile)
+	default:
+		var saveErr error
+		err = legv8.SimInstructions(machine, simFile, opts.format, opts.checkpoint.saver(outFileName, &saveErr))
+		if err == nil {
+			err = saveErr
 		}
 	}
+	// a program that stopped on an error still gets the trace up to there
+	if machine.Trace != nil {
+		if traceErr := machine.Trace.Flush(); err == nil {
+			err = traceErr
+		}
+	}
+	return machine.ExitCode, err
+}
 
-	fmt.Fprintf(f, "\n")
-
+// newMachine returns a machine loaded with the program, with the default
+// devices mapped
+func newMachine(instructionsArray []legv8.Instruction) (*legv8.Machine, error) {
+	machine := legv8.NewMachine()
+	if err := machine.MapDefaultDevices(); err != nil {
+		return nil, err
+	}
+	machine.Load(instructionsArray)
+	return machine, nil
 }
 
-func instructionString(sim Instruction) string {
-	switch sim.typeOfInstruction {
-	case "R":
-		switch sim.op {
-		case "LSL", "LSR", "ASR":
-			return fmt.Sprintf("%s\tR%d, R%d, #%d", sim.op, sim.rd, sim.rm, sim.shamt)
-		default:
-			return fmt.Sprintf("%s\tR%d, R%d, R%d", sim.op, sim.rd, sim.rm, sim.rn)
-		}
-	case "I":
-		return fmt.Sprintf("%s\tR%d, R%d, #%d", sim.op, sim.rd, sim.rn, sim.im)
-	case "D":
-		return fmt.Sprintf("%s\tR%d, [R%d, #%d]", sim.op, sim.rt, sim.rn, sim.address)
-	case "B":
-		return fmt.Sprintf("%s\t #%d", sim.op, sim.offset)
-	case "CB":
-		return fmt.Sprintf("%s\tR%d, #%d", sim.op, sim.conditional, sim.offset)
-	case "IM":
-		return fmt.Sprintf("%s\tR%d, %d, LSL %d", sim.op, sim.rd, sim.field, sim.shamt*16)
-	default:
-		return fmt.Sprintf("%s\t", sim.op)
+// loadProgram reads and decodes a program. Files ending in .s or .asm are
+// assembled first and their labels returned, for binary files labels is nil.
+func loadProgram(fileName string) (instructionsArray []legv8.Instruction, labels map[string]int, err error) {
+	inFile, err := os.Open(fileName)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer inFile.Close()
 
+	var source io.Reader = inFile
+	if ext := filepath.Ext(fileName); ext == ".s" || ext == ".asm" {
+		lines, asmLabels, err := legv8.AssembleWithLabels(inFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", fileName, err)
+		}
+		source, labels = strings.NewReader(strings.Join(lines, "\n")), asmLabels
 	}
-}
 
-func mapToString(arr map[uint8]int, highValue uint8) string {
-	var str = ""
-	var i uint8
-	for i = highValue - 8; i < highValue; i++ {
-		str = str + strconv.Itoa(arr[i]) + "\t"
+	//create a new array of instructions based on the data read from the inFile
+	instructionsArray, err = legv8.ReadFile(source)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := legv8.InitializeInstructions(instructionsArray); err != nil { //initialize the instructions
+		return nil, nil, err
 	}
-	return str
+	return instructionsArray, labels, nil
 }
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"Project2_Team10/legv8"
+)
+
+// asmMain runs the asm mode: assemble a LEGv8 source file into the binary
+// string format the disassembler and simulator read.
+func asmMain(args []string) error {
+	flags := flag.NewFlagSet("asm", flag.ExitOnError)
+	cmdInFile := flags.String("i", "", "-i [assembly file path/name]")
+	cmdOutFile := flags.String("o", "", "-o [output file path/name], standard output if left out")
+	_ = flags.Parse(args)
+
+	if *cmdInFile == "" {
+		return fmt.Errorf("asm: -i is required")
+	}
+	inFile, err := os.Open(*cmdInFile)
+	if err != nil {
+		return err
+	}
+	defer inFile.Close()
+
+	lines, err := legv8.Assemble(inFile)
+	if err != nil {
+		return fmt.Errorf("%s: %v", *cmdInFile, err)
+	}
+	text := strings.Join(lines, "\n") + "\n"
+
+	if *cmdOutFile == "" {
+		_, err = os.Stdout.WriteString(text)
+		return err
+	}
+	return os.WriteFile(*cmdOutFile, []byte(text), 0644)
+}
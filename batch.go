@@ -0,0 +1,312 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"Project2_Team10/legv8"
+)
+
+// batchResult is the summary line of one program in a batch
+type batchResult struct {
+	Name       string  `json:"name"`
+	Status     string  `json:"status"` // pass, fail, timeout (or an infinite loop) or error
+	Cycles     int     `json:"cycles"`
+	DisDiff    int     `json:"dis_diff"`   // lines that differ from the reference _dis.txt, -1 without one
+	SimDiff    int     `json:"sim_diff"`   // lines that differ from the reference _sim.txt, -1 without one
+	Mismatches int     `json:"mismatches"` // expectations not met, -1 without an _expect file
+	Seconds    float64 `json:"seconds"`
+	Error      string  `json:"error,omitempty"`
+}
+
+// batchOptions are the batch flags every run shares
+type batchOptions struct {
+	refDir    string // where the reference outputs are
+	outDir    string // where to write the outputs of each run, empty for a temporary file
+	timeout   time.Duration
+	maxOutput int64 // bytes of _sim.txt a run can write before it is stopped
+}
+
+// batchMain runs the batch mode: disassemble and simulate every *_bin.txt
+// file in a directory on a pool of workers and compare each with its
+// reference outputs, <name>_dis.txt and <name>_sim.txt, and with
+// <name>_expect.json when there is one. The exit status is 1 unless
+// every program passes.
+func batchMain(args []string) (int, error) {
+	flags := flag.NewFlagSet("batch", flag.ExitOnError)
+	cmdDir := flags.String("dir", ".", "-dir [directory] of *_bin.txt files to run")
+	cmdRef := flags.String("ref", "", "-ref [directory] of reference outputs, default the -dir directory")
+	cmdOut := flags.String("out", "", "-out [directory] to write each run's _dis.txt and _sim.txt to")
+	cmdJobs := flags.Int("j", runtime.NumCPU(), "-j [workers] programs run at the same time")
+	cmdTimeout := flags.Duration("timeout", 10*time.Second, "-timeout [duration] stop a program that runs longer")
+	cmdMaxOutput := flags.Int64("max-output", 64<<20, "-max-output [bytes] stop a program whose _sim.txt grows past this")
+	cmdFormat := flags.String("format", "csv", "-format [csv|json] of the summary")
+	cmdSummary := flags.String("summary", "", "-summary [file] to write the summary to, default standard output")
+	_ = flags.Parse(args)
+
+	if *cmdFormat != "csv" && *cmdFormat != "json" {
+		return 0, fmt.Errorf("-format must be csv or json")
+	}
+	if *cmdJobs < 1 || *cmdMaxOutput < 1 {
+		return 0, fmt.Errorf("-j and -max-output must be at least 1")
+	}
+	opts := batchOptions{refDir: *cmdRef, outDir: *cmdOut, timeout: *cmdTimeout, maxOutput: *cmdMaxOutput}
+	if opts.refDir == "" {
+		opts.refDir = *cmdDir
+	}
+	if opts.outDir != "" {
+		if err := os.MkdirAll(opts.outDir, 0o755); err != nil {
+			return 0, err
+		}
+	}
+	files, err := filepath.Glob(filepath.Join(*cmdDir, "*_bin.txt"))
+	if err != nil {
+		return 0, err
+	}
+	if len(files) == 0 {
+		return 0, fmt.Errorf("no *_bin.txt files in %s", *cmdDir)
+	}
+
+	results := make([]batchResult, len(files))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < *cmdJobs; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				start := time.Now()
+				results[i] = opts.grade(files[i])
+				results[i].Seconds = time.Since(start).Seconds()
+			}
+		}()
+	}
+	for i := range files {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	sort.Slice(results, func(i, j int) bool { return results[i].Name < results[j].Name })
+
+	var out io.Writer = os.Stdout
+	if *cmdSummary != "" {
+		file, err := os.Create(*cmdSummary)
+		if err != nil {
+			return 0, err
+		}
+		defer file.Close()
+		out = file
+	}
+	if *cmdFormat == "json" {
+		err = writeBatchJSON(out, results)
+	} else {
+		err = writeBatchCSV(out, results)
+	}
+	if err != nil {
+		return 0, err
+	}
+	for _, r := range results {
+		if r.Status != "pass" {
+			return 1, nil
+		}
+	}
+	return 0, nil
+}
+
+// grade runs one program and compares it with its references. Every run
+// has a machine of its own, so runs share nothing.
+func (opts batchOptions) grade(path string) batchResult {
+	name := programName(filepath.Base(path))
+	r := batchResult{Name: name, Status: "pass", DisDiff: -1, SimDiff: -1, Mismatches: -1}
+	fail := func(status string, err error) batchResult {
+		r.Status, r.Error = status, err.Error()
+		return r
+	}
+
+	instructionsArray, _, err := loadProgram(path)
+	if err != nil {
+		return fail("error", err)
+	}
+	var dis, output bytes.Buffer
+	if err := legv8.PrintResults(&dis, instructionsArray); err != nil {
+		return fail("error", err)
+	}
+	machine, err := newMachine(instructionsArray)
+	if err != nil {
+		return fail("error", err)
+	}
+
+	// the simulation goes straight to a file, it can be far too big to keep
+	var file *os.File
+	if opts.outDir != "" {
+		base := filepath.Join(opts.outDir, name)
+		if err := os.WriteFile(base+"_dis.txt", dis.Bytes(), 0o644); err != nil {
+			return fail("error", err)
+		}
+		file, err = os.Create(base + "_sim.txt")
+	} else {
+		file, err = os.CreateTemp("", name+"_*_sim.txt")
+		if err == nil {
+			defer os.Remove(file.Name())
+		}
+	}
+	if err != nil {
+		return fail("error", err)
+	}
+	defer file.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), opts.timeout)
+	defer cancel()
+	sim := &cappedWriter{w: bufio.NewWriter(file), left: opts.maxOutput, cancel: cancel}
+	machine.Context = ctx
+	machine.Loops = legv8.NewLoopDetector()
+	machine.Output = &output
+	runErr := legv8.SimInstructions(machine, sim, legv8.Reference)
+	r.Cycles = machine.Cycle
+	if err := sim.w.Flush(); err != nil {
+		return fail("error", err)
+	}
+
+	if sim.over {
+		return fail("error", fmt.Errorf("_sim.txt grew past %d bytes, see -max-output", opts.maxOutput))
+	}
+	var loop *legv8.LoopError
+	if errors.Is(runErr, context.DeadlineExceeded) || errors.As(runErr, &loop) {
+		return fail("timeout", runErr)
+	}
+	simData, err := os.ReadFile(file.Name())
+	if err != nil {
+		return fail("error", err)
+	}
+
+	ref := filepath.Join(opts.refDir, name)
+	compared := false
+	for _, c := range []struct {
+		suffix string
+		got    []byte
+		diff   *int
+	}{
+		{"_dis.txt", dis.Bytes(), &r.DisDiff},
+		{"_sim.txt", simData, &r.SimDiff},
+	} {
+		want, err := os.ReadFile(ref + c.suffix)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return fail("error", err)
+		}
+		*c.diff = diffSize(lines(want), lines(c.got))
+		compared = true
+	}
+	if expect, err := readExpectations(ref + "_expect.json"); err == nil {
+		r.Mismatches = len(expect.Check(machine, runErr, output.String()))
+		compared = true
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return fail("error", err)
+	}
+	if !compared {
+		return fail("error", fmt.Errorf("no reference outputs or expectations for %s", name))
+	}
+	if r.DisDiff > 0 || r.SimDiff > 0 || r.Mismatches > 0 {
+		r.Status = "fail"
+	}
+	return r
+}
+
+// lines splits output into lines with every run of white space in them
+// made one space, so tabs and trailing spaces don't count as differences
+func lines(data []byte) []string {
+	text := strings.ReplaceAll(string(data), "\r\n", "\n")
+	split := strings.Split(strings.TrimRight(text, "\n"), "\n")
+	for i, line := range split {
+		split[i] = strings.Join(strings.Fields(line), " ")
+	}
+	return split
+}
+
+// cappedWriter passes at most left bytes on to w. Anything past that is
+// dropped and cancels the run, so a program stuck printing stops early.
+type cappedWriter struct {
+	w      *bufio.Writer
+	left   int64
+	over   bool
+	cancel context.CancelFunc
+}
+
+func (c *cappedWriter) Write(p []byte) (int, error) {
+	if int64(len(p)) <= c.left {
+		c.left -= int64(len(p))
+		return c.w.Write(p)
+	}
+	n, _ := c.w.Write(p[:c.left])
+	c.left = 0
+	if !c.over {
+		c.over = true
+		c.cancel()
+	}
+	return n, io.ErrShortWrite
+}
+
+// diffSize returns how many lines a diff between a and b adds and removes.
+// It is Myers' greedy algorithm, which takes time in proportion to the
+// lines times the size of the diff, so outputs that nearly match are cheap.
+// v[offset+k] is the furthest x reached on diagonal k = x-y.
+func diffSize(a, b []string) int {
+	n, m := len(a), len(b)
+	offset := n + m + 1
+	v := make([]int, 2*offset+1)
+	for d := 0; d <= n+m; d++ {
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || k != d && v[offset+k-1] < v[offset+k+1] {
+				x = v[offset+k+1] // down: a line of b is added
+			} else {
+				x = v[offset+k-1] + 1 // right: a line of a is removed
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x, y = x+1, y+1
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				return d
+			}
+		}
+	}
+	return n + m
+}
+
+// writeBatchCSV writes the summary with a header row
+func writeBatchCSV(w io.Writer, results []batchResult) error {
+	out := csv.NewWriter(w)
+	_ = out.Write([]string{"name", "status", "cycles", "dis_diff", "sim_diff", "mismatches", "seconds", "error"})
+	for _, r := range results {
+		_ = out.Write([]string{r.Name, r.Status, strconv.Itoa(r.Cycles), strconv.Itoa(r.DisDiff), strconv.Itoa(r.SimDiff),
+			strconv.Itoa(r.Mismatches), strconv.FormatFloat(r.Seconds, 'f', 3, 64), r.Error})
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// writeBatchJSON writes the summary as one indented JSON array
+func writeBatchJSON(w io.Writer, results []batchResult) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(results)
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"Project2_Team10/legv8"
+)
+
+// TestBatchGrade grades addtest1 against the output of the reference
+// simulator, with its white space changed the way hand made references are
+func TestBatchGrade(t *testing.T) {
+	dir := t.TempDir()
+	files := []struct{ from, to string }{
+		{"addtest1_bin.txt", "addtest1_bin.txt"},
+		{"addtest1.dis", "addtest1_dis.txt"},
+		{"addtest1_reference.sim", "addtest1_sim.txt"},
+	}
+	for _, f := range files {
+		data, err := os.ReadFile(filepath.Join("legv8", "testdata", f.from))
+		if err != nil {
+			t.Fatal(err)
+		}
+		text := string(data)
+		if f.from != f.to {
+			text = strings.ReplaceAll(text, "\t", "  ")
+			text = strings.ReplaceAll(text, "\n", " \r\n")
+		}
+		if err := os.WriteFile(filepath.Join(dir, f.to), []byte(text), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	opts := batchOptions{refDir: dir, timeout: 10 * time.Second, maxOutput: 1 << 20}
+	r := opts.grade(filepath.Join(dir, "addtest1_bin.txt"))
+	if r.Status != "pass" || r.DisDiff != 0 || r.SimDiff != 0 || r.Mismatches != -1 {
+		t.Errorf("result is %+v, want a pass with no differences", r)
+	}
+
+	// one wrong register value is one line removed and one added
+	sim := filepath.Join(dir, "addtest1_sim.txt")
+	data, err := os.ReadFile(sim)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrong := strings.Replace(string(data), "r00:  65550  ", "r00:  65551  ", 1)
+	if err := os.WriteFile(sim, []byte(wrong), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if r := opts.grade(filepath.Join(dir, "addtest1_bin.txt")); r.Status != "fail" || r.SimDiff != 2 {
+		t.Errorf("result is %+v, want a fail with 2 lines of difference", r)
+	}
+}
+
+// TestBatchLoop grades a program that never reaches BREAK as a timeout as
+// soon as it goes around its loop, without waiting for the timeout
+func TestBatchLoop(t *testing.T) {
+	dir := t.TempDir()
+	lines, err := legv8.Assemble(strings.NewReader("loop: NOP\nB loop\nBREAK"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "loop_bin.txt")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	opts := batchOptions{timeout: time.Minute, maxOutput: 1 << 20}
+	start := time.Now()
+	r := opts.grade(path)
+	if r.Status != "timeout" || !strings.Contains(r.Error, "infinite loop") || time.Since(start) > 10*time.Second {
+		t.Errorf("result is %+v, want a timeout for the infinite loop", r)
+	}
+}
+
+// lcsDiff is the edit distance the slow way: lines of a and b that are not
+// in their longest common subsequence
+func lcsDiff(a, b []string) int {
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			switch {
+			case a[i] == b[j]:
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			case lcs[i+1][j] > lcs[i][j+1]:
+				lcs[i][j] = lcs[i+1][j]
+			default:
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	return len(a) + len(b) - 2*lcs[0][0]
+}
+
+func TestDiffSize(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"a b c", "a b c", 0},
+		{"a b c", "", 3},
+		{"", "a b", 2},
+		{"a b c", "a c", 1},
+		{"a c", "a b c", 1},
+		{"a b c", "a x c", 2},
+		{"a b c d", "d c b a", 6},
+		{"x a b c", "a b c x", 2},
+	}
+	for _, test := range tests {
+		a, b := strings.Fields(test.a), strings.Fields(test.b)
+		if got := diffSize(a, b); got != test.want {
+			t.Errorf("diffSize(%q, %q) = %d, want %d", test.a, test.b, got, test.want)
+		}
+	}
+
+	rng := rand.New(rand.NewSource(1))
+	lines := func() []string {
+		out := make([]string, rng.Intn(30))
+		for i := range out {
+			out[i] = string(rune('a' + rng.Intn(4)))
+		}
+		return out
+	}
+	for i := 0; i < 500; i++ {
+		a, b := lines(), lines()
+		if got, want := diffSize(a, b), lcsDiff(a, b); got != want {
+			t.Fatalf("diffSize(%q, %q) = %d, want %d", a, b, got, want)
+		}
+	}
+}
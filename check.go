@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"Project2_Team10/legv8"
+)
+
+// checkMain runs the check mode: run a program and grade its final state
+// against an expectations file. The exit status is 1 when anything differs.
+func checkMain(args []string) (int, error) {
+	flags := flag.NewFlagSet("check", flag.ExitOnError)
+	cmdInFile := flags.String("i", "addtest1_bin.txt", "-i [input file path/name], .s and .asm files are assembled first")
+	cmdExpect := flags.String("e", "", "-e [file] JSON expectations, default [program]_expect.json for [program]_bin.txt or [program].s")
+	cmdLimit := flags.Int("limit", 100000, "-limit [cycles] stop a program that runs longer, when the expectations give no max_cycles")
+	state := addStateFlags(flags)
+	_ = flags.Parse(args)
+
+	expectFile := *cmdExpect
+	if expectFile == "" {
+		expectFile = programName(*cmdInFile) + "_expect.json"
+	}
+	expect, err := readExpectations(expectFile)
+	if err != nil {
+		return 0, err
+	}
+	instructionsArray, _, err := loadProgram(*cmdInFile)
+	if err != nil {
+		return 0, err
+	}
+	machine, err := newMachine(instructionsArray)
+	if err != nil {
+		return 0, err
+	}
+	var output bytes.Buffer
+	machine.Output, machine.Input = &output, os.Stdin
+	machine.Loops = legv8.NewLoopDetector()
+	if err := state.apply(machine); err != nil {
+		return 0, err
+	}
+
+	runErr := expect.Run(machine, *cmdLimit)
+	mismatches := expect.Check(machine, runErr, output.String())
+	printCheck(os.Stdout, *cmdInFile, machine, mismatches)
+	if len(mismatches) > 0 {
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// programName strips _bin.txt or the extension off a program file name
+func programName(fileName string) string {
+	if strings.HasSuffix(fileName, "_bin.txt") {
+		return strings.TrimSuffix(fileName, "_bin.txt")
+	}
+	return strings.TrimSuffix(fileName, filepath.Ext(fileName))
+}
+
+// readExpectations reads the expectations file at path
+func readExpectations(path string) (*legv8.Expectations, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	expect, err := legv8.ReadExpectations(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return expect, nil
+}
+
+// printCheck writes the pass or fail line of a program and what differed
+func printCheck(w io.Writer, name string, m *legv8.Machine, mismatches []legv8.Mismatch) {
+	if len(mismatches) == 0 {
+		fmt.Fprintf(w, "PASS\t%s\t%d cycles\n", name, m.Cycle)
+		return
+	}
+	fmt.Fprintf(w, "FAIL\t%s\t%d cycles, mismatches: %d\n", name, m.Cycle, len(mismatches))
+	for _, mm := range mismatches {
+		fmt.Fprintf(w, "\t%s\n", mm)
+	}
+}
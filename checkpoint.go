@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"Project2_Team10/legv8"
+)
+
+// checkpointOptions are the checkpoint flags of the default mode
+type checkpointOptions struct {
+	saveAt      int    // cycle to save after, 0 for none
+	saveOnBreak bool   // save once BREAK retires
+	path        string // file to save to, empty for the default name
+	resume      string // checkpoint to start from, empty to start from the beginning
+}
+
+// saver returns a tracer that saves the checkpoints asked for. The first
+// error it runs into is kept in errp, later saves are skipped.
+func (opts checkpointOptions) saver(outFileName string, errp *error) legv8.Tracer {
+	return func(m *legv8.Machine, inst legv8.Instruction) {
+		if *errp != nil || !(inst.Cycle == opts.saveAt || opts.saveOnBreak && m.Halted) {
+			return
+		}
+		path := opts.path
+		if path == "" {
+			path = fmt.Sprintf("%s_cycle%d.ckpt", outFileName, inst.Cycle)
+		}
+		*errp = saveCheckpoint(m, path)
+		if *errp == nil {
+			fmt.Println("checkpoint at cycle", inst.Cycle, "saved to", path)
+		}
+	}
+}
+
+// saveCheckpoint writes the state of the machine to path
+func saveCheckpoint(m *legv8.Machine, path string) error {
+	c, err := m.Checkpoint()
+	if err != nil {
+		return err
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := legv8.WriteCheckpoint(file, c); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// resume restores the machine from the checkpoint at path
+func resume(m *legv8.Machine, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	c, err := legv8.ReadCheckpoint(file)
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	if err := m.Restore(c); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"Project2_Team10/legv8"
+)
+
+// compareMain runs the compare mode: check the _dis.txt and _sim.txt files
+// of a run against reference outputs record by record and report the first
+// place each differs. The exit status is 1 when either differs. Outputs of
+// the reference simulator only match a run made with -format reference, the
+// full format adds a backtrace and statistics at the end.
+func compareMain(args []string) (int, error) {
+	flags := flag.NewFlagSet("compare", flag.ExitOnError)
+	cmdOutFile := flags.String("o", "team10_out.txt", "-o [output file path/name] the run was written to")
+	cmdRef := flags.String("ref", "", "-ref [reference file path/name], compared as [name]_dis.txt and [name]_sim.txt")
+	cmdContext := flags.Int("context", 3, "-context [n] reference lines or cycles to print in front of a divergence")
+	_ = flags.Parse(args)
+
+	if *cmdRef == "" {
+		return 0, fmt.Errorf("compare needs -ref")
+	}
+	exitCode := 0
+	for _, suffix := range []string{"_dis.txt", "_sim.txt"} {
+		got, want := *cmdOutFile+suffix, *cmdRef+suffix
+		var d *legv8.Divergence
+		var records int
+		if suffix == "_dis.txt" {
+			var gotLines, wantLines []legv8.DisLine
+			err := parseFile(got, func(r io.Reader) (err error) { gotLines, err = legv8.ParseDisassembly(r); return })
+			if err == nil {
+				err = parseFile(want, func(r io.Reader) (err error) { wantLines, err = legv8.ParseDisassembly(r); return })
+			}
+			if err != nil {
+				return 0, err
+			}
+			d, records = legv8.CompareDisassembly(gotLines, wantLines, *cmdContext), len(wantLines)
+		} else {
+			var gotRecords, wantRecords []legv8.SimRecord
+			err := parseFile(got, func(r io.Reader) (err error) { gotRecords, err = legv8.ParseSimulation(r); return })
+			if err == nil {
+				err = parseFile(want, func(r io.Reader) (err error) { wantRecords, err = legv8.ParseSimulation(r); return })
+			}
+			if err != nil {
+				return 0, err
+			}
+			d, records = legv8.CompareSimulation(gotRecords, wantRecords, *cmdContext), len(wantRecords)
+		}
+
+		if d == nil {
+			fmt.Printf("%s: same as %s (%d records)\n", got, want, records)
+			continue
+		}
+		exitCode = 1
+		fmt.Printf("%s: first divergence from %s at line %d (reference line %d)\n", got, want, d.Line, d.RefLine)
+		if len(d.Context) > 0 {
+			fmt.Println("  after:")
+			for _, line := range d.Context {
+				fmt.Printf("\t%s\n", line)
+			}
+		}
+		for _, diff := range d.Diffs {
+			fmt.Printf("  %s\n", diff)
+		}
+	}
+	return exitCode, nil
+}
+
+// parseFile opens path and hands it to parse
+func parseFile(path string, parse func(io.Reader) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if err := parse(file); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"Project2_Team10/legv8"
+)
+
+// models are the execution models cosim can compare, with default settings
+var models = map[string]func(m *legv8.Machine, w io.Writer) error{
+	"single": func(m *legv8.Machine, w io.Writer) error {
+		return legv8.SimInstructions(m, w, legv8.Full)
+	},
+	"pipeline": func(m *legv8.Machine, w io.Writer) error {
+		return legv8.NewPipeline(m, legv8.PipelineConfig{Forwarding: true}).Run(w)
+	},
+	"ooo": func(m *legv8.Machine, w io.Writer) error {
+		return legv8.NewTomasulo(m, legv8.DefaultTomasuloConfig).Run(w)
+	},
+	"superscalar": func(m *legv8.Machine, w io.Writer) error {
+		return legv8.NewSuperscalar(m, legv8.SuperscalarConfig{Width: 2, MemoryPorts: 1, LoadLatency: 2}).Run(w)
+	},
+}
+
+var modelNames = []string{"single", "pipeline", "ooo", "superscalar"}
+
+// cosimMain runs the cosim mode: run a program on two execution models in
+// lock step and compare the architectural state at every retirement. The
+// exit status is 1 when they disagree.
+func cosimMain(args []string) (int, error) {
+	flags := flag.NewFlagSet("cosim", flag.ExitOnError)
+	cmdInFile := flags.String("i", "addtest1_bin.txt", "-i [input file path/name], .s and .asm files are assembled first")
+	cmdA := flags.String("a", "single", "-a ["+strings.Join(modelNames, "|")+"] reference model")
+	cmdB := flags.String("b", "ooo", "-b ["+strings.Join(modelNames, "|")+"] model checked against -a")
+	cmdTimeout := flags.Duration("timeout", time.Minute, "-timeout [duration] stop both models after this long")
+	state := addStateFlags(flags)
+	_ = flags.Parse(args)
+
+	var pair [2]legv8.Model
+	for i, name := range []string{*cmdA, *cmdB} {
+		run, ok := models[name]
+		if !ok {
+			return 0, fmt.Errorf("model %q is not one of %s", name, strings.Join(modelNames, ", "))
+		}
+		pair[i] = legv8.Model{Name: name, Run: run}
+	}
+	instructionsArray, _, err := loadProgram(*cmdInFile)
+	if err != nil {
+		return 0, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *cmdTimeout)
+	defer cancel()
+	report, err := legv8.CoSimulate(ctx, pair[0], pair[1], func() (*legv8.Machine, error) {
+		// each model gets a copy of the program, so nothing is shared
+		machine, err := newMachine(append([]legv8.Instruction(nil), instructionsArray...))
+		if err != nil {
+			return nil, err
+		}
+		return machine, state.apply(machine)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	a, b := pair[0].Name, pair[1].Name
+	if len(report.Mismatches) == 0 {
+		fmt.Printf("%s and %s agree on all %d retired instructions, both stopped on %s\n", a, b, report.Retired, stopReason(report.ErrA))
+		return 0, nil
+	}
+	fmt.Printf("%s and %s disagree after %d matching retirements\n", a, b, report.Retired)
+	for _, side := range []struct {
+		name string
+		s    *legv8.Snapshot
+	}{{a, report.A}, {b, report.B}} {
+		if side.s != nil {
+			fmt.Printf("  %s retired #%d: %d\t%s, next pc %d\n", side.name, side.s.Retired,
+				side.s.Inst.ProgramCnt, legv8.InstructionString(side.s.Inst), side.s.PC)
+		}
+	}
+	for _, mm := range report.Mismatches {
+		fmt.Printf("  %s: %s %s, %s %s\n", mm.What, a, mm.Expected, b, mm.Got)
+	}
+	return 1, nil
+}
+
+// stopReason describes how a model stopped
+func stopReason(err error) string {
+	if err == nil {
+		return "BREAK"
+	}
+	return err.Error()
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"Project2_Team10/legv8"
+)
+
+// debugMain runs the debug mode: an interactive prompt for stepping through
+// a program. Assembly input keeps its labels for breakpoints.
+func debugMain(args []string) error {
+	flags := flag.NewFlagSet("debug", flag.ExitOnError)
+	cmdInFile := flags.String("i", "addtest1_bin.txt", "-i [input file path/name], .s and .asm files are assembled first")
+	history := flags.Int("history", 10000, "-history [n], instructions the undo log keeps for back and lastwrite, 0 turns recording off")
+	interval := flags.Int("interval", 1000, "-interval [n], cycles between the checkpoints rewind starts from")
+	state := addStateFlags(flags)
+	_ = flags.Parse(args)
+
+	instructionsArray, labels, err := loadProgram(*cmdInFile)
+	if err != nil {
+		return err
+	}
+	machine, err := newMachine(instructionsArray)
+	if err != nil {
+		return err
+	}
+	machine.Output = os.Stdout // stdin is for debugger commands
+	if err := state.apply(machine); err != nil {
+		return err
+	}
+	if *history > 0 {
+		if err := machine.Record(legv8.NewHistory(*history, *interval)); err != nil {
+			return err
+		}
+	}
+
+	fmt.Println("debugging", *cmdInFile, "- type help for commands")
+	return legv8.NewDebugger(machine, labels).Run(os.Stdin, os.Stdout)
+}
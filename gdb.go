@@ -0,0 +1,54 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+
+	"Project2_Team10/legv8"
+)
+
+// gdbMain runs the gdb mode: a GDB remote serial protocol stub on a local
+// port, for "target remote localhost:<port>" in gdb.
+func gdbMain(args []string) error {
+	flags := flag.NewFlagSet("gdb", flag.ExitOnError)
+	cmdInFile := flags.String("i", "addtest1_bin.txt", "-i [input file path/name], .s and .asm files are assembled first")
+	cmdPort := flags.Int("port", 1234, "-port [tcp port] to listen on")
+	history := flags.Int("history", 10000, "-history [n], instructions the undo log keeps for reverse-step and reverse-continue, 0 turns recording off")
+	interval := flags.Int("interval", 1000, "-interval [n], cycles between the checkpoints reverse execution starts from")
+	state := addStateFlags(flags)
+	_ = flags.Parse(args)
+
+	instructionsArray, _, err := loadProgram(*cmdInFile)
+	if err != nil {
+		return err
+	}
+	machine, err := newMachine(instructionsArray)
+	if err != nil {
+		return err
+	}
+	machine.Output, machine.Input = os.Stdout, os.Stdin
+	if err := state.apply(machine); err != nil {
+		return err
+	}
+	if *history > 0 {
+		if err := machine.Record(legv8.NewHistory(*history, *interval)); err != nil {
+			return err
+		}
+	}
+
+	listener, err := net.Listen("tcp", fmt.Sprintf("localhost:%d", *cmdPort))
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+	fmt.Printf("debugging %s - waiting for gdb on %s\n", *cmdInFile, listener.Addr())
+
+	conn, err := listener.Accept()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return legv8.NewGDBStub(machine).Serve(conn)
+}
@@ -0,0 +1,380 @@
+package legv8
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// breakWord is the BREAK encoding the assembler writes out, it is the same
+// one the class test files use.
+const breakWord = "11111110110111101111111111100111"
+
+// AsmError is an assembly error at a line and column of the source, both
+// counted from 1.
+type AsmError struct {
+	Line, Col int
+	Msg       string
+}
+
+func (e *AsmError) Error() string {
+	return fmt.Sprintf("line %d, column %d: %s", e.Line, e.Col, e.Msg)
+}
+
+// token is one word or punctuation mark of an assembly line
+type token struct {
+	text string
+	col  int
+}
+
+// asmLine is one source line that makes a line of output
+type asmLine struct {
+	line     int
+	mnemonic token
+	operands []token
+	end      int // column just past the end of the line, used for missing operand errors
+	pc       int
+}
+
+// Assemble turns LEGv8 assembly into the 32 character binary lines ReadFile
+// reads. Labels end in a colon, comments start with // or ;, and .word puts
+// one or more data words into the output, which is how the data after BREAK
+// is written: only .word lines can follow BREAK. Each .word value is one 32
+// bit data word, the size LDUR loads.
+func Assemble(r io.Reader) ([]string, error) {
+	lines, _, err := AssembleWithLabels(r)
+	return lines, err
+}
+
+// AssembleWithLabels is Assemble that also returns the address of every label.
+func AssembleWithLabels(r io.Reader) ([]string, map[string]int, error) {
+	labels := make(map[string]int)
+	var lines []asmLine
+
+	// first pass: find the address of every label
+	scanner := bufio.NewScanner(r)
+	pc := BaseAddress
+	for n := 1; scanner.Scan(); n++ {
+		text := scanner.Text()
+		if i := strings.Index(text, "//"); i >= 0 {
+			text = text[:i]
+		}
+		if i := strings.Index(text, ";"); i >= 0 {
+			text = text[:i]
+		}
+		tokens, err := tokenize(text, n)
+		if err != nil {
+			return nil, nil, err
+		}
+		// labels
+		for len(tokens) >= 2 && tokens[1].text == ":" {
+			if !isIdent(tokens[0].text) {
+				return nil, nil, &AsmError{n, tokens[0].col, fmt.Sprintf("bad label %q", tokens[0].text)}
+			}
+			if _, ok := labels[tokens[0].text]; ok {
+				return nil, nil, &AsmError{n, tokens[0].col, fmt.Sprintf("label %q is already defined", tokens[0].text)}
+			}
+			labels[tokens[0].text] = pc
+			tokens = tokens[2:]
+		}
+		if len(tokens) == 0 {
+			continue
+		}
+		line := asmLine{line: n, mnemonic: tokens[0], operands: tokens[1:], end: len(text) + 1, pc: pc}
+		lines = append(lines, line)
+		if strings.EqualFold(line.mnemonic.text, ".word") {
+			pc += 4 * ((len(line.operands) + 1) / 2) // values are separated by commas
+		} else {
+			pc += 4
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	// second pass: encode
+	var out []string
+	data := false // everything after BREAK is data
+	for _, line := range lines {
+		if data && !strings.EqualFold(line.mnemonic.text, ".word") {
+			return nil, nil, &AsmError{line.line, line.mnemonic.col,
+				fmt.Sprintf("%s after BREAK, only .word can follow BREAK", line.mnemonic.text)}
+		}
+		data = data || strings.EqualFold(line.mnemonic.text, "BREAK")
+		if strings.EqualFold(line.mnemonic.text, ".word") {
+			words, err := line.words(labels)
+			if err != nil {
+				return nil, nil, err
+			}
+			out = append(out, words...)
+			continue
+		}
+		word, err := line.encode(labels)
+		if err != nil {
+			return nil, nil, err
+		}
+		out = append(out, word)
+	}
+	return out, labels, nil
+}
+
+// tokenize splits an assembly line into words and the marks , [ ] # :
+func tokenize(text string, line int) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(text); {
+		c := text[i]
+		switch {
+		case c == ' ' || c == '\t':
+			i++
+		case strings.IndexByte(",[]#:", c) >= 0:
+			tokens = append(tokens, token{string(c), i + 1})
+			i++
+		case c == '-' || c == '_' || c == '.' || c >= '0' && c <= '9' || c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z':
+			start := i
+			for i < len(text) && (text[i] == '-' || text[i] == '_' || text[i] == '.' ||
+				text[i] >= '0' && text[i] <= '9' || text[i] >= 'A' && text[i] <= 'Z' || text[i] >= 'a' && text[i] <= 'z') {
+				i++
+			}
+			tokens = append(tokens, token{text[start:i], start + 1})
+		default:
+			return nil, &AsmError{line, i + 1, fmt.Sprintf("unexpected character %q", c)}
+		}
+	}
+	return tokens, nil
+}
+
+func isIdent(s string) bool {
+	if s == "" || s[0] >= '0' && s[0] <= '9' || s[0] == '-' {
+		return false
+	}
+	return true
+}
+
+// words encodes the values of a .word line
+func (line asmLine) words(labels map[string]int) ([]string, error) {
+	var out []string
+	ops := line.operands
+	for {
+		if len(ops) == 0 {
+			return nil, &AsmError{line.line, line.end, ".word needs a value"}
+		}
+		value, err := line.value(ops[0], labels)
+		if err != nil {
+			return nil, err
+		}
+		if value < -1<<31 || value >= 1<<32 {
+			return nil, &AsmError{line.line, ops[0].col, fmt.Sprintf("value %d does not fit in 32 bits", value)}
+		}
+		out = append(out, fmt.Sprintf("%032b", uint32(value)))
+		ops = ops[1:]
+		if len(ops) == 0 {
+			return out, nil
+		}
+		if ops[0].text != "," {
+			return nil, &AsmError{line.line, ops[0].col, fmt.Sprintf("expected \",\" but found %q", ops[0].text)}
+		}
+		ops = ops[1:]
+	}
+}
+
+// value returns the number or label address a token stands for
+func (line asmLine) value(tok token, labels map[string]int) (int64, error) {
+	if addr, ok := labels[tok.text]; ok {
+		return int64(addr), nil
+	}
+	value, err := strconv.ParseInt(tok.text, 0, 64)
+	if err != nil {
+		return 0, &AsmError{line.line, tok.col, fmt.Sprintf("%q is not a number or label", tok.text)}
+	}
+	return value, nil
+}
+
+// register returns the number of the register a token names: X0-X31, R0-R31,
+// XZR, SP, FP or LR
+func (line asmLine) register(tok token) (int64, error) {
+	name := strings.ToUpper(tok.text)
+	switch name {
+	case "XZR":
+		return XZR, nil
+	case "SP":
+		return SP, nil
+	case "FP":
+		return FP, nil
+	case "LR":
+		return LR, nil
+	}
+	if len(name) > 1 && (name[0] == 'X' || name[0] == 'R') {
+		if n, err := strconv.Atoi(name[1:]); err == nil && n >= 0 && n <= 31 {
+			return int64(n), nil
+		}
+	}
+	return 0, &AsmError{line.line, tok.col, fmt.Sprintf("%q is not a register", tok.text)}
+}
+
+// shiftSyntax is the operand syntax of the instructions whose disassembly
+// doesn't show their shift amount. MOVZ and MOVK take it in bits.
+var shiftSyntax = map[string]string{
+	"LSR":  "Rd, Rn, #Shamt",
+	"LSL":  "Rd, Rn, #Shamt",
+	"ASR":  "Rd, Rn, #Shamt",
+	"MOVZ": "Rd, Field, LSL Shift",
+	"MOVK": "Rd, Field, LSL Shift",
+}
+
+// assemblySyntax returns the operand syntax the assembler reads, which is the
+// one of the disassembly so its output assembles back to the same bits
+func (enc *Encoding) assemblySyntax() string {
+	if syntax, ok := shiftSyntax[enc.Mnemonic]; ok {
+		return syntax
+	}
+	if syntax, ok := disassemblySyntax[enc.Mnemonic]; ok {
+		return syntax
+	}
+	return enc.Syntax
+}
+
+// encode turns an instruction line into its binary string using the
+// instruction table, assemblySyntax says how to read the operands
+func (line asmLine) encode(labels map[string]int) (string, error) {
+	mnemonic := strings.ToUpper(line.mnemonic.text)
+	switch mnemonic {
+	case "BREAK":
+		if len(line.operands) > 0 {
+			return "", &AsmError{line.line, line.operands[0].col, "BREAK takes no operands"}
+		}
+		return breakWord, nil
+	}
+
+	values := make(map[string]int64)
+	enc := Lookup(mnemonic)
+	syntax := ""
+	if enc != nil {
+		syntax = enc.assemblySyntax()
+	}
+	for _, a := range aliases {
+		if a.mnemonic == mnemonic {
+			enc = Lookup(a.of)
+			syntax = a.syntax
+			values["Rd"] = XZR
+		}
+	}
+	if enc == nil {
+		return "", &AsmError{line.line, line.mnemonic.col, fmt.Sprintf("unknown instruction %q", line.mnemonic.text)}
+	}
+	if cond, ok := enc.condition(); ok {
+		values["Rt"] = int64(cond)
+	}
+	if enc.Format == "EXC" {
+		values["Op2"] = 1 // the low bits of SVC are always 00001
+	}
+
+	pattern, _ := tokenize(syntax, 0)
+	ops := line.operands
+	for si := 0; si < len(pattern); si++ {
+		want := pattern[si].text
+		col := line.end
+		if len(ops) > 0 {
+			col = ops[0].col
+		}
+		switch {
+		case want == "#": // the # in front of an immediate is optional
+			if len(ops) > 0 && ops[0].text == "#" {
+				ops = ops[1:]
+			}
+		case want == "," && len(ops) == 0 && si+1 < len(pattern) && pattern[si+1].text == "LSL":
+			si = len(pattern) // the LSL of MOVZ and MOVK can be left off
+		case want == "," || want == "[" || want == "]" || want == "LSL":
+			if len(ops) == 0 {
+				return "", &AsmError{line.line, col, fmt.Sprintf("expected %q", want)}
+			}
+			if !strings.EqualFold(ops[0].text, want) {
+				return "", &AsmError{line.line, col, fmt.Sprintf("expected %q but found %q", want, ops[0].text)}
+			}
+			ops = ops[1:]
+		case want == "Rd" || want == "Rn" || want == "Rm" || want == "Rt":
+			if len(ops) == 0 {
+				return "", &AsmError{line.line, col, "missing register"}
+			}
+			r, err := line.register(ops[0])
+			if err != nil {
+				return "", err
+			}
+			values[want] = r
+			ops = ops[1:]
+		case want == "Sysreg":
+			if len(ops) == 0 {
+				return "", &AsmError{line.line, col, "missing system register"}
+			}
+			n := -1
+			for i, name := range sysregNames {
+				if strings.EqualFold(ops[0].text, name) {
+					n = i
+				}
+			}
+			if n < 0 {
+				return "", &AsmError{line.line, col, fmt.Sprintf("%q is not a system register", ops[0].text)}
+			}
+			values["Field"] = int64(n)
+			ops = ops[1:]
+		default: // an immediate field, its # can be written even if the syntax has none
+			if len(ops) > 0 && ops[0].text == "#" {
+				ops = ops[1:]
+			}
+			if len(ops) == 0 {
+				return "", &AsmError{line.line, col, "missing immediate"}
+			}
+			value, err := line.value(ops[0], labels)
+			if err != nil {
+				return "", err
+			}
+			if addr, ok := labels[ops[0].text]; ok && want == "Offset" {
+				value = int64(addr-line.pc) / 4 // branches to a label are relative
+			}
+			if want == "Shift" {
+				if value%16 != 0 {
+					return "", &AsmError{line.line, col, fmt.Sprintf("shift %d is not 0, 16, 32 or 48", value)}
+				}
+				want, value = "Shamt", value/16
+			}
+			if err := checkRange(enc.Format, want, value); err != "" {
+				return "", &AsmError{line.line, col, err}
+			}
+			values[want] = value
+			ops = ops[1:]
+		}
+	}
+	if len(ops) > 0 {
+		return "", &AsmError{line.line, ops[0].col, fmt.Sprintf("unexpected %q", ops[0].text)}
+	}
+
+	lineValue := enc.Opcode << (32 - enc.Width)
+	for _, f := range formats[enc.Format] {
+		length := f.hi - f.lo + 1
+		lineValue |= uint64(values[f.name]) & (1<<length - 1) << f.lo
+	}
+	return fmt.Sprintf("%032b", lineValue), nil
+}
+
+// checkRange returns a message if value does not fit in the named field of
+// the format
+func checkRange(format string, name string, value int64) string {
+	for _, f := range formats[format] {
+		if f.name != name {
+			continue
+		}
+		length := f.hi - f.lo + 1
+		low, high := int64(0), int64(1)<<length-1
+		if f.signed {
+			low, high = -(int64(1) << (length - 1)), int64(1)<<(length-1)-1
+		}
+		if (name == "Im" || name == "Address") && high > 255 {
+			high = 255 // decoding keeps only the low 8 bits of these
+		}
+		if value < low || value > high {
+			return fmt.Sprintf("%s %d is out of range (%d to %d)", name, value, low, high)
+		}
+	}
+	return ""
+}
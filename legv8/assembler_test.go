@@ -0,0 +1,141 @@
+package legv8
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// assemble assembles and decodes a program the way the main program loads
+// a .s file
+func assemble(t *testing.T, source string) []Instruction {
+	t.Helper()
+	lines, err := Assemble(strings.NewReader(source))
+	if err != nil {
+		t.Fatalf("Assemble: %v", err)
+	}
+	program, err := ReadFile(strings.NewReader(strings.Join(lines, "\n")))
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if err := InitializeInstructions(program); err != nil {
+		t.Fatalf("InitializeInstructions: %v", err)
+	}
+	return program
+}
+
+// disassembled returns the text of the instruction in the disassembly file
+func disassembled(inst Instruction) string {
+	return inst.Mnemonic() + " " + inst.disassembly()
+}
+
+func TestAssembleRoundTrip(t *testing.T) {
+	tests := []struct {
+		source string
+		text   string // what the disassembly prints
+		same   bool   // the text assembles back to the same bits
+	}{
+		{"ADD X3, X1, X2", "ADD R3, R1, R2", true},
+		{"SUB X3, X1, XZR", "SUB R3, R1, R31", true},
+		{"AND X3, X1, X2", "AND R3, R1, R2", true},
+		{"ORR X3, X1, X2", "ORR R3, R1, R2", true},
+		{"EOR X1, X2, X3", "EOR R1, R2, R3", true},
+		{"ANDS X1, X2, X3", "ANDS R1, R2, R3", true},
+		{"ADDS X1, X2, X3", "ADDS R1, R2, R3", true},
+		{"LSL X3, X1, #7", "LSL R3, R1, R0", false}, // the disassembly leaves out the shift amount
+		{"ASR X3, X1, #31", "ASR R3, R1, R0", false},
+		{"ADDI X0, X0, #7", "ADDI R0, R0, #7", true},
+		{"SUBI X1, SP, #255", "SUBI R1, R28, #255", true},
+		{"LDUR X13, [X12, #255]", "LDUR R13, [R12, #255]", true},
+		{"STUR X13, [X12, #0]", "STUR R13, [R12, #0]", true},
+		{"STURB X1, [SP, #1]", "STURB R1, [R28, #1]", true},
+		{"LDURSW X2, [X1, #4]", "LDURSW R2, [R1, #4]", true},
+		{"CBZ X12, #0", "CBZ R12, 0", true},
+		{"CBNZ X1, -3", "CBNZ R1, -3", true},
+		{"B #-2", "B #-2", true},
+		{"BL #5", "BL #5", true},
+		{"B.LT #-2", "B.LT #-2", true},
+		{"BR LR", "BR R30", true},
+		{"MOVZ X0, 0xFFFF, LSL 48", "MOVZ R0, 65535, LSL 3", false}, // the disassembly counts the shift in 16 bits
+		{"MOVK X0, 1, LSL 16", "MOVK R0, 1, LSL 1", false},
+		{"CMP X1, X2", "CMP R1, R2", true},
+		{"CMPI X1, #3", "CMPI R1, #3", true},
+		{"NOP", "NOP ", true},
+		{"SVC #2", "SVC #2", true},
+		{"MRS X1, ESR", "MRS R1, ESR", true},
+		{"MSR VBAR, X2", "MSR VBAR, R2", true},
+		{"ERET", "ERET ", true},
+	}
+	for _, test := range tests {
+		t.Run(test.source, func(t *testing.T) {
+			first := assemble(t, test.source+"\nBREAK")[0]
+			if got := disassembled(first); got != test.text {
+				t.Fatalf("disassembly is %q, want %q", got, test.text)
+			}
+			if !test.same {
+				return
+			}
+			again := assemble(t, test.text+"\nBREAK")[0]
+			if again.RawInstruction != first.RawInstruction {
+				t.Errorf("%q assembles to %s, %q to %s", test.source, first.RawInstruction, test.text, again.RawInstruction)
+			}
+		})
+	}
+}
+
+func TestAssembleShifts(t *testing.T) {
+	program := assemble(t, "LSL X3, X1, #7\nMOVZ X0, 0xFFFF, LSL 48\nMOVK X0, 1\nBREAK")
+	for i, want := range []uint8{7, 3, 0} {
+		if got := program[i].Shamt; got != want {
+			t.Errorf("%s has a shift of %d, want %d", program[i].Op, got, want)
+		}
+	}
+}
+
+func TestAssembleLabels(t *testing.T) {
+	program := assemble(t, `
+start:	ADDI X1, XZR, #3
+loop:	SUBI X1, X1, #1
+	CBNZ X1, loop
+	B start
+	BREAK
+	.word -1, 7`)
+	want := []string{"ADDI R1, R31, #3", "SUBI R1, R1, #1", "CBNZ R1, -1", "B #-3", "BREAK "}
+	for i, text := range want {
+		if got := disassembled(program[i]); got != text {
+			t.Errorf("line %d is %q, want %q", i, got, text)
+		}
+	}
+	if len(program) != 7 || program[5].LineValue != 0xFFFFFFFF || program[6].LineValue != 7 {
+		t.Errorf("data words are %v, want -1 and 7 after BREAK", program[len(want):])
+	}
+}
+
+func TestAssembleErrors(t *testing.T) {
+	tests := []struct {
+		source    string
+		line, col int
+		msg       string
+	}{
+		{"FOO X1, X2, X3", 1, 1, "FOO"},
+		{"ADDI X1, X2, #256", 1, 15, "256"},
+		{"ADD X1, X2", 1, 11, ""},
+		{"LDUR X1, [X2, #256]", 1, 16, "256"},
+		{"LSL X1, X2, #32", 1, 14, "32"},
+		{"MOVZ X1, 1, LSL 8", 1, 17, "shift 8"},
+		{"B nowhere", 1, 3, "nowhere"},
+		{"BREAK\nADD X1, X2, X3", 2, 1, "only .word can follow BREAK"},
+		{"BREAK\n.word 1\nNOP", 3, 1, "only .word can follow BREAK"},
+	}
+	for _, test := range tests {
+		_, err := Assemble(strings.NewReader(test.source))
+		var asmErr *AsmError
+		if !errors.As(err, &asmErr) {
+			t.Errorf("%q: error is %v, want an AsmError", test.source, err)
+			continue
+		}
+		if asmErr.Line != test.line || asmErr.Col != test.col || !strings.Contains(asmErr.Msg, test.msg) {
+			t.Errorf("%q: error is %v, want line %d, column %d mentioning %q", test.source, err, test.line, test.col, test.msg)
+		}
+	}
+}
@@ -0,0 +1,302 @@
+package legv8
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Predictor guesses whether a conditional branch is taken. target is where
+// the branch goes when it is taken.
+type Predictor interface {
+	Predict(pc, target int) bool
+	Update(pc, target int, taken bool)
+}
+
+// PredictorConfig describes a branch predictor and its branch target buffer.
+type PredictorConfig struct {
+	Kind        string // taken, not-taken, btfnt, 1bit, 2bit, gshare or tournament
+	TableSize   int    // counters in each prediction table
+	HistoryBits int    // bits of global history gshare and tournament use
+	BTBSize     int    // entries in the branch target buffer
+	Penalty     int    // cycles a misprediction adds to the cycle count outside of the pipeline
+}
+
+// PredictorKinds lists the predictors NewBranchUnit knows.
+var PredictorKinds = []string{"taken", "not-taken", "btfnt", "1bit", "2bit", "gshare", "tournament"}
+
+// staticPredictor always predicts the same direction
+type staticPredictor bool
+
+func (s staticPredictor) Predict(pc, target int) bool       { return bool(s) }
+func (s staticPredictor) Update(pc, target int, taken bool) {}
+
+// btfnt predicts backward branches (loops) taken and forward ones not taken
+type btfnt struct{}
+
+func (btfnt) Predict(pc, target int) bool       { return target <= pc }
+func (btfnt) Update(pc, target int, taken bool) {}
+
+// counters is a table of saturating counters indexed by pc, 1 bit counters
+// remember the last outcome and 2 bit ones need two misses to flip. They
+// start out weakly not taken.
+type counters struct {
+	table []uint8
+	max   uint8
+}
+
+func newCounters(size int, bits int) *counters {
+	c := &counters{table: make([]uint8, size), max: 1<<bits - 1}
+	for i := range c.table {
+		c.table[i] = c.max / 2
+	}
+	return c
+}
+
+func (c *counters) taken(index int) bool {
+	return c.table[index%len(c.table)] > c.max/2
+}
+
+func (c *counters) update(index int, taken bool) {
+	counter := &c.table[index%len(c.table)]
+	if taken && *counter < c.max {
+		*counter++
+	} else if !taken && *counter > 0 {
+		*counter--
+	}
+}
+
+// bimodal predicts from a table of counters indexed by the branch address
+type bimodal struct{ *counters }
+
+func (b bimodal) Predict(pc, target int) bool       { return b.taken(pc / 4) }
+func (b bimodal) Update(pc, target int, taken bool) { b.update(pc/4, taken) }
+
+// gshare indexes 2 bit counters with the branch address xored with the
+// outcomes of the last branches
+type gshare struct {
+	*counters
+	history int
+	mask    int
+}
+
+func (g *gshare) index(pc int) int { return pc/4 ^ g.history }
+
+func (g *gshare) Predict(pc, target int) bool { return g.taken(g.index(pc)) }
+
+func (g *gshare) Update(pc, target int, taken bool) {
+	g.update(g.index(pc), taken)
+	g.history <<= 1
+	if taken {
+		g.history |= 1
+	}
+	g.history &= g.mask
+}
+
+// tournament picks between a bimodal and a gshare predictor with a table of
+// 2 bit choosers, a chooser above the middle trusts gshare
+type tournament struct {
+	local   bimodal
+	global  *gshare
+	chooser *counters
+}
+
+func (t *tournament) Predict(pc, target int) bool {
+	if t.chooser.taken(pc / 4) {
+		return t.global.Predict(pc, target)
+	}
+	return t.local.Predict(pc, target)
+}
+
+func (t *tournament) Update(pc, target int, taken bool) {
+	local, global := t.local.Predict(pc, target), t.global.Predict(pc, target)
+	if local != global {
+		t.chooser.update(pc/4, global == taken)
+	}
+	t.local.Update(pc, target, taken)
+	t.global.Update(pc, target, taken)
+}
+
+// btbEntry is one line of the branch target buffer
+type btbEntry struct {
+	valid  bool
+	pc     int
+	target int
+}
+
+// BranchStats counts the outcomes of branches.
+type BranchStats struct {
+	Executed     int
+	Taken        int
+	Mispredicted int
+	BTBMisses    int // taken branches predicted taken whose target was not in the BTB
+}
+
+// Accuracy returns the percentage of branches predicted correctly.
+func (s BranchStats) Accuracy() float64 {
+	if s.Executed == 0 {
+		return 100
+	}
+	return 100 * float64(s.Executed-s.Mispredicted) / float64(s.Executed)
+}
+
+// BranchUnit predicts every branch the machine runs before it resolves and
+// keeps track of how well it did. Unconditional branches are always
+// predicted taken, so they only miss when the BTB does not have the target.
+type BranchUnit struct {
+	Config    PredictorConfig
+	Predictor Predictor
+	Stats     BranchStats
+	PerBranch map[int]*BranchStats // by branch address
+
+	Mispredicted  bool // the last branch went somewhere other than the front end fetched
+	Fetched       int  // address the front end fetched after the last branch
+	PenaltyCycles int  // Config.Penalty for every misprediction so far
+
+	btb []btbEntry
+}
+
+// NewBranchUnit returns a branch unit with a fresh predictor and an empty BTB.
+func NewBranchUnit(config PredictorConfig) (*BranchUnit, error) {
+	if config.TableSize < 1 || config.BTBSize < 1 || config.HistoryBits < 0 || config.Penalty < 0 {
+		return nil, fmt.Errorf("legv8: predictor table, btb, history and penalty sizes must be positive")
+	}
+	bu := &BranchUnit{Config: config, PerBranch: make(map[int]*BranchStats), btb: make([]btbEntry, config.BTBSize)}
+	newGshare := func() *gshare {
+		return &gshare{counters: newCounters(config.TableSize, 2), mask: 1<<config.HistoryBits - 1}
+	}
+	switch config.Kind {
+	case "taken":
+		bu.Predictor = staticPredictor(true)
+	case "not-taken":
+		bu.Predictor = staticPredictor(false)
+	case "btfnt":
+		bu.Predictor = btfnt{}
+	case "1bit":
+		bu.Predictor = bimodal{newCounters(config.TableSize, 1)}
+	case "2bit":
+		bu.Predictor = bimodal{newCounters(config.TableSize, 2)}
+	case "gshare":
+		bu.Predictor = newGshare()
+	case "tournament":
+		bu.Predictor = &tournament{bimodal{newCounters(config.TableSize, 2)}, newGshare(), newCounters(config.TableSize, 2)}
+	default:
+		return nil, fmt.Errorf("legv8: predictor %q is not one of %s", config.Kind, strings.Join(PredictorKinds, ", "))
+	}
+	return bu, nil
+}
+
+// record predicts a branch that just ran, then trains the predictor and BTB
+// with where it actually went
+func (bu *BranchUnit) record(inst Instruction, taken bool, target int) {
+	pc := inst.ProgramCnt
+	conditional := inst.TypeOfInstruction == "CB"
+	predicted := true
+	if conditional {
+		predicted = bu.Predictor.Predict(pc, pc+4*int(inst.Offset))
+	}
+
+	entry := &bu.btb[pc/4%len(bu.btb)]
+	hit := entry.valid && entry.pc == pc
+	bu.Fetched = pc + 4
+	if predicted && hit {
+		bu.Fetched = entry.target
+	}
+	actual := pc + 4
+	if taken {
+		actual = target
+	}
+	bu.Mispredicted = bu.Fetched != actual
+
+	branch := bu.PerBranch[pc]
+	if branch == nil {
+		branch = &BranchStats{}
+		bu.PerBranch[pc] = branch
+	}
+	for _, s := range []*BranchStats{&bu.Stats, branch} {
+		s.Executed++
+		if taken {
+			s.Taken++
+		}
+		if bu.Mispredicted {
+			s.Mispredicted++
+		}
+		if predicted && taken && !hit {
+			s.BTBMisses++
+		}
+	}
+	if bu.Mispredicted {
+		bu.PenaltyCycles += bu.Config.Penalty
+	}
+
+	if conditional {
+		bu.Predictor.Update(pc, pc+4*int(inst.Offset), taken)
+	}
+	if taken {
+		*entry = btbEntry{true, pc, target}
+	}
+}
+
+// ParsePredictorConfig reads a predictor description such as
+// "gshare,table=256,history=8,btb=32,penalty=2". The first part is the kind,
+// anything left out keeps the default: 64 entry tables, 6 bits of history, a
+// 16 entry BTB and a 2 cycle penalty, the cost of a branch resolving in EX.
+func ParsePredictorConfig(spec string) (PredictorConfig, error) {
+	parts := strings.Split(spec, ",")
+	config := PredictorConfig{Kind: strings.TrimSpace(parts[0]), TableSize: 64, HistoryBits: 6, BTBSize: 16, Penalty: 2}
+	for _, part := range parts[1:] {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return config, fmt.Errorf("legv8: predictor: %q is not key=value", part)
+		}
+		n, err := strconv.Atoi(value)
+		switch key {
+		case "table":
+			config.TableSize = n
+		case "history":
+			config.HistoryBits = n
+		case "btb":
+			config.BTBSize = n
+		case "penalty":
+			config.Penalty = n
+		default:
+			err = fmt.Errorf("unknown setting")
+		}
+		if err != nil {
+			return config, fmt.Errorf("legv8: predictor: %s: %v", part, err)
+		}
+	}
+	return config, nil
+}
+
+// PrintBranchStats writes the overall and per branch prediction statistics.
+// cycles is the cycle count the penalties went into, or 0 when the pipeline
+// counted the cycles itself.
+func PrintBranchStats(w io.Writer, bu *BranchUnit, m *Machine, cycles int) {
+	fmt.Fprintln(w, "====================")
+	fmt.Fprintf(w, "Branch predictor:\t%s, %d entry tables, %d bits of history, %d entry BTB\n",
+		bu.Config.Kind, bu.Config.TableSize, bu.Config.HistoryBits, bu.Config.BTBSize)
+	fmt.Fprintf(w, "Branches:\t%d\ttaken %d\tmispredicted %d\tBTB misses %d\taccuracy %.2f%%\n",
+		bu.Stats.Executed, bu.Stats.Taken, bu.Stats.Mispredicted, bu.Stats.BTBMisses, bu.Stats.Accuracy())
+	if cycles > 0 {
+		fmt.Fprintf(w, "Cycles:\t%d (%d + %d misprediction penalty cycles)\n",
+			cycles, cycles-bu.PenaltyCycles, bu.PenaltyCycles)
+	}
+
+	var pcs []int
+	for pc := range bu.PerBranch {
+		pcs = append(pcs, pc)
+	}
+	sort.Ints(pcs)
+	for _, pc := range pcs {
+		s := bu.PerBranch[pc]
+		text := "?"
+		if inst, err := m.Fetch(pc); err == nil {
+			text = InstructionString(*inst)
+		}
+		fmt.Fprintf(w, "%d\t%s\texecuted %d\ttaken %d\tmispredicted %d\taccuracy %.2f%%\n",
+			pc, text, s.Executed, s.Taken, s.Mispredicted, s.Accuracy())
+	}
+}
@@ -0,0 +1,117 @@
+package legv8
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+// loopProgram runs its B.NE four times, taken the first three
+const loopProgram = `
+	ADDI X1, XZR, #4
+loop:	SUBIS X1, X1, #1
+	B.NE loop
+	BREAK`
+
+func TestPredictors(t *testing.T) {
+	tests := []struct {
+		kind         string
+		mispredicted int
+		btbMisses    int
+	}{
+		{"not-taken", 3, 0},
+		{"taken", 2, 1}, // the first taken branch is not in the BTB yet
+		{"btfnt", 2, 1},
+		{"1bit", 2, 0},
+		{"2bit", 2, 0},
+		{"gshare", 3, 0},
+		{"tournament", 2, 0},
+	}
+	program := assemble(t, loopProgram)
+	for _, test := range tests {
+		t.Run(test.kind, func(t *testing.T) {
+			config, err := ParsePredictorConfig(test.kind + ",penalty=3")
+			if err != nil {
+				t.Fatal(err)
+			}
+			bu, err := NewBranchUnit(config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			m := NewMachine()
+			m.Load(program)
+			m.Branches = bu
+			if err := m.Run(nil); err != nil {
+				t.Fatal(err)
+			}
+			want := BranchStats{Executed: 4, Taken: 3, Mispredicted: test.mispredicted, BTBMisses: test.btbMisses}
+			if bu.Stats != want || *bu.PerBranch[104] != want {
+				t.Errorf("stats are %+v, want %+v", bu.Stats, want)
+			}
+			// 10 instructions and the penalty of every misprediction
+			if got := m.Cycle; got != 10+3*test.mispredicted || bu.PenaltyCycles != 3*test.mispredicted {
+				t.Errorf("the run took %d cycles with %d penalty cycles, want %d", got, bu.PenaltyCycles, 10+3*test.mispredicted)
+			}
+		})
+	}
+}
+
+func TestPredictorTrace(t *testing.T) {
+	config, _ := ParsePredictorConfig("not-taken,penalty=5")
+	bu, _ := NewBranchUnit(config)
+	m := NewMachine()
+	m.Load(assemble(t, loopProgram))
+	m.Branches = bu
+	var out strings.Builder
+	if err := SimInstructions(m, &out, Full); err != nil {
+		t.Fatal(err)
+	}
+	// the first B.NE is mispredicted and takes 5 more cycles
+	for _, want := range []string{"Cycle:2\t100\tSUBIS", "Cycle:8\t104\tB.NE", "Cycle:9\t100\tSUBIS", "Cycles:\t25 (10 + 15 misprediction penalty cycles)"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output is missing %q", want)
+		}
+	}
+}
+
+func TestPredictorPipeline(t *testing.T) {
+	program := assemble(t, loopProgram)
+	for _, test := range []struct {
+		kind    string
+		flushes int
+	}{
+		{"", 3}, // no predictor, every taken branch flushes
+		{"2bit", 2},
+	} {
+		m := NewMachine()
+		m.Load(program)
+		if test.kind != "" {
+			config, _ := ParsePredictorConfig(test.kind)
+			m.Branches, _ = NewBranchUnit(config)
+		}
+		p := NewPipeline(m, PipelineConfig{Forwarding: true})
+		if err := p.Run(io.Discard); err != nil {
+			t.Fatal(err)
+		}
+		if p.Stats.Flushes != test.flushes || p.Stats.Flushed != 2*test.flushes {
+			t.Errorf("%q: %d flushes of %d instructions, want %d of %d", test.kind, p.Stats.Flushes, p.Stats.Flushed, test.flushes, 2*test.flushes)
+		}
+	}
+}
+
+func TestParsePredictorConfigErrors(t *testing.T) {
+	for _, spec := range []string{"2bit,table", "2bit,table=x", "2bit,size=4"} {
+		if _, err := ParsePredictorConfig(spec); err == nil {
+			t.Errorf("%q parsed without an error", spec)
+		}
+	}
+	for _, spec := range []string{"perceptron", "2bit,table=0", "2bit,penalty=-1"} {
+		config, err := ParsePredictorConfig(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := NewBranchUnit(config); err == nil {
+			t.Errorf("%q was accepted", spec)
+		}
+	}
+}
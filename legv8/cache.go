@@ -0,0 +1,370 @@
+package legv8
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+// MemoryLevel is anything a cache can miss to. Access returns the number of
+// cycles the access takes at this level and every level below it.
+type MemoryLevel interface {
+	Access(addr int, write bool) int
+}
+
+// CacheConfig describes one cache.
+type CacheConfig struct {
+	Name        string
+	Size        int    // bytes of data the cache holds
+	BlockSize   int    // bytes per block
+	Ways        int    // blocks per set, 1 is direct mapped
+	Replacement string // "LRU", "FIFO" or "random"
+	WriteBack   bool   // write-back with write allocate, otherwise write-through without
+	Latency     int    // cycles for a hit
+}
+
+// CacheStats counts the accesses to one cache.
+type CacheStats struct {
+	Reads, ReadMisses   int
+	Writes, WriteMisses int
+	Writebacks          int // dirty blocks written to the next level
+}
+
+// Access is one entry of the cache access log.
+type Access struct {
+	Level string // L1I, L1D, L2 or MEM
+	Addr  int
+	Write bool
+	Hit   bool
+}
+
+func (a Access) String() string {
+	kind, result := "read", "miss"
+	if a.Write {
+		kind = "write"
+	}
+	if a.Hit {
+		result = "hit"
+	}
+	if a.Level == "MEM" {
+		return fmt.Sprintf("%s %s %d", a.Level, kind, a.Addr)
+	}
+	return fmt.Sprintf("%s %s %d %s", a.Level, kind, a.Addr, result)
+}
+
+// cacheLine is one block of a set
+type cacheLine struct {
+	valid bool
+	dirty bool
+	tag   int
+	used  int // clock of the last access, for LRU
+	added int // clock of the fill, for FIFO
+}
+
+// Cache is a set associative cache in front of Next. It only keeps tags, the
+// data itself always lives in Memory, so a cache changes timing and never
+// the result of a program.
+type Cache struct {
+	Config CacheConfig
+	Next   MemoryLevel
+	Stats  CacheStats
+
+	sets  [][]cacheLine
+	clock int
+	rng   *rand.Rand
+	log   *[]Access
+}
+
+// NewCache returns an empty cache in front of next.
+func NewCache(config CacheConfig, next MemoryLevel) (*Cache, error) {
+	if config.BlockSize < 8 || config.BlockSize&(config.BlockSize-1) != 0 {
+		return nil, fmt.Errorf("legv8: %s block size %d is not a power of two of at least 8", config.Name, config.BlockSize)
+	}
+	if config.Ways < 1 || config.Size <= 0 || config.Size%(config.BlockSize*config.Ways) != 0 {
+		return nil, fmt.Errorf("legv8: %s size %d does not hold a whole number of %d way sets of %d byte blocks",
+			config.Name, config.Size, config.Ways, config.BlockSize)
+	}
+	if config.Latency < 0 {
+		return nil, fmt.Errorf("legv8: %s latency %d is negative", config.Name, config.Latency)
+	}
+	switch config.Replacement {
+	case "LRU", "FIFO", "random":
+	default:
+		return nil, fmt.Errorf("legv8: %s replacement %q is not LRU, FIFO or random", config.Name, config.Replacement)
+	}
+	c := &Cache{Config: config, Next: next, rng: rand.New(rand.NewSource(1))}
+	c.sets = make([][]cacheLine, config.Size/(config.BlockSize*config.Ways))
+	for i := range c.sets {
+		c.sets[i] = make([]cacheLine, config.Ways)
+	}
+	return c, nil
+}
+
+// Access looks addr up, filling the block from the next level on a miss.
+func (c *Cache) Access(addr int, write bool) int {
+	c.clock++
+	block := addr / c.Config.BlockSize
+	set := c.sets[block%len(c.sets)]
+	tag := block / len(c.sets)
+	cycles := c.Config.Latency
+
+	if write {
+		c.Stats.Writes++
+	} else {
+		c.Stats.Reads++
+	}
+
+	for i := range set {
+		if set[i].valid && set[i].tag == tag {
+			c.record(addr, write, true)
+			set[i].used = c.clock
+			if write && c.Config.WriteBack {
+				set[i].dirty = true
+			} else if write {
+				cycles += c.Next.Access(addr, true)
+			}
+			return cycles
+		}
+	}
+
+	c.record(addr, write, false)
+	if write {
+		c.Stats.WriteMisses++
+		if !c.Config.WriteBack { // no write allocate
+			return cycles + c.Next.Access(addr, true)
+		}
+	} else {
+		c.Stats.ReadMisses++
+	}
+
+	victim := &set[c.victim(set)]
+	if victim.valid && victim.dirty {
+		c.Stats.Writebacks++
+		cycles += c.Next.Access((victim.tag*len(c.sets)+block%len(c.sets))*c.Config.BlockSize, true)
+	}
+	cycles += c.Next.Access(block*c.Config.BlockSize, false)
+	*victim = cacheLine{valid: true, dirty: write, tag: tag, used: c.clock, added: c.clock}
+	return cycles
+}
+
+// victim returns the way of the set to replace
+func (c *Cache) victim(set []cacheLine) int {
+	for i := range set {
+		if !set[i].valid {
+			return i
+		}
+	}
+	if c.Config.Replacement == "random" {
+		return c.rng.Intn(len(set))
+	}
+	victim := 0
+	for i := range set {
+		if c.Config.Replacement == "LRU" && set[i].used < set[victim].used ||
+			c.Config.Replacement == "FIFO" && set[i].added < set[victim].added {
+			victim = i
+		}
+	}
+	return victim
+}
+
+func (c *Cache) record(addr int, write bool, hit bool) {
+	if c.log != nil {
+		*c.log = append(*c.log, Access{c.Config.Name, addr, write, hit})
+	}
+}
+
+// MainMemory is the last level, every access takes Latency cycles.
+type MainMemory struct {
+	Latency int
+	Reads   int
+	Writes  int
+
+	log *[]Access
+}
+
+func (mem *MainMemory) Access(addr int, write bool) int {
+	if write {
+		mem.Writes++
+	} else {
+		mem.Reads++
+	}
+	if mem.log != nil {
+		*mem.log = append(*mem.log, Access{"MEM", addr, write, false})
+	}
+	return mem.Latency
+}
+
+// CacheSystem is the memory hierarchy the machine fetches and accesses data
+// through. Any of the caches can be nil, the L1 caches share the L2 if there
+// is one.
+type CacheSystem struct {
+	L1I, L1D, L2 *Cache
+	Memory       *MainMemory
+	Log          []Access // accesses made by the last instruction
+
+	FetchCycles int // cycles the last fetch took
+	DataCycles  int // cycles the data accesses of the last instruction took
+	Fetches     int
+	DataOps     int
+	TotalCycles int // cycles of every access made so far
+}
+
+// NewCacheSystem builds a hierarchy from the configs, a nil config leaves
+// that cache out.
+func NewCacheSystem(l1i, l1d, l2 *CacheConfig, memLatency int) (*CacheSystem, error) {
+	if memLatency < 0 {
+		return nil, fmt.Errorf("legv8: memory latency %d is negative", memLatency)
+	}
+	cs := &CacheSystem{Memory: &MainMemory{Latency: memLatency}}
+	cs.Memory.log = &cs.Log
+
+	var next MemoryLevel = cs.Memory
+	var err error
+	if l2 != nil {
+		if cs.L2, err = NewCache(*l2, next); err != nil {
+			return nil, err
+		}
+		cs.L2.log = &cs.Log
+		next = cs.L2
+	}
+	if l1i != nil {
+		if cs.L1I, err = NewCache(*l1i, next); err != nil {
+			return nil, err
+		}
+		cs.L1I.log = &cs.Log
+	}
+	if l1d != nil {
+		if cs.L1D, err = NewCache(*l1d, next); err != nil {
+			return nil, err
+		}
+		cs.L1D.log = &cs.Log
+	}
+	return cs, nil
+}
+
+// reset empties every cache and clears the statistics, for a rerun of the
+// program
+func (cs *CacheSystem) reset() {
+	for _, c := range []*Cache{cs.L1I, cs.L1D, cs.L2} {
+		if c == nil {
+			continue
+		}
+		for i := range c.sets {
+			for j := range c.sets[i] {
+				c.sets[i][j] = cacheLine{}
+			}
+		}
+		c.Stats, c.clock = CacheStats{}, 0
+		c.rng.Seed(1)
+	}
+	cs.Memory.Reads, cs.Memory.Writes = 0, 0
+	cs.Log = cs.Log[:0]
+	cs.FetchCycles, cs.DataCycles, cs.Fetches, cs.DataOps, cs.TotalCycles = 0, 0, 0, 0, 0
+}
+
+// first returns the level an access starts at: the L1 cache, or whatever is
+// below it when it is left out
+func (cs *CacheSystem) first(l1 *Cache) MemoryLevel {
+	switch {
+	case l1 != nil:
+		return l1
+	case cs.L2 != nil:
+		return cs.L2
+	}
+	return cs.Memory
+}
+
+// fetch models the instruction fetch of a new instruction and clears the
+// log of the previous one
+func (cs *CacheSystem) fetch(pc int) {
+	cs.Log = cs.Log[:0]
+	cs.DataCycles = 0
+	cs.FetchCycles = cs.first(cs.L1I).Access(pc, false)
+	cs.Fetches++
+	cs.TotalCycles += cs.FetchCycles
+}
+
+// data models a load or store
+func (cs *CacheSystem) data(addr int, write bool) {
+	cycles := cs.first(cs.L1D).Access(addr, write)
+	cs.DataCycles += cycles
+	cs.DataOps++
+	cs.TotalCycles += cycles
+}
+
+// ParseCacheConfig reads a cache description such as
+// "size=1024,block=16,ways=2,replace=LRU,write=back,latency=1". Anything
+// left out keeps the default: 1024 bytes, 16 byte blocks, direct mapped,
+// LRU, write-back and 1 cycle hits.
+func ParseCacheConfig(name string, spec string) (CacheConfig, error) {
+	config := CacheConfig{Name: name, Size: 1024, BlockSize: 16, Ways: 1, Replacement: "LRU", WriteBack: true, Latency: 1}
+	if strings.TrimSpace(spec) == "" {
+		return config, nil
+	}
+	for _, part := range strings.Split(spec, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return config, fmt.Errorf("legv8: %s: %q is not key=value", name, part)
+		}
+		var err error
+		switch key {
+		case "size":
+			config.Size, err = strconv.Atoi(value)
+		case "block":
+			config.BlockSize, err = strconv.Atoi(value)
+		case "ways":
+			config.Ways, err = strconv.Atoi(value)
+		case "latency":
+			config.Latency, err = strconv.Atoi(value)
+		case "replace":
+			config.Replacement = value
+			if strings.EqualFold(value, "lru") || strings.EqualFold(value, "fifo") {
+				config.Replacement = strings.ToUpper(value)
+			}
+		case "write":
+			switch value {
+			case "back":
+				config.WriteBack = true
+			case "through":
+				config.WriteBack = false
+			default:
+				err = fmt.Errorf("write must be back or through")
+			}
+		default:
+			err = fmt.Errorf("unknown setting")
+		}
+		if err != nil {
+			return config, fmt.Errorf("legv8: %s: %s: %v", name, part, err)
+		}
+	}
+	return config, nil
+}
+
+// PrintCacheStats writes the statistics of every cache and of main memory.
+func PrintCacheStats(w io.Writer, cs *CacheSystem) {
+	fmt.Fprintln(w, "====================")
+	fmt.Fprintln(w, "Cache statistics:")
+	for _, c := range []*Cache{cs.L1I, cs.L1D, cs.L2} {
+		if c == nil {
+			continue
+		}
+		policy := "write-through"
+		if c.Config.WriteBack {
+			policy = "write-back"
+		}
+		accesses := c.Stats.Reads + c.Stats.Writes
+		misses := c.Stats.ReadMisses + c.Stats.WriteMisses
+		rate := 0.0
+		if accesses > 0 {
+			rate = 100 * float64(accesses-misses) / float64(accesses)
+		}
+		fmt.Fprintf(w, "%s:\t%d bytes, %d byte blocks, %d way, %s, %s, %d cycle hits\n", c.Config.Name,
+			c.Config.Size, c.Config.BlockSize, c.Config.Ways, c.Config.Replacement, policy, c.Config.Latency)
+		fmt.Fprintf(w, "\treads %d (%d misses)\twrites %d (%d misses)\thit rate %.2f%%\twritebacks %d\n",
+			c.Stats.Reads, c.Stats.ReadMisses, c.Stats.Writes, c.Stats.WriteMisses, rate, c.Stats.Writebacks)
+	}
+	fmt.Fprintf(w, "MEM:\t%d cycles\treads %d\twrites %d\n", cs.Memory.Latency, cs.Memory.Reads, cs.Memory.Writes)
+	fmt.Fprintf(w, "Memory cycles:\t%d over %d fetches and %d data accesses\n", cs.TotalCycles, cs.Fetches, cs.DataOps)
+}
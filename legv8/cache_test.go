@@ -0,0 +1,162 @@
+package legv8
+
+import (
+	"io"
+	"testing"
+)
+
+// newTestCache returns a cache of 16 byte blocks in front of a 10 cycle memory
+func newTestCache(t *testing.T, size, ways int, replacement string, writeBack bool) (*Cache, *MainMemory) {
+	t.Helper()
+	mem := &MainMemory{Latency: 10}
+	c, err := NewCache(CacheConfig{"L1D", size, 16, ways, replacement, writeBack, 1}, mem)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, mem
+}
+
+func TestCacheHitsAndMisses(t *testing.T) {
+	c, mem := newTestCache(t, 64, 1, "LRU", true)
+	for _, access := range []struct {
+		addr   int
+		cycles int
+	}{
+		{0, 11},  // miss
+		{4, 1},   // same block
+		{64, 11}, // same set, replaces block 0
+		{0, 11},
+		{16, 11}, // next set
+	} {
+		if got := c.Access(access.addr, false); got != access.cycles {
+			t.Errorf("read of %d took %d cycles, want %d", access.addr, got, access.cycles)
+		}
+	}
+	if c.Stats.Reads != 5 || c.Stats.ReadMisses != 4 || mem.Reads != 4 {
+		t.Errorf("stats are %+v with %d memory reads, want 5 reads, 4 misses", c.Stats, mem.Reads)
+	}
+}
+
+func TestCacheReplacement(t *testing.T) {
+	// 2 sets of 2 ways, 0, 32 and 64 all go in set 0
+	for _, test := range []struct {
+		replacement string
+		hit         bool // whether 0 is still there after 64 is added
+	}{
+		{"LRU", true},
+		{"FIFO", false},
+	} {
+		c, _ := newTestCache(t, 64, 2, test.replacement, true)
+		for _, addr := range []int{0, 32, 0, 64} {
+			c.Access(addr, false)
+		}
+		misses := c.Stats.ReadMisses
+		c.Access(0, false)
+		if hit := c.Stats.ReadMisses == misses; hit != test.hit {
+			t.Errorf("%s: reading 0 again hit is %v, want %v", test.replacement, hit, test.hit)
+		}
+	}
+}
+
+func TestCacheWritePolicies(t *testing.T) {
+	c, mem := newTestCache(t, 64, 1, "LRU", true)
+	c.Access(0, true)   // allocates a dirty block
+	c.Access(8, true)   // hits it
+	c.Access(64, false) // evicts it
+	if c.Stats.Writebacks != 1 || mem.Writes != 1 || mem.Reads != 2 {
+		t.Errorf("write-back: %+v with %d memory reads and %d writes, want 1 writeback", c.Stats, mem.Reads, mem.Writes)
+	}
+
+	c, mem = newTestCache(t, 64, 1, "LRU", false)
+	c.Access(0, true) // no write allocate
+	c.Access(0, false)
+	c.Access(0, true) // hit, written through
+	if c.Stats.WriteMisses != 1 || c.Stats.ReadMisses != 1 || mem.Writes != 2 || c.Stats.Writebacks != 0 {
+		t.Errorf("write-through: %+v with %d memory writes, want 1 write miss, 1 read miss and 2 writes", c.Stats, mem.Writes)
+	}
+}
+
+// TestCacheFetchWithoutL1I checks fetches go to the L2 or to memory when
+// there is no instruction cache, the same as data accesses do
+func TestCacheFetchWithoutL1I(t *testing.T) {
+	l1d, err := ParseCacheConfig("L1D", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l2, err := ParseCacheConfig("L2", "size=4096,latency=4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs, err := NewCacheSystem(nil, &l1d, &l2, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs.fetch(96)
+	cs.fetch(100)
+	if cs.L2.Stats.Reads != 2 || cs.L2.Stats.ReadMisses != 1 || cs.FetchCycles != 4 {
+		t.Errorf("L2 stats are %+v and the last fetch took %d cycles, want 2 reads, 1 miss and 4 cycles", cs.L2.Stats, cs.FetchCycles)
+	}
+
+	cs, err = NewCacheSystem(nil, &l1d, nil, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs.fetch(96)
+	if cs.Memory.Reads != 1 || cs.FetchCycles != 10 {
+		t.Errorf("fetch made %d memory reads in %d cycles, want 1 in 10", cs.Memory.Reads, cs.FetchCycles)
+	}
+}
+
+func TestCacheMachine(t *testing.T) {
+	program := assemble(t, `
+	ADDI X1, XZR, #5
+	STUR X1, [XZR, #16]
+	LDUR X2, [XZR, #16]
+	LDUR X3, [XZR, #17]
+	BREAK`)
+	l1i, _ := ParseCacheConfig("L1I", "")
+	l1d, _ := ParseCacheConfig("L1D", "size=256,block=8,ways=2,replace=FIFO,write=through")
+	cs, err := NewCacheSystem(&l1i, &l1d, nil, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewMachine()
+	m.Load(program)
+	m.Caches = cs
+	if err := SimInstructions(m, io.Discard, Full); err != nil {
+		t.Fatal(err)
+	}
+	// the store misses without allocating, the loads miss then hit the next word
+	if got := cs.L1D.Stats; got != (CacheStats{Reads: 2, ReadMisses: 1, Writes: 1, WriteMisses: 1}) {
+		t.Errorf("L1D stats are %+v", got)
+	}
+	if cs.L1I.Stats.Reads != 5 || cs.L1I.Stats.ReadMisses != 2 || cs.Fetches != 5 {
+		t.Errorf("L1I stats are %+v over %d fetches, want 5 reads with 2 misses", cs.L1I.Stats, cs.Fetches)
+	}
+	if m.Registers[2] != 5 {
+		t.Errorf("X2 is %d, want 5", m.Registers[2])
+	}
+
+	m.Reset()
+	if cs.L1I.Stats != (CacheStats{}) || cs.Fetches != 0 {
+		t.Errorf("after Reset the L1I stats are %+v", cs.L1I.Stats)
+	}
+}
+
+func TestParseCacheConfigErrors(t *testing.T) {
+	for _, spec := range []string{"size", "size=x", "write=around", "color=red"} {
+		if _, err := ParseCacheConfig("L1D", spec); err == nil {
+			t.Errorf("%q parsed without an error", spec)
+		}
+	}
+	for _, config := range []CacheConfig{
+		{"L1D", 1024, 12, 1, "LRU", true, 1},  // block not a power of two
+		{"L1D", 1000, 16, 1, "LRU", true, 1},  // not a whole number of sets
+		{"L1D", 1024, 16, 1, "MRU", true, 1},  // unknown replacement
+		{"L1D", 1024, 16, 1, "LRU", true, -1}, // negative latency
+	} {
+		if _, err := NewCache(config, &MainMemory{}); err == nil {
+			t.Errorf("%+v was accepted", config)
+		}
+	}
+}
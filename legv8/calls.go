@@ -0,0 +1,57 @@
+package legv8
+
+import (
+	"fmt"
+	"io"
+)
+
+// register roles in the LEGv8 calling convention
+const (
+	SP = 28 // stack pointer
+	FP = 29 // frame pointer
+	LR = 30 // link register, BL writes the return address here
+)
+
+// Frame is one entry of the shadow call stack the machine keeps for BL.
+type Frame struct {
+	Function int // address BL jumped to
+	CallSite int // address of the BL
+	Return   int // address BL wrote to LR
+}
+
+// call pushes a frame for a BL at the current PC
+func (m *Machine) call(target int) {
+	m.CallStack = append(m.CallStack, Frame{Function: target, CallSite: m.PC, Return: m.PC + 4})
+}
+
+// ret pops frames when a BR goes back to one of the return addresses on the
+// call stack. A BR anywhere else is a plain jump and leaves the stack alone.
+func (m *Machine) ret(target int) {
+	for i := len(m.CallStack) - 1; i >= 0; i-- {
+		if m.CallStack[i].Return == target {
+			m.CallStack = m.CallStack[:i]
+			return
+		}
+	}
+}
+
+// Depth returns the number of calls that have not returned yet.
+func (m *Machine) Depth() int {
+	return len(m.CallStack)
+}
+
+// PrintBacktrace writes the call stack of the machine, innermost call first,
+// pc is the address the machine stopped at.
+func PrintBacktrace(w io.Writer, m *Machine, pc int) {
+	fmt.Fprintln(w, "Backtrace:")
+	for i := len(m.CallStack); i >= 0; i-- {
+		function := BaseAddress
+		if i > 0 {
+			function = m.CallStack[i-1].Function
+		}
+		fmt.Fprintf(w, "#%d\t%d\tin %d\n", len(m.CallStack)-i, pc, function)
+		if i > 0 {
+			pc = m.CallStack[i-1].CallSite
+		}
+	}
+}
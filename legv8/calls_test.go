@@ -0,0 +1,83 @@
+package legv8
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// loadLines returns a machine loaded with the binary lines of a program
+func loadLines(t *testing.T, lines ...string) *Machine {
+	t.Helper()
+	program, err := ReadFile(strings.NewReader(strings.Join(lines, "\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := InitializeInstructions(program); err != nil {
+		t.Fatal(err)
+	}
+	m := NewMachine()
+	m.Load(program)
+	return m
+}
+
+const (
+	bl2   = "10010100000000000000000000000010" // BL #2
+	brLR  = "11010110000000000000001111000000" // BR R30
+	addi1 = "10010001000000000000010000100001" // ADDI R1, R1, #1
+	b3    = "00010100000000000000000000000011" // B #3
+	nop   = "00000000000000000000000000000000"
+)
+
+func TestCallAndReturn(t *testing.T) {
+	m := loadLines(t, bl2, b3, addi1, brLR, breakWord)
+	var depths []int
+	err := m.Run(func(m *Machine, inst Instruction) {
+		depths = append(depths, m.Depth())
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := depths, []int{1, 1, 0, 0, 0}; !equalInts(got, want) {
+		t.Errorf("call depth after each cycle is %v, want %v", got, want)
+	}
+	if m.Registers[LR] != 100 || m.Registers[1] != 1 {
+		t.Errorf("LR is %d and X1 is %d, want 100 and 1", m.Registers[LR], m.Registers[1])
+	}
+}
+
+func TestBacktrace(t *testing.T) {
+	// BREAK inside the function BL called
+	m := loadLines(t, bl2, nop, breakWord)
+	var out bytes.Buffer
+	if err := SimInstructions(m, &out, Full); err != nil {
+		t.Fatal(err)
+	}
+	want := "====================\nBacktrace:\n#0\t104\tin 104\n#1\t96\tin 96\n"
+	if got := out.String(); !strings.HasSuffix(got, want) {
+		t.Errorf("output ends in\n%s\nwant\n%s", got[strings.LastIndex(got, "Data:"):], want)
+	}
+
+	// a BR that is not a return leaves the call stack alone
+	m = loadLines(t, bl2, nop, "10010001000000000110000000111110", brLR, breakWord) // ADDI R30, R1, #24
+	for i := 0; i < 3; i++ {
+		if _, err := m.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if m.PC != 24 || m.Depth() != 1 {
+		t.Errorf("after BR to 24 the PC is %d and the depth %d, want 24 and 1", m.PC, m.Depth())
+	}
+}
+
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,171 @@
+package legv8
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// CheckpointVersion is the version of the checkpoint file format, a
+// checkpoint with any other version is refused.
+const CheckpointVersion = 1
+
+// Checkpoint is the full architectural state of a machine, saved as JSON.
+type Checkpoint struct {
+	Version   int
+	Program   string // sha256 of the program lines, a checkpoint only restores into the same program
+	Registers [32]int64
+	Flags     Flags
+	PC        int
+	Cycle     int
+	Halted    bool
+	ExitCode  int
+	CallStack []Frame
+
+	ELR, ESR, FAR, VBAR int
+	SPSR                Flags
+	Masked              bool
+
+	MemorySize int
+	Memory     []MemoryRun                // every byte that has been written
+	Devices    map[string]json.RawMessage // state each device saved, by name
+}
+
+// MemoryRun is a run of consecutive bytes of memory, Data is hex.
+type MemoryRun struct {
+	Addr int
+	Data string
+}
+
+// programHash identifies a program by its lines
+func programHash(program []Instruction) string {
+	h := sha256.New()
+	for _, inst := range program {
+		io.WriteString(h, inst.RawInstruction+"\n")
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Checkpoint returns the state of the machine.
+func (m *Machine) Checkpoint() (*Checkpoint, error) {
+	c := &Checkpoint{
+		Version:    CheckpointVersion,
+		Program:    programHash(m.Program),
+		Registers:  m.Registers,
+		Flags:      m.Flags,
+		PC:         m.PC,
+		Cycle:      m.Cycle,
+		Halted:     m.Halted,
+		ExitCode:   m.ExitCode,
+		CallStack:  append([]Frame(nil), m.CallStack...),
+		ELR:        m.ELR,
+		ESR:        m.ESR,
+		FAR:        m.FAR,
+		VBAR:       m.VBAR,
+		SPSR:       m.SPSR,
+		Masked:     m.Masked,
+		MemorySize: m.Memory.Size,
+		Devices:    make(map[string]json.RawMessage),
+	}
+
+	var addrs []int
+	for addr := range m.Memory.bytes {
+		addrs = append(addrs, addr)
+	}
+	sort.Ints(addrs)
+	var run []byte
+	for i, addr := range addrs {
+		run = append(run, m.Memory.bytes[addr])
+		if i+1 == len(addrs) || addrs[i+1] != addr+1 {
+			c.Memory = append(c.Memory, MemoryRun{addr - len(run) + 1, hex.EncodeToString(run)})
+			run = nil
+		}
+	}
+
+	for _, mp := range m.Memory.devices {
+		saver, ok := mp.device.(Saver)
+		if !ok {
+			return nil, fmt.Errorf("legv8: checkpoint of %s: the device does not save its state", mp.device.Name())
+		}
+		state, err := saver.SaveState()
+		if err != nil {
+			return nil, fmt.Errorf("legv8: checkpoint of %s: %v", mp.device.Name(), err)
+		}
+		c.Devices[mp.device.Name()] = state
+	}
+	return c, nil
+}
+
+// Restore puts the machine into the state of a checkpoint. The machine has
+// to be loaded with the program the checkpoint was taken of.
+func (m *Machine) Restore(c *Checkpoint) error {
+	if c.Version != CheckpointVersion {
+		return fmt.Errorf("legv8: checkpoint version %d is not %d", c.Version, CheckpointVersion)
+	}
+	if c.Program != programHash(m.Program) {
+		return fmt.Errorf("legv8: checkpoint was taken of a different program")
+	}
+	if c.MemorySize != m.image.Size {
+		return fmt.Errorf("legv8: checkpoint has %d bytes of memory, the machine has %d", c.MemorySize, m.image.Size)
+	}
+
+	memory := NewMemory(c.MemorySize)
+	memory.devices = m.image.devices
+	for _, run := range c.Memory {
+		data, err := hex.DecodeString(run.Data)
+		if err != nil {
+			return fmt.Errorf("legv8: checkpoint memory at %d: %v", run.Addr, err)
+		}
+		for i, b := range data {
+			memory.set(run.Addr+i, b)
+		}
+	}
+	for _, mp := range memory.devices {
+		if state, ok := c.Devices[mp.device.Name()]; ok {
+			saver, ok := mp.device.(Saver)
+			if !ok {
+				return fmt.Errorf("legv8: checkpoint of %s: the device does not restore its state", mp.device.Name())
+			}
+			if err := saver.RestoreState(state); err != nil {
+				return fmt.Errorf("legv8: checkpoint of %s: %v", mp.device.Name(), err)
+			}
+		}
+	}
+
+	m.Memory = memory
+	m.Registers = c.Registers
+	m.Flags = c.Flags
+	m.PC = c.PC
+	m.Cycle = c.Cycle
+	m.Halted = c.Halted
+	m.ExitCode = c.ExitCode
+	m.CallStack = append([]Frame(nil), c.CallStack...)
+	m.ELR, m.ESR, m.FAR, m.VBAR, m.SPSR, m.Masked = c.ELR, c.ESR, c.FAR, c.VBAR, c.SPSR, c.Masked
+	m.Exception = nil
+	if m.Loops != nil {
+		m.Loops.reset()
+	}
+	return nil
+}
+
+// WriteCheckpoint writes a checkpoint as indented JSON.
+func WriteCheckpoint(w io.Writer, c *Checkpoint) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	return enc.Encode(c)
+}
+
+// ReadCheckpoint reads a checkpoint written by WriteCheckpoint.
+func ReadCheckpoint(r io.Reader) (*Checkpoint, error) {
+	var c Checkpoint
+	if err := json.NewDecoder(r).Decode(&c); err != nil {
+		return nil, fmt.Errorf("legv8: reading checkpoint: %v", err)
+	}
+	if c.Version != CheckpointVersion {
+		return nil, fmt.Errorf("legv8: checkpoint version %d is not %d", c.Version, CheckpointVersion)
+	}
+	return &c, nil
+}
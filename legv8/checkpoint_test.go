@@ -0,0 +1,109 @@
+package legv8
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// plainDevice is a device that does not save its state
+type plainDevice struct{}
+
+func (plainDevice) Name() string                                   { return "plain" }
+func (plainDevice) Size() int                                      { return 8 }
+func (plainDevice) Read(offset int, size int) (uint64, error)      { return 0, nil }
+func (plainDevice) Write(offset int, size int, value uint64) error { return nil }
+
+// checkpointProgram sums 10 down to 1 into memory, calling a function for
+// each step
+const checkpointProgram = `
+	ADDI X1, XZR, #10
+	B    loop
+add:	LDURSW X2, [XZR, #200]
+	ADD  X2, X2, X1
+	STURW X2, [XZR, #200]
+	BR   LR
+loop:	BL   add
+	SUBIS X1, X1, #1
+	B.NE loop
+	BREAK`
+
+// deviceMachine returns a machine with the default devices loaded with a
+// program
+func deviceMachine(t *testing.T, program []Instruction) *Machine {
+	t.Helper()
+	m := NewMachine()
+	if err := m.MapDefaultDevices(); err != nil {
+		t.Fatal(err)
+	}
+	m.Load(program)
+	return m
+}
+
+func TestCheckpointRoundTrip(t *testing.T) {
+	program := assemble(t, checkpointProgram)
+	m := deviceMachine(t, program)
+	for i := 0; i < 12; i++ {
+		if _, err := m.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c, err := m.Checkpoint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved bytes.Buffer
+	if err := WriteCheckpoint(&saved, c); err != nil {
+		t.Fatal(err)
+	}
+
+	// a fresh machine with the same program picks up where m was
+	fresh := deviceMachine(t, program)
+	read, err := ReadCheckpoint(&saved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fresh.Restore(read); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := fresh.Checkpoint(); !reflect.DeepEqual(got, c) {
+		t.Errorf("restored state is %+v, want %+v", got, c)
+	}
+	if got, _ := fresh.Memory.Load(TimerBase, 8); got != 12 {
+		t.Errorf("restored timer count is %d, want 12", got)
+	}
+	if err := fresh.Run(nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Run(nil); err != nil {
+		t.Fatal(err)
+	}
+	if fresh.Registers != m.Registers || fresh.Memory.Word(200) != 55 || m.Memory.Word(200) != 55 {
+		t.Errorf("the restored run ends with %v and %d, the original with %v and %d", fresh.Registers, fresh.Memory.Word(200), m.Registers, m.Memory.Word(200))
+	}
+
+	other := deviceMachine(t, assemble(t, "NOP\nBREAK"))
+	if err := other.Restore(read); err == nil || !strings.Contains(err.Error(), "different program") {
+		t.Errorf("restoring into another program returned %v", err)
+	}
+}
+
+func TestCheckpointDevices(t *testing.T) {
+	m := NewMachine()
+	if err := m.Map(0xFF00, plainDevice{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Checkpoint(); err == nil || !strings.Contains(err.Error(), "plain") {
+		t.Errorf("checkpoint of a device that does not save its state returned %v", err)
+	}
+}
+
+func TestReadCheckpointVersion(t *testing.T) {
+	if _, err := ReadCheckpoint(strings.NewReader(`{"Version": 2}`)); err == nil {
+		t.Error("a checkpoint of another version was read")
+	}
+	if _, err := ReadCheckpoint(strings.NewReader(`{"Version": `)); err == nil {
+		t.Error("a broken checkpoint was read")
+	}
+}
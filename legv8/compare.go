@@ -0,0 +1,314 @@
+package legv8
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DisLine is one parsed line of a _dis.txt file.
+type DisLine struct {
+	Line int    // line number in the file
+	Bits string // the 32 bits without the spaces between fields
+	PC   int
+	Text string // what follows the pc: the assembly, BREAK or a data value
+}
+
+// SimRecord is one parsed block of a _sim.txt file. Blocks are separated by
+// the ==================== lines, cycle blocks are split into their parts and
+// anything else, the backtrace and statistics, is kept as lines.
+type SimRecord struct {
+	Line      int  // line number the block starts on
+	IsCycle   bool // the fields below are only set for cycle blocks
+	Cycle     int
+	PC        int
+	Text      string
+	Registers [32]int64
+	Flags     string      // "" when the file has no flags line
+	Depth     int         // -1 when the file has no depth line
+	Data      map[int]int // word address to value
+	Other     []string    // lines that are not one of the above
+}
+
+// Divergence is the first place two outputs differ.
+type Divergence struct {
+	Line    int      // line it starts on in the output being checked
+	RefLine int      // line it starts on in the reference
+	Diffs   []string // every difference in the first record that differs
+	Context []string // the reference records in front of it, oldest first
+}
+
+// normalize collapses runs of white space to one space and puts one space
+// after every comma, so "R3,R1" and "R3 , R1" read as "R3, R1"
+func normalize(text string) string {
+	text = strings.Join(strings.Fields(text), " ")
+	text = strings.ReplaceAll(strings.ReplaceAll(text, " ,", ","), ", ", ",")
+	return strings.ReplaceAll(text, ",", ", ")
+}
+
+// ParseDisassembly reads a _dis.txt file.
+func ParseDisassembly(r io.Reader) ([]DisLine, error) {
+	var lines []DisLine
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		line := DisLine{Line: n}
+		i := 0
+		for i < len(fields) && len(line.Bits) < 32 && strings.Trim(fields[i], "01") == "" {
+			line.Bits += fields[i]
+			i++
+		}
+		if i == len(fields) {
+			return nil, fmt.Errorf("legv8: line %d: no address after the binary", n)
+		}
+		pc, err := strconv.Atoi(fields[i])
+		if err != nil {
+			return nil, fmt.Errorf("legv8: line %d: %q is not an address", n, fields[i])
+		}
+		line.PC, line.Text = pc, normalize(strings.Join(fields[i+1:], " "))
+		lines = append(lines, line)
+	}
+	return lines, scanner.Err()
+}
+
+// ParseSimulation reads a _sim.txt file.
+func ParseSimulation(r io.Reader) ([]SimRecord, error) {
+	var records []SimRecord
+	var rec *SimRecord
+	inData := false
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	for n := 1; scanner.Scan(); n++ {
+		text := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(text, "=====") {
+			records = append(records, SimRecord{Line: n + 1, Depth: -1})
+			rec, inData = &records[len(records)-1], false
+			continue
+		}
+		if text == "" {
+			continue
+		}
+		if rec == nil {
+			records = append(records, SimRecord{Line: n, Depth: -1})
+			rec = &records[len(records)-1]
+		}
+		fields := strings.Fields(text)
+		label := strings.ToLower(fields[0])
+		switch {
+		case strings.HasPrefix(label, "cycle:") && !rec.IsCycle && len(rec.Other) == 0:
+			// "Cycle:7 112 SUB R3, R1, R2", with or without a space after the colon
+			if label == "cycle:" && len(fields) > 1 {
+				fields = append([]string{"cycle:" + fields[1]}, fields[2:]...)
+			}
+			cycle, err1 := strconv.Atoi(strings.TrimPrefix(strings.ToLower(fields[0]), "cycle:"))
+			if len(fields) < 2 {
+				return nil, fmt.Errorf("legv8: line %d: cycle line has no address", n)
+			}
+			pc, err2 := strconv.Atoi(fields[1])
+			if err1 != nil || err2 != nil {
+				return nil, fmt.Errorf("legv8: line %d: %q is not a cycle line", n, text)
+			}
+			rec.IsCycle, rec.Cycle, rec.PC, rec.Text = true, cycle, pc, normalize(strings.Join(fields[2:], " "))
+		case !rec.IsCycle:
+			rec.Other = append(rec.Other, normalize(text))
+		case label == "registers:":
+			inData = false
+		case label == "data:":
+			inData = true
+		case len(label) == 4 && label[0] == 'r' && label[3] == ':' && !inData:
+			base, err := strconv.Atoi(label[1:3])
+			if err != nil || base+len(fields)-1 > 32 {
+				return nil, fmt.Errorf("legv8: line %d: %q is not a register row", n, text)
+			}
+			for i, field := range fields[1:] {
+				if rec.Registers[base+i], err = strconv.ParseInt(field, 10, 64); err != nil {
+					return nil, fmt.Errorf("legv8: line %d: %q is not a register value", n, field)
+				}
+			}
+		case label == "flags:":
+			rec.Flags = normalize(strings.Join(fields[1:], " "))
+		case label == "depth:" && len(fields) == 2:
+			rec.Depth, _ = strconv.Atoi(fields[1])
+		case inData && strings.HasSuffix(label, ":"):
+			addr, err := strconv.Atoi(strings.TrimSuffix(label, ":"))
+			if err != nil {
+				return nil, fmt.Errorf("legv8: line %d: %q is not a data row", n, text)
+			}
+			if rec.Data == nil {
+				rec.Data = make(map[int]int)
+			}
+			for i, field := range fields[1:] {
+				if rec.Data[addr+4*i], err = strconv.Atoi(field); err != nil {
+					return nil, fmt.Errorf("legv8: line %d: %q is not a data value", n, field)
+				}
+			}
+		default:
+			rec.Other = append(rec.Other, normalize(text))
+		}
+	}
+	// a trailing separator starts an empty block
+	if len(records) > 0 && !records[len(records)-1].IsCycle && len(records[len(records)-1].Other) == 0 {
+		records = records[:len(records)-1]
+	}
+	return records, scanner.Err()
+}
+
+// CompareDisassembly returns where got first differs from want, or nil.
+func CompareDisassembly(got, want []DisLine, context int) *Divergence {
+	for i := 0; i < len(got) || i < len(want); i++ {
+		var diffs []string
+		switch {
+		case i >= len(got):
+			diffs = append(diffs, fmt.Sprintf("pc %d: expected %q, got the end of the output", want[i].PC, want[i].Text))
+		case i >= len(want):
+			diffs = append(diffs, fmt.Sprintf("pc %d: expected the end of the output, got %q", got[i].PC, got[i].Text))
+		default:
+			g, w := got[i], want[i]
+			if g.PC != w.PC {
+				diffs = append(diffs, fmt.Sprintf("line %d: pc expected %d got %d", w.Line, w.PC, g.PC))
+			}
+			if g.Bits != w.Bits {
+				diffs = append(diffs, fmt.Sprintf("pc %d: binary expected %s got %s", w.PC, w.Bits, g.Bits))
+			}
+			if g.Text != w.Text {
+				diffs = append(diffs, fmt.Sprintf("pc %d: expected %q got %q", w.PC, w.Text, g.Text))
+			}
+		}
+		if diffs == nil {
+			continue
+		}
+		d := &Divergence{Diffs: diffs, Line: lineAt(disLines(got), i), RefLine: lineAt(disLines(want), i)}
+		for j := maxInt(0, i-context); j < i && j < len(want); j++ {
+			d.Context = append(d.Context, fmt.Sprintf("%d %s", want[j].PC, want[j].Text))
+		}
+		return d
+	}
+	return nil
+}
+
+// CompareSimulation returns where got first differs from want, or nil.
+func CompareSimulation(got, want []SimRecord, context int) *Divergence {
+	for i := 0; i < len(got) || i < len(want); i++ {
+		var diffs []string
+		switch {
+		case i >= len(got):
+			diffs = append(diffs, fmt.Sprintf("%s: expected it, got the end of the output", want[i].name()))
+		case i >= len(want):
+			diffs = append(diffs, fmt.Sprintf("%s: expected the end of the output", got[i].name()))
+		default:
+			diffs = compareRecords(got[i], want[i])
+		}
+		if diffs == nil {
+			continue
+		}
+		d := &Divergence{Diffs: diffs, Line: lineAt(simLines(got), i), RefLine: lineAt(simLines(want), i)}
+		for j := maxInt(0, i-context); j < i && j < len(want); j++ {
+			d.Context = append(d.Context, want[j].name())
+		}
+		return d
+	}
+	return nil
+}
+
+// name describes a record in messages
+func (rec SimRecord) name() string {
+	if rec.IsCycle {
+		return fmt.Sprintf("cycle %d: %d %s", rec.Cycle, rec.PC, rec.Text)
+	}
+	if len(rec.Other) > 0 {
+		return rec.Other[0]
+	}
+	return "empty block"
+}
+
+// compareRecords lists the differences between two blocks
+func compareRecords(g, w SimRecord) []string {
+	if g.IsCycle != w.IsCycle {
+		return []string{fmt.Sprintf("expected %q got %q", w.name(), g.name())}
+	}
+	var diffs []string
+	add := func(format string, args ...interface{}) {
+		diffs = append(diffs, fmt.Sprintf(format, args...))
+	}
+	prefix := ""
+	if w.IsCycle {
+		prefix = fmt.Sprintf("cycle %d: ", w.Cycle)
+		if g.Cycle != w.Cycle {
+			add("%scycle number expected %d got %d", prefix, w.Cycle, g.Cycle)
+		}
+		if g.PC != w.PC || g.Text != w.Text {
+			add("%sinstruction expected %d %s got %d %s", prefix, w.PC, w.Text, g.PC, g.Text)
+		}
+		for r := range w.Registers {
+			if g.Registers[r] != w.Registers[r] {
+				add("%sR%d expected %d got %d", prefix, r, w.Registers[r], g.Registers[r])
+			}
+		}
+		if g.Flags != "" && w.Flags != "" && g.Flags != w.Flags {
+			add("%sflags expected %s got %s", prefix, w.Flags, g.Flags)
+		}
+		if g.Depth >= 0 && w.Depth >= 0 && g.Depth != w.Depth {
+			add("%scall depth expected %d got %d", prefix, w.Depth, g.Depth)
+		}
+		var addrs []int
+		for addr := range w.Data {
+			addrs = append(addrs, addr)
+		}
+		for addr := range g.Data {
+			if _, ok := w.Data[addr]; !ok {
+				addrs = append(addrs, addr)
+			}
+		}
+		sort.Ints(addrs)
+		for _, addr := range addrs {
+			if g.Data[addr] != w.Data[addr] {
+				add("%smemory %d expected %d got %d", prefix, addr, w.Data[addr], g.Data[addr])
+			}
+		}
+	}
+	for i := 0; i < len(g.Other) || i < len(w.Other); i++ {
+		switch {
+		case i >= len(g.Other):
+			add("%sexpected %q, got nothing", prefix, w.Other[i])
+		case i >= len(w.Other):
+			add("%sexpected nothing, got %q", prefix, g.Other[i])
+		case g.Other[i] != w.Other[i]:
+			add("%sexpected %q got %q", prefix, w.Other[i], g.Other[i])
+		}
+	}
+	return diffs
+}
+
+// lineAt returns the line record i starts on, or the line after the last
+// record when there are fewer, starts holds the line of every record
+func lineAt(starts []int, i int) int {
+	if i < len(starts) {
+		return starts[i]
+	}
+	if len(starts) > 0 {
+		return starts[len(starts)-1] + 1
+	}
+	return 1
+}
+
+func disLines(lines []DisLine) []int {
+	starts := make([]int, len(lines))
+	for i, line := range lines {
+		starts[i] = line.Line
+	}
+	return starts
+}
+
+func simLines(records []SimRecord) []int {
+	starts := make([]int, len(records))
+	for i, rec := range records {
+		starts[i] = rec.Line
+	}
+	return starts
+}
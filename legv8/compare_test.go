@@ -0,0 +1,226 @@
+package legv8
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const refSim = `====================
+Cycle:1	96	ADDI	R1, R31, #3
+
+Registers:
+r00:	0	3	0	0	0	0	0	0
+r08:	0	0	0	0	0	0	0	0
+r16:	0	0	0	0	0	0	0	0
+r24:	0	0	0	0	0	0	0	0
+flags:	N:0	Z:0	C:0	V:0
+depth:	0
+
+Data:
+====================
+Cycle:2	100	STUR	R1, [R31, #200]
+
+Registers:
+r00:	0	3	0	0	0	0	0	0
+r08:	0	0	0	0	0	0	0	0
+r16:	0	0	0	0	0	0	0	0
+r24:	0	0	0	0	0	0	0	0
+flags:	N:0	Z:0	C:0	V:0
+depth:	0
+
+Data:
+200:	3	0	0	0	0	0	0	0
+====================
+Backtrace:
+#0	104	in 96
+`
+
+func parseSim(t *testing.T, text string) []SimRecord {
+	t.Helper()
+	records, err := ParseSimulation(strings.NewReader(text))
+	if err != nil {
+		t.Fatalf("ParseSimulation: %v", err)
+	}
+	return records
+}
+
+func TestParseSimulation(t *testing.T) {
+	records := parseSim(t, refSim)
+	if len(records) != 3 {
+		t.Fatalf("%d records, want 2 cycles and the backtrace", len(records))
+	}
+	rec := records[1]
+	if !rec.IsCycle || rec.Cycle != 2 || rec.PC != 100 || rec.Text != "STUR R1, [R31, #200]" || rec.Line != 14 {
+		t.Errorf("second record is %+v", rec)
+	}
+	if rec.Registers[1] != 3 {
+		t.Errorf("registers are %v", rec.Registers)
+	}
+	if rec.Flags != "N:0 Z:0 C:0 V:0" || rec.Depth != 0 {
+		t.Errorf("flags %q and depth %d", rec.Flags, rec.Depth)
+	}
+	want := map[int]int{200: 3, 204: 0, 208: 0, 212: 0, 216: 0, 220: 0, 224: 0, 228: 0}
+	if !reflect.DeepEqual(rec.Data, want) {
+		t.Errorf("data is %v, want %v", rec.Data, want)
+	}
+	if back := records[2]; back.IsCycle || !reflect.DeepEqual(back.Other, []string{"Backtrace:", "#0 104 in 96"}) {
+		t.Errorf("last record is %+v", back)
+	}
+
+	// the first version printed no flags or depth lines
+	old := strings.ReplaceAll(strings.ReplaceAll(refSim, "flags:\tN:0\tZ:0\tC:0\tV:0\n", ""), "depth:\t0\n", "")
+	if rec := parseSim(t, old)[1]; rec.Flags != "" || rec.Depth != -1 {
+		t.Errorf("without the lines flags are %q and depth %d, want \"\" and -1", rec.Flags, rec.Depth)
+	}
+}
+
+func TestCompareSimulation(t *testing.T) {
+	tests := []struct {
+		name  string
+		edit  func(string) string
+		diffs []string // nil when the outputs match
+	}{
+		{"same", func(s string) string { return s }, nil},
+		{"spacing", func(s string) string {
+			return strings.ReplaceAll(s, "R1, [R31, #200]", "R1 ,[R31,  #200]")
+		}, nil},
+		{"no flags or depth lines", func(s string) string {
+			s = strings.ReplaceAll(s, "flags:\tN:0\tZ:0\tC:0\tV:0\n", "")
+			return strings.ReplaceAll(s, "depth:\t0\n", "")
+		}, nil},
+		{"register", func(s string) string {
+			return strings.Replace(s, "r00:\t0\t3", "r00:\t0\t4", 1)
+		}, []string{"cycle 1: R1 expected 3 got 4"}},
+		{"flags", func(s string) string {
+			return strings.Replace(s, "Z:0", "Z:1", 1)
+		}, []string{"cycle 1: flags expected N:0 Z:0 C:0 V:0 got N:0 Z:1 C:0 V:0"}},
+		{"memory", func(s string) string {
+			return strings.Replace(s, "200:\t3", "200:\t5", 1)
+		}, []string{"cycle 2: memory 200 expected 3 got 5"}},
+		{"instruction", func(s string) string {
+			return strings.Replace(s, "STUR", "LDUR", 1)
+		}, []string{"cycle 2: instruction expected 100 STUR R1, [R31, #200] got 100 LDUR R1, [R31, #200]"}},
+		{"missing block", func(s string) string {
+			return s[:strings.LastIndex(s, "====================")]
+		}, []string{"Backtrace:: expected it, got the end of the output"}},
+	}
+	want := parseSim(t, refSim)
+	for _, test := range tests {
+		d := CompareSimulation(parseSim(t, test.edit(refSim)), want, 1)
+		switch {
+		case test.diffs == nil && d != nil:
+			t.Errorf("%s: outputs differ: %v", test.name, d.Diffs)
+		case test.diffs != nil && d == nil:
+			t.Errorf("%s: outputs match, want %v", test.name, test.diffs)
+		case test.diffs != nil && !reflect.DeepEqual(d.Diffs, test.diffs):
+			t.Errorf("%s: differences are %q, want %q", test.name, d.Diffs, test.diffs)
+		}
+	}
+}
+
+func TestCompareSimulationPlace(t *testing.T) {
+	got := parseSim(t, strings.Replace(refSim, "200:\t3", "200:\t5", 1))
+	d := CompareSimulation(got, parseSim(t, refSim), 1)
+	if d == nil {
+		t.Fatal("outputs match")
+	}
+	if d.Line != 14 || d.RefLine != 14 || !reflect.DeepEqual(d.Context, []string{"cycle 1: 96 ADDI R1, R31, #3"}) {
+		t.Errorf("divergence at line %d (reference %d) after %v", d.Line, d.RefLine, d.Context)
+	}
+}
+
+func TestCompareDisassembly(t *testing.T) {
+	ref := "1001000100 000000000011 11111 00001 96 ADDI R1, R31, #3\n" +
+		"11111110110111101111111111100111 100 BREAK\n" +
+		"00000000000000000000000000000111 104 7\n"
+	tests := []struct {
+		name string
+		got  string
+		diff string // "" when the outputs match
+	}{
+		{"same", ref, ""},
+		{"grouping and spacing", strings.Replace(ref, "1001000100 000000000011 11111 00001 96 ADDI R1, R31, #3",
+			"10010001000000000000111111100001\t96\tADDI\tR1,R31,   #3", 1), ""},
+		{"bits", strings.Replace(ref, "11111 00001", "11111 00010", 1),
+			"pc 96: binary expected 10010001000000000000111111100001 got 10010001000000000000111111100010"},
+		{"text", strings.Replace(ref, "#3", "#4", 1), `pc 96: expected "ADDI R1, R31, #3" got "ADDI R1, R31, #4"`},
+		{"short", strings.TrimSuffix(ref, "00000000000000000000000000000111 104 7\n"),
+			`pc 104: expected "7", got the end of the output`},
+	}
+	want, err := ParseDisassembly(strings.NewReader(ref))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range tests {
+		got, err := ParseDisassembly(strings.NewReader(test.got))
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		d := CompareDisassembly(got, want, 2)
+		switch {
+		case test.diff == "" && d != nil:
+			t.Errorf("%s: outputs differ: %v", test.name, d.Diffs)
+		case test.diff != "" && (d == nil || len(d.Diffs) != 1 || d.Diffs[0] != test.diff):
+			t.Errorf("%s: divergence is %+v, want %q", test.name, d, test.diff)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	if _, err := ParseDisassembly(strings.NewReader("10001011000000100000000000100011\n")); err == nil {
+		t.Error("a disassembly line without an address parsed")
+	}
+	if _, err := ParseDisassembly(strings.NewReader("10001011000000100000000000100011 x ADD\n")); err == nil {
+		t.Error("a disassembly line with a bad address parsed")
+	}
+	for _, text := range []string{
+		"====================\nCycle:x\t96\tADD\n",
+		"====================\nCycle:1\n",
+		"====================\nCycle:1\t96\tADD\nr00:\t0\tx\n",
+		"====================\nCycle:1\t96\tADD\nr30:\t0\t0\t0\n",
+		"====================\nCycle:1\t96\tADD\nData:\n2x0:\t0\n",
+	} {
+		if _, err := ParseSimulation(strings.NewReader(text)); err == nil {
+			t.Errorf("%q parsed", text)
+		}
+	}
+}
+
+// TestCompareReference compares runs of addtest1 with what the reference
+// simulator wrote for it
+func TestCompareReference(t *testing.T) {
+	f, err := os.Open(filepath.Join("testdata", "addtest1_reference.sim"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	want, err := ParseSimulation(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		format Format
+		diffs  []string
+	}{
+		{Reference, nil},
+		{Full, []string{"Backtrace:: expected the end of the output"}}, // only the trailer differs
+	} {
+		m := NewMachine()
+		m.Load(load(t, "addtest1_bin.txt"))
+		var sim strings.Builder
+		if err := SimInstructions(m, &sim, test.format); err != nil {
+			t.Fatal(err)
+		}
+		d := CompareSimulation(parseSim(t, sim.String()), want, 1)
+		var diffs []string
+		if d != nil {
+			diffs = d.Diffs
+		}
+		if !reflect.DeepEqual(diffs, test.diffs) {
+			t.Errorf("format %d: differences are %q, want %q", test.format, diffs, test.diffs)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package legv8
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// Model is an execution model that can run a machine to the end, writing
+// its own trace to w.
+type Model struct {
+	Name string
+	Run  func(m *Machine, w io.Writer) error
+}
+
+// Snapshot is the architectural state right after an instruction retires.
+// Memory is only there as a hash, the words are looked at when two hashes
+// differ.
+type Snapshot struct {
+	Retired    int // instructions retired so far
+	Inst       Instruction
+	PC         int
+	Registers  [32]int64
+	Flags      Flags
+	Halted     bool
+	ExitCode   int
+	MemoryHash uint64
+
+	memory *Memory // the memory of the machine, it only holds still until the model is resumed
+}
+
+func snapshot(m *Machine, inst Instruction, retired int) Snapshot {
+	return Snapshot{Retired: retired, Inst: inst, PC: m.PC, Registers: m.Registers, Flags: m.Flags,
+		Halted: m.Halted, ExitCode: m.ExitCode, MemoryHash: m.Memory.hash, memory: m.Memory}
+}
+
+// CoSimReport is the outcome of a co-simulation. Mismatches is empty when
+// the two models agreed at every retirement, otherwise A and B are the
+// snapshots of the first retirement they disagree on. Expected in each
+// Mismatch is model A's value and Got model B's.
+type CoSimReport struct {
+	Retired    int // retirements both models agreed on
+	A, B       *Snapshot
+	Mismatches []Mismatch
+	ErrA, ErrB error // what each model stopped on, nil for BREAK
+}
+
+// cosimRun is one model running on its own goroutine. After sending a
+// state the model waits on resume, so its memory can be read until then.
+type cosimRun struct {
+	states chan Snapshot // closed once the model stops
+	resume chan struct{}
+	err    error // set before states is closed
+}
+
+// CoSimulate runs two models in lock step on machines newMachine returns,
+// comparing the architectural state every time an instruction retires, and
+// stops both at the first mismatch. The state is what the model hands
+// Machine.Retire: the machine itself for the models that retire in Step, the
+// committed state for the Tomasulo model.
+func CoSimulate(ctx context.Context, a, b Model, newMachine func() (*Machine, error)) (*CoSimReport, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := make(chan struct{})
+
+	var runs [2]*cosimRun
+	for i, model := range []Model{a, b} {
+		m, err := newMachine()
+		if err != nil {
+			return nil, err
+		}
+		run := &cosimRun{states: make(chan Snapshot), resume: make(chan struct{})}
+		runs[i] = run
+		retired := 0
+		m.Context = ctx
+		m.Retire = func(m *Machine, inst Instruction) {
+			retired++
+			select {
+			case run.states <- snapshot(m, inst, retired):
+			case <-stop:
+				return
+			}
+			select {
+			case <-run.resume:
+			case <-stop:
+			}
+		}
+		go func(model Model) {
+			run.err = model.Run(m, io.Discard)
+			close(run.states)
+		}(model)
+	}
+	finish := func(report *CoSimReport) (*CoSimReport, error) {
+		close(stop)
+		cancel()
+		for range runs[0].states {
+		}
+		for range runs[1].states {
+		}
+		if len(report.Mismatches) > 0 {
+			return report, nil
+		}
+		report.ErrA, report.ErrB = runs[0].err, runs[1].err
+		if errString(report.ErrA) != errString(report.ErrB) {
+			report.Mismatches = append(report.Mismatches, Mismatch{"stopped on", errString(report.ErrA), errString(report.ErrB)})
+		}
+		return report, nil
+	}
+
+	report := &CoSimReport{}
+	for {
+		sa, okA := <-runs[0].states
+		sb, okB := <-runs[1].states
+		switch {
+		case !okA && !okB:
+			return finish(report)
+		case !okA:
+			report.B = &sb
+			report.Mismatches = []Mismatch{{"retired", "the end of the run", InstructionString(sb.Inst)}}
+			return finish(report)
+		case !okB:
+			report.A = &sa
+			report.Mismatches = []Mismatch{{"retired", InstructionString(sa.Inst), "the end of the run"}}
+			return finish(report)
+		}
+		if mismatches := compareSnapshots(sa, sb); len(mismatches) > 0 {
+			report.A, report.B, report.Mismatches = &sa, &sb, mismatches
+			return finish(report)
+		}
+		report.Retired++
+		runs[0].resume <- struct{}{}
+		runs[1].resume <- struct{}{}
+	}
+}
+
+// compareSnapshots lists how two snapshots differ
+func compareSnapshots(a, b Snapshot) []Mismatch {
+	var mismatches []Mismatch
+	add := func(what string, x, y interface{}) {
+		mismatches = append(mismatches, Mismatch{what, fmt.Sprint(x), fmt.Sprint(y)})
+	}
+	if a.Inst.ProgramCnt != b.Inst.ProgramCnt {
+		add("instruction", fmt.Sprintf("%d %s", a.Inst.ProgramCnt, InstructionString(a.Inst)),
+			fmt.Sprintf("%d %s", b.Inst.ProgramCnt, InstructionString(b.Inst)))
+	}
+	if a.PC != b.PC {
+		add("pc", a.PC, b.PC)
+	}
+	for r := range a.Registers {
+		if a.Registers[r] != b.Registers[r] {
+			add(fmt.Sprintf("X%d", r), a.Registers[r], b.Registers[r])
+		}
+	}
+	if a.Flags != b.Flags {
+		add("flags", a.Flags, b.Flags)
+	}
+	if a.Halted != b.Halted {
+		add("halted", a.Halted, b.Halted)
+	}
+	if a.ExitCode != b.ExitCode {
+		add("exit code", a.ExitCode, b.ExitCode)
+	}
+	if a.MemoryHash != b.MemoryHash {
+		// both models are waiting to be resumed, so their memory is still
+		// the memory of this retirement
+		addrs := a.memory.Words()
+		inA := addrs[:len(addrs):len(addrs)]
+		for _, addr := range b.memory.Words() {
+			if i := sort.SearchInts(inA, addr); i == len(inA) || inA[i] != addr {
+				addrs = append(addrs, addr)
+			}
+		}
+		sort.Ints(addrs)
+		for _, addr := range addrs {
+			if x, y := a.memory.Word(addr), b.memory.Word(addr); x != y {
+				add(fmt.Sprintf("memory %d", addr), x, y)
+			}
+		}
+	}
+	return mismatches
+}
+
+func errString(err error) string {
+	if err == nil {
+		return "BREAK"
+	}
+	return err.Error()
+}
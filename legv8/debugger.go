@@ -0,0 +1,404 @@
+package legv8
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Debugger is an interactive prompt for stepping through a program on a
+// machine, with breakpoints and register and memory inspection.
+type Debugger struct {
+	Machine     *Machine
+	Labels      map[string]int // label addresses, nil when the program came from a binary file
+	Breakpoints map[int]bool   // addresses the machine stops in front of
+
+	out io.Writer
+}
+
+// NewDebugger returns a debugger for a loaded machine, labels may be nil.
+func NewDebugger(m *Machine, labels map[string]int) *Debugger {
+	return &Debugger{Machine: m, Labels: labels, Breakpoints: make(map[int]bool)}
+}
+
+// debugHelp is printed by the help command
+const debugHelp = `step [n]            run n instructions (s)
+next [n]            like step but runs BL calls to completion (n)
+finish              run until the current call returns
+continue            run until a breakpoint or BREAK (c)
+break <addr|label>  set a breakpoint (b)
+delete <addr|label> remove a breakpoint (d)
+info breakpoints    list breakpoints
+registers           print the registers and flags (r)
+print <reg>         print one register (p)
+set <reg> <value>   change a register
+x <addr> [n]        print n 32 bit words of memory
+setmem <addr> <value> [size]
+                    store size (1, 2, 4 or 8, default 4) bytes of memory
+disassemble [n]     print n instructions either side of the pc (dis)
+backtrace           print the call stack (bt)
+back [n]            undo the last n instructions (rs)
+rewind <cycle>      go back to the state after a cycle
+reverse-continue    run backwards to a breakpoint or the start (rc)
+lastwrite <reg|addr>
+                    print the last instruction that wrote a register or
+                    memory byte (lw)
+reset               start the program over
+quit                leave the debugger (q)`
+
+// Run reads commands from in until quit or the end of input.
+func (d *Debugger) Run(in io.Reader, out io.Writer) error {
+	d.out = out
+	scanner := bufio.NewScanner(in)
+	d.where()
+	for {
+		fmt.Fprint(out, "(legv8) ")
+		if !scanner.Scan() {
+			fmt.Fprintln(out)
+			return scanner.Err()
+		}
+		args := strings.Fields(scanner.Text())
+		if len(args) == 0 {
+			continue
+		}
+		if args[0] == "quit" || args[0] == "q" {
+			return nil
+		}
+		if err := d.command(args[0], args[1:]); err != nil {
+			fmt.Fprintln(out, err)
+		}
+	}
+}
+
+// command runs one debugger command
+func (d *Debugger) command(name string, args []string) error {
+	m := d.Machine
+	switch name {
+	case "help", "h":
+		fmt.Fprintln(d.out, debugHelp)
+	case "step", "s", "next", "n":
+		count, err := d.count(args, 1)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < count; i++ {
+			var stopped bool
+			if name == "next" || name == "n" {
+				stopped = d.next()
+			} else {
+				stopped = d.step()
+			}
+			if stopped {
+				return nil
+			}
+		}
+		d.where()
+	case "finish":
+		depth := m.Depth()
+		if depth == 0 {
+			return fmt.Errorf("not in a call")
+		}
+		if !d.runUntil(func() bool { return m.Depth() < depth }) {
+			d.where()
+		}
+	case "continue", "c":
+		if !d.runUntil(func() bool { return false }) {
+			d.where()
+		}
+	case "break", "b", "delete", "d":
+		if len(args) != 1 {
+			return fmt.Errorf("%s needs an address or label", name)
+		}
+		addr, err := d.address(args[0])
+		if err != nil {
+			return err
+		}
+		if name == "break" || name == "b" {
+			if _, err := m.Fetch(addr); err != nil {
+				return err
+			}
+			d.Breakpoints[addr] = true
+			fmt.Fprintf(d.out, "breakpoint at %d\n", addr)
+		} else {
+			if !d.Breakpoints[addr] {
+				return fmt.Errorf("no breakpoint at %d", addr)
+			}
+			delete(d.Breakpoints, addr)
+		}
+	case "info":
+		if len(args) != 1 || args[0] != "breakpoints" {
+			return fmt.Errorf("info breakpoints is the only info command")
+		}
+		var addrs []int
+		for addr := range d.Breakpoints {
+			addrs = append(addrs, addr)
+		}
+		sort.Ints(addrs)
+		for _, addr := range addrs {
+			fmt.Fprintf(d.out, "%d\t%s\n", addr, d.listing(addr))
+		}
+	case "registers", "r":
+		fmt.Fprintf(d.out, "r00:\t%s\nr08:\t%s\nr16:\t%s\nr24:\t%s\nflags:\t%s\npc:\t%d\n",
+			registerString(m, 8), registerString(m, 16), registerString(m, 24), registerString(m, 32), m.Flags, m.PC)
+	case "print", "p":
+		if len(args) != 1 {
+			return fmt.Errorf("print needs a register")
+		}
+		r, err := d.register(args[0])
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(d.out, "%s = %d\n", args[0], m.reg(r))
+	case "set":
+		if len(args) != 2 {
+			return fmt.Errorf("set needs a register and a value")
+		}
+		r, err := d.register(args[0])
+		if err != nil {
+			return err
+		}
+		value, err := strconv.ParseInt(args[1], 0, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", args[1])
+		}
+		if r == XZR {
+			return fmt.Errorf("XZR is always zero")
+		}
+		m.Registers[r] = value
+	case "x":
+		if len(args) < 1 || len(args) > 2 {
+			return fmt.Errorf("x needs an address and an optional count")
+		}
+		addr, err := d.address(args[0])
+		if err != nil {
+			return err
+		}
+		count, err := d.count(args[1:], 8)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < count; i++ {
+			word, err := m.Memory.Load(addr+4*i, 4)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(d.out, "%d:\t%d\n", addr+4*i, int32(word))
+		}
+	case "setmem":
+		if len(args) < 2 || len(args) > 3 {
+			return fmt.Errorf("setmem needs an address, a value and an optional size")
+		}
+		addr, err := d.address(args[0])
+		if err != nil {
+			return err
+		}
+		value, err := strconv.ParseInt(args[1], 0, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", args[1])
+		}
+		size, err := d.count(args[2:], 4)
+		if err != nil {
+			return err
+		}
+		return m.Memory.Store(addr, size, uint64(value))
+	case "disassemble", "dis":
+		count, err := d.count(args, 5)
+		if err != nil {
+			return err
+		}
+		for addr := m.PC - 4*count; addr <= m.PC+4*count; addr += 4 {
+			if _, err := m.Fetch(addr); err != nil {
+				continue
+			}
+			marker := "  "
+			if addr == m.PC {
+				marker = "=>"
+			}
+			if d.Breakpoints[addr] {
+				marker = marker[:1] + "*"
+			}
+			fmt.Fprintf(d.out, "%s %d\t%s\n", marker, addr, d.listing(addr))
+		}
+	case "backtrace", "bt":
+		PrintBacktrace(d.out, m, m.PC)
+	case "back", "rs":
+		count, err := d.count(args, 1)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < count; i++ {
+			if err := m.StepBack(); err != nil {
+				return err
+			}
+		}
+		d.where()
+	case "rewind":
+		if len(args) != 1 {
+			return fmt.Errorf("rewind needs a cycle")
+		}
+		cycle, err := strconv.Atoi(args[0])
+		if err != nil || cycle < 0 {
+			return fmt.Errorf("%q is not a cycle", args[0])
+		}
+		if err := m.Rewind(cycle); err != nil {
+			return err
+		}
+		d.where()
+	case "reverse-continue", "rc":
+		for first := true; first || !d.Breakpoints[m.PC]; first = false {
+			if err := m.StepBack(); err != nil {
+				if first {
+					return err
+				}
+				fmt.Fprintf(d.out, "start of the history at cycle %d\n", m.Cycle)
+				break
+			}
+		}
+		d.where()
+	case "lastwrite", "lw":
+		if len(args) != 1 {
+			return fmt.Errorf("lastwrite needs a register or an address")
+		}
+		if m.History == nil {
+			return fmt.Errorf("no history is being recorded")
+		}
+		var w Write
+		var found bool
+		if r, err := d.register(args[0]); err == nil {
+			w, found = m.History.LastRegisterWrite(int(r))
+		} else if addr, err := d.address(args[0]); err == nil {
+			w, found = m.History.LastMemoryWrite(addr)
+		} else {
+			return fmt.Errorf("%q is not a register or address", args[0])
+		}
+		if !found {
+			return fmt.Errorf("%s was not written since cycle %d", args[0], m.Oldest())
+		}
+		fmt.Fprintf(d.out, "%s\t%s\n", w, d.listing(w.PC))
+	case "reset":
+		m.Reset()
+		if m.History != nil {
+			if err := m.Record(m.History); err != nil {
+				return err
+			}
+		}
+		d.where()
+	default:
+		return fmt.Errorf("unknown command %q, try help", name)
+	}
+	return nil
+}
+
+// step runs one instruction, it returns true if the machine stopped
+func (d *Debugger) step() bool {
+	m := d.Machine
+	if m.Halted {
+		fmt.Fprintln(d.out, "the program has finished, use reset to start over")
+		return true
+	}
+	if _, err := m.Step(); err != nil {
+		fmt.Fprintf(d.out, "fault: %v\n", err)
+		PrintBacktrace(d.out, m, m.PC)
+		return true
+	}
+	if m.Halted && m.ExitCode != 0 {
+		fmt.Fprintf(d.out, "exit code %d after %d cycles\n", m.ExitCode, m.Cycle)
+		PrintBacktrace(d.out, m, m.PC-4)
+		return true
+	}
+	if m.Halted {
+		fmt.Fprintf(d.out, "BREAK after %d cycles\n", m.Cycle)
+		PrintBacktrace(d.out, m, m.PC-4)
+		return true
+	}
+	return false
+}
+
+// next steps over a BL by running until the call returns
+func (d *Debugger) next() bool {
+	m := d.Machine
+	inst, err := m.Fetch(m.PC)
+	if err != nil || inst.Op != "BL" {
+		return d.step()
+	}
+	depth := m.Depth()
+	if d.step() {
+		return true
+	}
+	return d.runUntil(func() bool { return m.Depth() <= depth })
+}
+
+// runUntil steps until done returns true, a breakpoint is reached or the
+// machine stops. It returns true if the machine stopped.
+func (d *Debugger) runUntil(done func() bool) bool {
+	m := d.Machine
+	for first := true; ; first = false {
+		if !first && d.Breakpoints[m.PC] {
+			fmt.Fprintf(d.out, "breakpoint at %d\n", m.PC)
+			return false
+		}
+		if d.step() {
+			return true
+		}
+		if done() {
+			return false
+		}
+	}
+}
+
+// where prints the instruction the machine is about to run
+func (d *Debugger) where() {
+	fmt.Fprintf(d.out, "=> %d\t%s\n", d.Machine.PC, d.listing(d.Machine.PC))
+}
+
+// listing returns the assembly at an address, with its label if it has one
+func (d *Debugger) listing(addr int) string {
+	inst, err := d.Machine.Fetch(addr)
+	if err != nil {
+		return "?"
+	}
+	var labels []string
+	for label, at := range d.Labels {
+		if at == addr {
+			labels = append(labels, label+":")
+		}
+	}
+	sort.Strings(labels)
+	return strings.Join(append(labels, InstructionString(*inst)), "\t")
+}
+
+// address reads a number or a label
+func (d *Debugger) address(arg string) (int, error) {
+	if addr, ok := d.Labels[arg]; ok {
+		return addr, nil
+	}
+	addr, err := strconv.ParseInt(arg, 0, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not an address or label", arg)
+	}
+	return int(addr), nil
+}
+
+// register reads a register name the same way the assembler does
+func (d *Debugger) register(arg string) (uint8, error) {
+	r, err := asmLine{}.register(token{text: arg})
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a register", arg)
+	}
+	return uint8(r), nil
+}
+
+// count reads an optional positive count argument
+func (d *Debugger) count(args []string, def int) (int, error) {
+	if len(args) == 0 {
+		return def, nil
+	}
+	n, err := strconv.Atoi(args[0])
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("%q is not a positive count", args[0])
+	}
+	return n, nil
+}
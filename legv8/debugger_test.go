@@ -0,0 +1,80 @@
+package legv8
+
+import (
+	"strings"
+	"testing"
+)
+
+// debugSession runs the debugger on a program with the commands given and
+// returns what it printed
+func debugSession(t *testing.T, source string, commands ...string) string {
+	t.Helper()
+	lines, labels, err := AssembleWithLabels(strings.NewReader(source))
+	if err != nil {
+		t.Fatal(err)
+	}
+	program, err := ReadFile(strings.NewReader(strings.Join(lines, "\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := InitializeInstructions(program); err != nil {
+		t.Fatal(err)
+	}
+	m := NewMachine()
+	m.Load(program)
+	var out strings.Builder
+	if err := NewDebugger(m, labels).Run(strings.NewReader(strings.Join(commands, "\n")), &out); err != nil {
+		t.Fatal(err)
+	}
+	return out.String()
+}
+
+const debugProgram = `
+main:	ADDI X1, XZR, #3
+	BL   double
+	ADDI X2, XZR, #7
+	B    done
+double:	ADD  X1, X1, X1
+	BR   LR
+done:	BREAK`
+
+func TestDebuggerBreakpoints(t *testing.T) {
+	out := debugSession(t, debugProgram,
+		"b double", "c", "bt", "finish", "p X1", "info breakpoints", "d double", "c", "q")
+	for _, want := range []string{
+		"breakpoint at 112\n",
+		"=> 112\tdouble:\tADD\tR1, R1, R1\n",
+		"#0\t112\tin 112\n#1\t100\tin 96\n",
+		"=> 104\tADDI\tR2, R31, #7\n",
+		"X1 = 6\n",
+		"112\tdouble:\tADD\tR1, R1, R1\n",
+		"BREAK after 7 cycles\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output is missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestDebuggerStepAndState(t *testing.T) {
+	out := debugSession(t, debugProgram,
+		"n 2", "p X1", "reset", "s 2", "p LR", "set X3 9", "p X3", "set XZR 1",
+		"setmem 200 -5", "x 200 2", "setmem 201 1 2", "dis 1", "bogus")
+	for _, want := range []string{
+		"=> 104\tADDI\tR2, R31, #7\n", // next ran the whole call
+		"X1 = 6\n",
+		"=> 96\tmain:\tADDI\tR1, R31, #3\n",
+		"=> 112\tdouble:\tADD\tR1, R1, R1\n", // step went into it
+		"LR = 104\n",
+		"X3 = 9\n",
+		"XZR is always zero\n",
+		"200:\t-5\n204:\t0\n",
+		"misaligned 2 byte access at address 201\n",
+		"   108\tB\t #3\n=> 112\tdouble:\tADD\tR1, R1, R1\n   116\tBR\tR30\n",
+		"unknown command \"bogus\"",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output is missing %q:\n%s", want, out)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package legv8
+
+import "strings"
+
+// FlagsReg stands for the NZCV flags in the register lists returned by Reads
+// and Writes, so flag dependencies look like any other register dependency.
+const FlagsReg = 32
+
+// flagSetters are the instructions that write the NZCV flags
+var flagSetters = map[string]bool{"ADDS": true, "SUBS": true, "ANDS": true, "ADDIS": true, "SUBIS": true, "ERET": true}
+
+// Reads returns the registers the instruction reads, XZR is left out.
+func (inst Instruction) Reads() []uint8 {
+	var regs []uint8
+	enc := Lookup(inst.Op)
+	if enc == nil {
+		return nil
+	}
+	add := func(r uint8) {
+		if r != XZR {
+			regs = append(regs, r)
+		}
+	}
+	switch {
+	case enc.Format == "D" && !inst.IsLoad(): // stores read the register they write to memory
+		add(inst.Rt)
+		add(inst.Rn)
+	case enc.Format == "CB":
+		// CBZ and CBNZ test the number of their register, not its value
+		if _, ok := enc.condition(); ok {
+			regs = append(regs, FlagsReg)
+		}
+	case inst.Op == "LSL" || inst.Op == "LSR" || inst.Op == "ASR": // shifted by the register Shamt names
+		add(inst.Rn)
+		add(inst.Shamt)
+	case inst.Op == "MOVK": // only part of Rd is replaced
+		add(inst.Rd)
+	case inst.Op == "SVC": // syscall arguments are in X0
+		add(0)
+	case inst.Op == "MSR":
+		add(inst.Rt)
+	default:
+		if strings.Contains(enc.Syntax, "Rn") {
+			add(inst.Rn)
+		}
+		if strings.Contains(enc.Syntax, "Rm") {
+			add(inst.Rm)
+		}
+	}
+	return regs
+}
+
+// Writes returns the registers the instruction writes, XZR is left out.
+func (inst Instruction) Writes() []uint8 {
+	var regs []uint8
+	enc := Lookup(inst.Op)
+	if enc == nil {
+		return nil
+	}
+	switch {
+	case inst.IsLoad() || inst.Op == "MRS":
+		regs = append(regs, inst.Rt)
+	case inst.Op == "BL":
+		regs = append(regs, LR)
+	case inst.Op == "SVC" && (inst.Field == SysReadInt || inst.Field == SysCycles):
+		regs = append(regs, 0)
+	case strings.HasPrefix(enc.Syntax, "Rd"):
+		regs = append(regs, inst.Rd)
+	}
+	if len(regs) > 0 && regs[0] == XZR {
+		regs = regs[:0]
+	}
+	if flagSetters[inst.Op] {
+		regs = append(regs, FlagsReg)
+	}
+	return regs
+}
+
+// IsLoad reports whether the instruction reads data memory.
+func (inst Instruction) IsLoad() bool {
+	return strings.HasPrefix(inst.Op, "LDUR")
+}
+
+// IsStore reports whether the instruction writes data memory.
+func (inst Instruction) IsStore() bool {
+	return strings.HasPrefix(inst.Op, "STUR")
+}
+
+// accessSize returns the bytes a load or store accesses
+func (inst Instruction) accessSize() int {
+	switch {
+	case strings.HasSuffix(inst.Op, "B"):
+		return 1
+	case strings.HasSuffix(inst.Op, "H"):
+		return 2
+	}
+	return 4
+}
+
+// IsBranch reports whether the instruction can change the flow of the program.
+func (inst Instruction) IsBranch() bool {
+	return inst.TypeOfInstruction == "B" || inst.TypeOfInstruction == "CB" || inst.Op == "BR" || inst.Op == "ERET"
+}
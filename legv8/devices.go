@@ -0,0 +1,207 @@
+package legv8
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+)
+
+// Device is a peripheral mapped into memory, loads and stores to its
+// address range go to it instead of to memory. offset is counted from the
+// address the device is mapped at and the access always fits inside Size.
+type Device interface {
+	Name() string
+	Size() int // bytes of address space the device takes up
+	Read(offset int, size int) (uint64, error)
+	Write(offset int, size int, value uint64) error
+}
+
+// Saver is a device whose state goes into checkpoints. Checkpoint refuses
+// a machine with a device that is not a Saver, a device without state of
+// its own saves nil.
+type Saver interface {
+	SaveState() (json.RawMessage, error)
+	RestoreState(state json.RawMessage) error
+}
+
+// Ticker is a device that keeps time, Tick is called once per retired
+// instruction.
+type Ticker interface {
+	Tick()
+}
+
+// Addresses of the devices MapDefaultDevices puts at the top of memory.
+const (
+	UARTBase  = 0xFF00
+	TimerBase = 0xFF10
+	HaltBase  = 0xFF20
+)
+
+// mapping is a device and the address it is mapped at
+type mapping struct {
+	base   int
+	device Device
+}
+
+// Map routes the address range starting at base to a device. The range
+// can't overlap another device.
+func (mem *Memory) Map(base int, device Device) error {
+	if base < 0 || base%8 != 0 {
+		return fmt.Errorf("legv8: %s: base %d is not 8 byte aligned", device.Name(), base)
+	}
+	for _, mp := range mem.devices {
+		if base < mp.base+mp.device.Size() && mp.base < base+device.Size() {
+			return fmt.Errorf("legv8: %s at %d overlaps %s at %d", device.Name(), base, mp.device.Name(), mp.base)
+		}
+	}
+	mem.devices = append(mem.devices, mapping{base, device})
+	return nil
+}
+
+// device returns the device an access of size bytes at addr goes to
+func (mem *Memory) device(addr int, size int) (mapping, bool) {
+	for _, mp := range mem.devices {
+		if mp.base <= addr && addr+size <= mp.base+mp.device.Size() {
+			return mp, true
+		}
+	}
+	return mapping{}, false
+}
+
+// IsDevice reports whether addr belongs to a device, device accesses are
+// not cached.
+func (mem *Memory) IsDevice(addr int) bool {
+	_, ok := mem.device(addr, 1)
+	return ok
+}
+
+// Map puts a device into the memory of the machine, it stays mapped across
+// Load and Reset.
+func (m *Machine) Map(base int, device Device) error {
+	if err := m.image.Map(base, device); err != nil {
+		return err
+	}
+	return m.Memory.Map(base, device)
+}
+
+// MapDefaultDevices maps the console UART, the timer and the halt register
+// at UARTBase, TimerBase and HaltBase.
+func (m *Machine) MapDefaultDevices() error {
+	for _, mp := range []mapping{{UARTBase, &UART{machine: m}}, {TimerBase, &Timer{}}, {HaltBase, &HaltRegister{machine: m}}} {
+		if err := m.Map(mp.base, mp.device); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tick advances every device that keeps time
+func (m *Machine) tick() {
+	for _, mp := range m.Memory.devices {
+		if t, ok := mp.device.(Ticker); ok {
+			t.Tick()
+		}
+	}
+}
+
+// UART is the console. Storing to offset 0 writes the low byte to the
+// machine Output, loading from offset 0 reads the next byte of Input (0 if
+// there is none). Offset 8 is the status register: bit 0 is always set since
+// output never blocks and bit 1 is set while there is input to read.
+type UART struct {
+	machine *Machine
+}
+
+func (u *UART) Name() string                             { return "UART" }
+func (u *UART) Size() int                                { return 16 }
+func (u *UART) SaveState() (json.RawMessage, error)      { return nil, nil }
+func (u *UART) RestoreState(state json.RawMessage) error { return nil }
+
+func (u *UART) Read(offset int, size int) (uint64, error) {
+	m := u.machine
+	if m.Input != nil && m.input == nil {
+		m.input = bufio.NewReader(m.Input)
+	}
+	switch offset {
+	case 0:
+		if m.input == nil {
+			return 0, nil
+		}
+		b, err := m.input.ReadByte()
+		if err != nil {
+			return 0, nil
+		}
+		return uint64(b), nil
+	case 8:
+		status := uint64(1)
+		if m.input != nil {
+			if _, err := m.input.Peek(1); err == nil {
+				status |= 2
+			}
+		}
+		return status, nil
+	}
+	return 0, nil
+}
+
+func (u *UART) Write(offset int, size int, value uint64) error {
+	if offset == 0 && u.machine.Output != nil {
+		_, err := u.machine.Output.Write([]byte{byte(value)})
+		return err
+	}
+	return nil
+}
+
+// Timer counts retired instructions. Offset 0 is the count, which can be
+// written to restart it from a value, and offset 8 is a compare register
+// that the timer interrupt fires on.
+type Timer struct {
+	Count   uint64
+	Compare uint64
+}
+
+func (t *Timer) Name() string { return "timer" }
+func (t *Timer) Size() int    { return 16 }
+func (t *Timer) Tick()        { t.Count++ }
+func (t *Timer) Reset()       { *t = Timer{} }
+
+func (t *Timer) SaveState() (json.RawMessage, error)      { return json.Marshal(t) }
+func (t *Timer) RestoreState(state json.RawMessage) error { return json.Unmarshal(state, t) }
+
+func (t *Timer) Read(offset int, size int) (uint64, error) {
+	reg := &t.Count
+	if offset >= 8 {
+		reg = &t.Compare
+	}
+	shift := 8 * (offset % 8)
+	return *reg >> shift & (1<<(8*size) - 1), nil
+}
+
+func (t *Timer) Write(offset int, size int, value uint64) error {
+	reg := &t.Count
+	if offset >= 8 {
+		reg = &t.Compare
+	}
+	shift := 8 * (offset % 8)
+	mask := uint64(1<<(8*size)-1) << shift
+	*reg = *reg&^mask | value<<shift&mask
+	return nil
+}
+
+// HaltRegister stops the machine when it is stored to, the value stored is
+// the exit code.
+type HaltRegister struct {
+	machine *Machine
+}
+
+func (h *HaltRegister) Name() string                              { return "halt" }
+func (h *HaltRegister) Size() int                                 { return 8 }
+func (h *HaltRegister) Read(offset int, size int) (uint64, error) { return 0, nil }
+func (h *HaltRegister) SaveState() (json.RawMessage, error)       { return nil, nil }
+func (h *HaltRegister) RestoreState(state json.RawMessage) error  { return nil }
+
+func (h *HaltRegister) Write(offset int, size int, value uint64) error {
+	h.machine.ExitCode = int(value)
+	h.machine.Halted = true
+	return nil
+}
@@ -0,0 +1,91 @@
+package legv8
+
+import (
+	"strings"
+	"testing"
+)
+
+// deviceProgram uses every default device through X9, which points at the
+// UART
+const deviceProgram = `
+	MOVZ  X9, 0xFF00
+	ADDI  X1, XZR, #72
+	STURB X1, [X9, #0]  // 'H' to the UART
+	LDURB X2, [X9, #8]  // status, input waiting
+	LDURB X3, [X9, #0]  // 'a'
+	LDURB X4, [X9, #8]  // status, input used up
+	LDUR  X5, [X9, #4]  // timer count
+	ADDI  X6, XZR, #2
+	STUR  X6, [X9, #8]  // halt with exit code 2
+	BREAK`
+
+func TestDevices(t *testing.T) {
+	m := NewMachine()
+	if err := m.MapDefaultDevices(); err != nil {
+		t.Fatal(err)
+	}
+	m.Load(assemble(t, deviceProgram))
+	l1d, _ := ParseCacheConfig("L1D", "")
+	cs, err := NewCacheSystem(nil, &l1d, nil, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Caches = cs
+	var out strings.Builder
+	m.Output, m.Input = &out, strings.NewReader("a")
+	if err := m.Run(nil); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "H" {
+		t.Errorf("the UART wrote %q, want %q", out.String(), "H")
+	}
+	want := []int64{3, 'a', 1, 6}
+	for i, w := range want {
+		if got := m.Registers[i+2]; got != w {
+			t.Errorf("X%d is %d, want %d", i+2, got, w)
+		}
+	}
+	if !m.Halted || m.ExitCode != 2 || m.Cycle != 9 {
+		t.Errorf("halted %v with exit code %d after %d cycles, want the halt register to stop the program with 2 after 9", m.Halted, m.ExitCode, m.Cycle)
+	}
+	if cs.L1D.Stats != (CacheStats{}) {
+		t.Errorf("device accesses went through the cache: %+v", cs.L1D.Stats)
+	}
+
+	// the devices stay mapped and the timer starts again
+	m.Load(m.Program)
+	if _, err := m.Step(); err != nil {
+		t.Fatal(err)
+	}
+	if count, _ := m.Memory.Load(TimerBase, 8); count != 1 {
+		t.Errorf("after Load and one step the timer is %d, want 1", count)
+	}
+}
+
+func TestTimerPartialAccess(t *testing.T) {
+	timer := &Timer{Count: 0xAABBCCDD}
+	timer.Write(9, 1, 0x12)
+	timer.Write(1, 1, 0x00)
+	if timer.Compare != 0x1200 || timer.Count != 0xAABB00DD {
+		t.Errorf("timer is %+v after byte writes", *timer)
+	}
+	if got, _ := timer.Read(2, 2); got != 0xAABB {
+		t.Errorf("half word at 2 is %#x, want 0xaabb", got)
+	}
+}
+
+func TestMapErrors(t *testing.T) {
+	mem := NewMemory(DefaultMemorySize)
+	if err := mem.Map(4, &Timer{}); err == nil {
+		t.Error("a device mapped at 4, which is not 8 byte aligned")
+	}
+	if err := mem.Map(UARTBase, &UART{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := mem.Map(UARTBase+8, &Timer{}); err == nil {
+		t.Error("a timer mapped over the UART")
+	}
+	if !mem.IsDevice(UARTBase+15) || mem.IsDevice(UARTBase+16) {
+		t.Error("the UART does not cover exactly 16 bytes")
+	}
+}
@@ -0,0 +1,186 @@
+package legv8
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// Exception classes, the syndrome register ESR holds the class of the last
+// exception taken.
+const (
+	ExcUndefined  = 1 // the instruction did not decode
+	ExcMisaligned = 2 // a load or store was not aligned to its size
+	ExcOutOfRange = 3 // a load or store fell outside of memory, FAR holds the address
+	ExcInterrupt  = 4 // a device asked for an interrupt
+)
+
+var exceptionNames = map[int]string{
+	ExcUndefined:  "undefined instruction",
+	ExcMisaligned: "misaligned access",
+	ExcOutOfRange: "out of range access",
+	ExcInterrupt:  "interrupt",
+}
+
+// Offsets of the vector table entries from VBAR. Each entry is one
+// instruction, normally a branch to the handler.
+const (
+	VectorSync      = 0 // undefined instructions and memory faults
+	VectorInterrupt = 4 // device interrupts
+)
+
+// System registers MRS and MSR can name, by the number in their Field.
+const (
+	SysELR  = 0 // address the exception was taken at, ERET goes back to it
+	SysESR  = 1 // syndrome, the class of the last exception
+	SysFAR  = 2 // faulting address of the last memory exception
+	SysVBAR = 3 // vector base address, exceptions only go to a handler when it is not 0
+	SysSPSR = 4 // NZCV flags saved on exception entry as bits 31 to 28
+)
+
+var sysregNames = []string{"ELR", "ESR", "FAR", "VBAR", "SPSR"}
+
+// Exception is an exception the machine took.
+type Exception struct {
+	Class  int
+	PC     int // ELR, the instruction that faulted or the one an interrupt came in front of
+	Addr   int // faulting address of a memory exception
+	Vector int // address execution went to
+}
+
+func (e Exception) String() string {
+	text := fmt.Sprintf("%s at %d", exceptionNames[e.Class], e.PC)
+	if e.Class == ExcMisaligned || e.Class == ExcOutOfRange {
+		text += fmt.Sprintf(" (address %d)", e.Addr)
+	}
+	return text + fmt.Sprintf(", vector %d", e.Vector)
+}
+
+// UndefinedInstruction is the error for a line that is not in the
+// instruction table.
+type UndefinedInstruction struct {
+	PC        int
+	LineValue uint64
+}
+
+func (e *UndefinedInstruction) Error() string {
+	return fmt.Sprintf("legv8: undefined instruction %032b at pc %d", e.LineValue, e.PC)
+}
+
+// Interrupter is a device that can interrupt the machine. Interrupt
+// reports whether it is asking for one, the handler has to make the
+// device stop asking before it returns.
+type Interrupter interface {
+	Interrupt() bool
+}
+
+// Interrupt fires once the count reaches a compare value that is not 0,
+// writing either register acknowledges it.
+func (t *Timer) Interrupt() bool {
+	return t.Compare != 0 && t.Count >= t.Compare
+}
+
+// exception returns the exception a fault of the current instruction
+// raises, ok is false for faults that stop the machine
+func (m *Machine) exception(err error) (exc Exception, ok bool) {
+	var undefined *UndefinedInstruction
+	var fault *MemoryFault
+	switch {
+	case errors.As(err, &undefined):
+		exc.Class = ExcUndefined
+	case errors.As(err, &fault) && fault.Reason == "misaligned":
+		exc.Class, exc.Addr = ExcMisaligned, fault.Addr
+	case errors.As(err, &fault):
+		exc.Class, exc.Addr = ExcOutOfRange, fault.Addr
+	default:
+		return exc, false
+	}
+	exc.PC = m.PC
+	return exc, true
+}
+
+// interrupting reports whether a device is asking for an interrupt that
+// the machine can take
+func (m *Machine) interrupting() bool {
+	if m.VBAR == 0 || m.Masked {
+		return false
+	}
+	for _, mp := range m.Memory.devices {
+		if d, ok := mp.device.(Interrupter); ok && d.Interrupt() {
+			return true
+		}
+	}
+	return false
+}
+
+// faulted reports whether the last Step took an exception in place of
+// retiring its instruction. An interrupt is taken before the instruction at
+// the vector runs, and that instruction still retires.
+func (m *Machine) faulted() bool {
+	return m.Exception != nil && m.Exception.Class != ExcInterrupt
+}
+
+// enter takes an exception: the return address, syndrome and flags are
+// saved, interrupts are masked and execution goes to the vector
+func (m *Machine) enter(exc Exception) {
+	exc.Vector = m.VBAR + VectorSync
+	if exc.Class == ExcInterrupt {
+		exc.Vector = m.VBAR + VectorInterrupt
+	}
+	m.ELR = exc.PC
+	m.ESR = exc.Class
+	if exc.Class == ExcMisaligned || exc.Class == ExcOutOfRange {
+		m.FAR = exc.Addr
+	}
+	m.SPSR = m.Flags
+	m.Masked = true
+	m.PC = exc.Vector
+	m.Exception = &exc
+}
+
+// sysreg returns the system register MRS reads
+func (m *Machine) sysreg(n uint64) int64 {
+	switch n {
+	case SysELR:
+		return int64(m.ELR)
+	case SysESR:
+		return int64(m.ESR)
+	case SysFAR:
+		return int64(m.FAR)
+	case SysVBAR:
+		return int64(m.VBAR)
+	case SysSPSR:
+		var bits int64
+		for i, set := range []bool{m.SPSR.N, m.SPSR.Z, m.SPSR.C, m.SPSR.V} {
+			if set {
+				bits |= 1 << (31 - i)
+			}
+		}
+		return bits
+	}
+	return 0
+}
+
+// setSysreg writes the system register MSR names
+func (m *Machine) setSysreg(n uint64, value int64) {
+	switch n {
+	case SysELR:
+		m.ELR = int(value)
+	case SysESR:
+		m.ESR = int(value)
+	case SysFAR:
+		m.FAR = int(value)
+	case SysVBAR:
+		m.VBAR = int(value)
+	case SysSPSR:
+		m.SPSR = Flags{N: value&(1<<31) != 0, Z: value&(1<<30) != 0, C: value&(1<<29) != 0, V: value&(1<<28) != 0}
+	}
+}
+
+// sysregName returns the assembly name of a system register number
+func sysregName(n uint64) string {
+	if n < uint64(len(sysregNames)) {
+		return sysregNames[n]
+	}
+	return "S" + strconv.FormatUint(n, 10)
+}
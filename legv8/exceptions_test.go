@@ -0,0 +1,101 @@
+package legv8
+
+import "testing"
+
+// exceptionTrace runs a program to the end and returns every exception it
+// took
+func exceptionTrace(t *testing.T, m *Machine) []Exception {
+	t.Helper()
+	var taken []Exception
+	err := m.Run(func(m *Machine, inst Instruction) {
+		if m.Exception != nil {
+			taken = append(taken, *m.Exception)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return taken
+}
+
+func TestSyncException(t *testing.T) {
+	m := NewMachine()
+	m.Load(assemble(t, `
+	ADDI  X1, XZR, vectors
+	MSR   VBAR, X1
+	SUBIS X7, XZR, #1     // N set
+	LDURH X2, [XZR, #1]   // misaligned
+	ADDI  X3, XZR, #7
+	B     done
+vectors: B sync
+	NOP
+sync:	MRS   X4, ESR
+	MRS   X5, FAR
+	MRS   X6, ELR
+	ADDI  X6, X6, #4      // skip the faulting load
+	MSR   ELR, X6
+	ADDIS X8, XZR, #1     // N clear
+	ERET
+done:	BREAK`))
+	taken := exceptionTrace(t, m)
+	want := Exception{Class: ExcMisaligned, PC: 108, Addr: 1, Vector: 120}
+	if len(taken) != 1 || taken[0] != want {
+		t.Errorf("exceptions are %v, want %v", taken, want)
+	}
+	if r := m.Registers; r[4] != ExcMisaligned || r[5] != 1 || r[6] != 112 || r[3] != 7 || r[2] != 0 {
+		t.Errorf("X2 to X6 are %v, want the load skipped with ESR 2, FAR 1 and ELR 108", r[2:7])
+	}
+	if !m.Flags.N || m.Masked {
+		t.Errorf("after ERET the flags are %+v and masked is %v, want N restored and interrupts unmasked", m.Flags, m.Masked)
+	}
+}
+
+func TestUndefinedInstruction(t *testing.T) {
+	// without a vector base the line retires without doing anything
+	m := loadLines(t, "00000000000000000000000000000001", breakWord)
+	if _, err := m.Step(); err != nil || m.Exception != nil || m.PC != 100 {
+		t.Errorf("undefined line without VBAR: error %v, exception %v, PC %d", err, m.Exception, m.PC)
+	}
+
+	m.Reset()
+	m.VBAR = 200
+	inst, err := m.Step()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Exception{Class: ExcUndefined, PC: 96, Vector: 200}
+	if m.Exception == nil || *m.Exception != want || m.PC != 200 || inst.Cycle != 1 {
+		t.Errorf("undefined line with VBAR: exception %v at cycle %d, PC %d, want %v", m.Exception, inst.Cycle, m.PC, want)
+	}
+}
+
+func TestTimerInterrupt(t *testing.T) {
+	m := NewMachine()
+	if err := m.MapDefaultDevices(); err != nil {
+		t.Fatal(err)
+	}
+	m.Load(assemble(t, `
+	ADDI X1, XZR, vectors
+	MSR  VBAR, X1
+	MOVZ X9, 0xFF10
+	ADDI X2, XZR, #5
+	STUR X2, [X9, #2]   // compare at 5 retired instructions
+	ADDI X3, XZR, #1
+	ADDI X3, X3, #1
+	ADDI X3, X3, #1
+	B    done
+vectors: NOP
+	B    irq
+irq:	STUR XZR, [X9, #2]  // acknowledge
+	ADDI X4, X4, #1
+	ERET
+done:	BREAK`))
+	taken := exceptionTrace(t, m)
+	want := Exception{Class: ExcInterrupt, PC: 116, Vector: 136}
+	if len(taken) != 1 || taken[0] != want {
+		t.Errorf("exceptions are %v, want %v", taken, want)
+	}
+	if m.Registers[3] != 3 || m.Registers[4] != 1 {
+		t.Errorf("X3 is %d and X4 %d, want 3 and 1", m.Registers[3], m.Registers[4])
+	}
+}
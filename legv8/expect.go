@@ -0,0 +1,165 @@
+package legv8
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Expectations describe the final state a correct program ends in, for
+// grading. Everything left out is not checked. They are read from JSON in
+// the same layout as State:
+//
+//	{
+//		"registers": {"X3": 12},
+//		"flags": "Z",
+//		"memory": [{"addr": 200, "values": [1, 2, 3]}],
+//		"max_cycles": 40,
+//		"exit_code": 0,
+//		"output": "55\n"
+//	}
+type Expectations struct {
+	Registers map[string]int64 `json:"registers,omitempty"`
+	Flags     *string          `json:"flags,omitempty"` // exactly the flags that are set, "" for none
+	Memory    []MemoryRange    `json:"memory,omitempty"`
+	MinCycles int              `json:"min_cycles,omitempty"`
+	MaxCycles int              `json:"max_cycles,omitempty"` // the run is stopped once it goes past this
+	ExitCode  *int             `json:"exit_code,omitempty"`
+	Output    *string          `json:"output,omitempty"` // everything the print syscalls and UART wrote
+}
+
+// Mismatch is one expectation the final state did not meet.
+type Mismatch struct {
+	What     string // X3, memory 200, cycles...
+	Expected string
+	Got      string
+}
+
+func (mm Mismatch) String() string {
+	return fmt.Sprintf("%s: expected %s, got %s", mm.What, mm.Expected, mm.Got)
+}
+
+// ReadExpectations reads a JSON expectations file.
+func ReadExpectations(r io.Reader) (*Expectations, error) {
+	var e Expectations
+	if err := decodeFile(r, &e); err != nil {
+		return nil, fmt.Errorf("legv8: expectations: %v", err)
+	}
+	for name := range e.Registers {
+		if _, err := (asmLine{}).register(token{text: name}); err != nil {
+			return nil, fmt.Errorf("legv8: expectations: %q is not a register", name)
+		}
+	}
+	if e.Flags != nil {
+		if _, err := parseFlags(*e.Flags); err != nil {
+			return nil, fmt.Errorf("legv8: expectations: %v", err)
+		}
+	}
+	return &e, nil
+}
+
+// Run runs the machine until BREAK, a fault, or until it goes past
+// MaxCycles, or past limit when there is no MaxCycles.
+func (e *Expectations) Run(m *Machine, limit int) error {
+	if e.MaxCycles > 0 {
+		limit = e.MaxCycles
+	}
+	for !m.Halted && m.Cycle <= limit {
+		if _, err := m.Step(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Check compares the machine with the expectations once a run is over,
+// runErr is the fault the run stopped on, if any, and output what it printed.
+// It returns every mismatch, sorted the same way every time.
+func (e *Expectations) Check(m *Machine, runErr error, output string) []Mismatch {
+	var mismatches []Mismatch
+	add := func(what, expected, got string) {
+		mismatches = append(mismatches, Mismatch{what, expected, got})
+	}
+
+	switch {
+	case runErr != nil:
+		add("run", "BREAK", runErr.Error())
+	case !m.Halted:
+		add("run", "BREAK", fmt.Sprintf("still running after %d cycles", m.Cycle))
+	}
+
+	var names []string
+	for name := range e.Registers {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		ri, _ := asmLine{}.register(token{text: names[i]})
+		rj, _ := asmLine{}.register(token{text: names[j]})
+		return ri < rj
+	})
+	for _, name := range names {
+		r, _ := asmLine{}.register(token{text: name})
+		if got := m.reg(uint8(r)); got != e.Registers[name] {
+			add(strings.ToUpper(name), fmt.Sprint(e.Registers[name]), fmt.Sprint(got))
+		}
+	}
+
+	if e.Flags != nil {
+		want, _ := parseFlags(*e.Flags)
+		if want != m.Flags {
+			letters := func(f Flags) string {
+				if l := flagLetters(f); l != "" {
+					return l
+				}
+				return "none set"
+			}
+			add("flags", letters(want), letters(m.Flags))
+		}
+	}
+
+	for _, r := range e.Memory {
+		size := r.Size
+		if size == 0 {
+			size = 4
+		}
+		for i, want := range r.Values {
+			addr := r.Addr + i*size
+			what := fmt.Sprintf("memory %d", addr)
+			value, err := m.Memory.Load(addr, size)
+			if err != nil {
+				add(what, fmt.Sprint(want), err.Error())
+				continue
+			}
+			shift := 64 - 8*uint(size)
+			if got := int64(value<<shift) >> shift; got != int64(uint64(want)<<shift)>>shift {
+				add(what, fmt.Sprint(want), fmt.Sprint(got))
+			}
+		}
+	}
+
+	if e.MinCycles > 0 && m.Cycle < e.MinCycles {
+		add("cycles", fmt.Sprintf("at least %d", e.MinCycles), fmt.Sprint(m.Cycle))
+	}
+	if e.MaxCycles > 0 && m.Cycle > e.MaxCycles {
+		add("cycles", fmt.Sprintf("at most %d", e.MaxCycles), fmt.Sprint(m.Cycle))
+	}
+	if e.ExitCode != nil && m.ExitCode != *e.ExitCode {
+		add("exit code", fmt.Sprint(*e.ExitCode), fmt.Sprint(m.ExitCode))
+	}
+	if e.Output != nil && output != *e.Output {
+		add("output", fmt.Sprintf("%q", *e.Output), fmt.Sprintf("%q", output))
+	}
+	return mismatches
+}
+
+// flagLetters returns the set flags in NZCV order, "" when none are set
+func flagLetters(f Flags) string {
+	var letters string
+	for i, set := range []bool{f.N, f.Z, f.C, f.V} {
+		if set {
+			letters += string("NZCV"[i])
+		}
+	}
+	return letters
+}
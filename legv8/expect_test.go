@@ -0,0 +1,82 @@
+package legv8
+
+import (
+	"strings"
+	"testing"
+)
+
+// checkProgram runs checkpointProgram against a JSON expectations file and
+// returns the mismatches as text
+func checkProgram(t *testing.T, expectations string) []string {
+	t.Helper()
+	e, err := ReadExpectations(strings.NewReader(expectations))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewMachine()
+	m.Load(assemble(t, checkpointProgram))
+	runErr := e.Run(m, 1000)
+	var got []string
+	for _, mm := range e.Check(m, runErr, "") {
+		got = append(got, mm.String())
+	}
+	return got
+}
+
+func TestExpectationsPass(t *testing.T) {
+	got := checkProgram(t, `{
+		"registers": {"X1": 0, "LR": 124},
+		"flags": "ZC",
+		"memory": [{"addr": 200, "values": [55]}, {"addr": 200, "size": 1, "values": [55, 0]}],
+		"min_cycles": 70,
+		"max_cycles": 80,
+		"exit_code": 0,
+		"output": ""
+	}`)
+	if len(got) != 0 {
+		t.Errorf("mismatches are %q, want none", got)
+	}
+}
+
+func TestExpectationsMismatch(t *testing.T) {
+	got := checkProgram(t, `{
+		"registers": {"X2": 54, "x1": 3},
+		"flags": "",
+		"memory": [{"addr": 200, "size": 2, "values": [-1]}],
+		"min_cycles": 100,
+		"exit_code": 1,
+		"output": "55"
+	}`)
+	want := []string{
+		"X1: expected 3, got 0",
+		"X2: expected 54, got 55",
+		"flags: expected none set, got ZC",
+		"memory 200: expected -1, got 55",
+		"cycles: expected at least 100, got 73",
+		"exit code: expected 1, got 0",
+		`output: expected "55", got ""`,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("mismatches are\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	// a run past max_cycles is stopped
+	got = checkProgram(t, `{"max_cycles": 20}`)
+	want = []string{"run: expected BREAK, got still running after 21 cycles", "cycles: expected at most 20, got 21"}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("mismatches are %q, want %q", got, want)
+	}
+}
+
+func TestReadExpectationsErrors(t *testing.T) {
+	for _, text := range []string{
+		`{"registers": {"X99": 1}}`,
+		`{"flags": "Q"}`,
+		`{"cycles": 3}`,
+		`registers: {X1: 1}`,
+	} {
+		if _, err := ReadExpectations(strings.NewReader(text)); err == nil {
+			t.Errorf("%s was read without an error", text)
+		}
+	}
+}
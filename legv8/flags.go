@@ -0,0 +1,88 @@
+package legv8
+
+import "fmt"
+
+// Flags is the NZCV condition flags register.
+type Flags struct {
+	N bool // result was negative
+	Z bool // result was zero
+	C bool // unsigned carry out (no borrow for subtraction)
+	V bool // signed overflow
+}
+
+// conditions are the B.cond condition codes in encoding order, the index is
+// the value of the Rt field of the instruction
+var conditions = []string{"EQ", "NE", "HS", "LO", "MI", "PL", "VS", "VC", "HI", "LS", "GE", "LT", "GT", "LE"}
+
+// holds reports whether the condition code is true for these flags
+func (f Flags) holds(cond uint8) bool {
+	switch conditions[cond] {
+	case "EQ":
+		return f.Z
+	case "NE":
+		return !f.Z
+	case "HS":
+		return f.C
+	case "LO":
+		return !f.C
+	case "MI":
+		return f.N
+	case "PL":
+		return !f.N
+	case "VS":
+		return f.V
+	case "VC":
+		return !f.V
+	case "HI":
+		return f.C && !f.Z
+	case "LS":
+		return !(f.C && !f.Z)
+	case "GE":
+		return f.N == f.V
+	case "LT":
+		return f.N != f.V
+	case "GT":
+		return !f.Z && f.N == f.V
+	case "LE":
+		return !(!f.Z && f.N == f.V)
+	}
+	return false
+}
+
+func (f Flags) String() string {
+	return fmt.Sprintf("N:%d\tZ:%d\tC:%d\tV:%d", bit(f.N), bit(f.Z), bit(f.C), bit(f.V))
+}
+
+func bit(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// addFlags returns a + b along with the flags the addition sets
+func addFlags(a, b int64) (int64, Flags) {
+	result := a + b
+	return result, Flags{
+		N: result < 0,
+		Z: result == 0,
+		C: uint64(result) < uint64(a),
+		V: (a < 0) == (b < 0) && (result < 0) != (a < 0),
+	}
+}
+
+// subFlags returns a - b along with the flags the subtraction sets
+func subFlags(a, b int64) (int64, Flags) {
+	result := a - b
+	return result, Flags{
+		N: result < 0,
+		Z: result == 0,
+		C: uint64(a) >= uint64(b),
+		V: (a < 0) != (b < 0) && (result < 0) != (a < 0),
+	}
+}
+
+// logicFlags returns the flags set by a logical operation, C and V are cleared
+func logicFlags(result int64) Flags {
+	return Flags{N: result < 0, Z: result == 0}
+}
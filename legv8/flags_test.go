@@ -0,0 +1,102 @@
+package legv8
+
+import (
+	"math"
+	"testing"
+)
+
+func TestAddFlags(t *testing.T) {
+	tests := []struct {
+		a, b   int64
+		result int64
+		flags  string
+	}{
+		{1, 2, 3, ""},
+		{0, 0, 0, "Z"},
+		{-1, 1, 0, "ZC"},
+		{-1, -1, -2, "NC"},
+		{math.MaxInt64, 1, math.MinInt64, "NV"},
+		{math.MinInt64, -1, math.MaxInt64, "CV"},
+		{math.MinInt64, math.MinInt64, 0, "ZCV"},
+		{math.MaxInt64, math.MaxInt64, -2, "NV"},
+		{-2, 1, -1, "N"},
+	}
+	for _, test := range tests {
+		result, flags := addFlags(test.a, test.b)
+		if result != test.result || letters(flags) != test.flags {
+			t.Errorf("%d + %d = %d %q, want %d %q", test.a, test.b, result, letters(flags), test.result, test.flags)
+		}
+	}
+}
+
+func TestSubFlags(t *testing.T) {
+	tests := []struct {
+		a, b   int64
+		result int64
+		flags  string
+	}{
+		{3, 2, 1, "C"},
+		{2, 2, 0, "ZC"},
+		{2, 3, -1, "N"},
+		{0, 0, 0, "ZC"},
+		{-1, -1, 0, "ZC"},
+		{0, 1, -1, "N"},
+		{-1, 0, -1, "NC"},
+		{math.MinInt64, 1, math.MaxInt64, "CV"},
+		{math.MaxInt64, -1, math.MinInt64, "NV"},
+		{0, math.MinInt64, math.MinInt64, "NV"},
+		{math.MinInt64, math.MinInt64, 0, "ZC"},
+	}
+	for _, test := range tests {
+		result, flags := subFlags(test.a, test.b)
+		if result != test.result || letters(flags) != test.flags {
+			t.Errorf("%d - %d = %d %q, want %d %q", test.a, test.b, result, letters(flags), test.result, test.flags)
+		}
+	}
+}
+
+// TestConditions compares each B.cond condition with the signed or unsigned
+// comparison it stands for after SUBS a, b
+func TestConditions(t *testing.T) {
+	values := []int64{0, 1, -1, 2, math.MaxInt64, math.MinInt64, math.MinInt64 + 1}
+	compare := map[string]func(a, b int64) bool{
+		"EQ": func(a, b int64) bool { return a == b },
+		"NE": func(a, b int64) bool { return a != b },
+		"HS": func(a, b int64) bool { return uint64(a) >= uint64(b) },
+		"LO": func(a, b int64) bool { return uint64(a) < uint64(b) },
+		"HI": func(a, b int64) bool { return uint64(a) > uint64(b) },
+		"LS": func(a, b int64) bool { return uint64(a) <= uint64(b) },
+		"GE": func(a, b int64) bool { return a >= b },
+		"LT": func(a, b int64) bool { return a < b },
+		"GT": func(a, b int64) bool { return a > b },
+		"LE": func(a, b int64) bool { return a <= b },
+	}
+	for cond, name := range conditions {
+		want, ok := compare[name]
+		if !ok {
+			continue
+		}
+		for _, a := range values {
+			for _, b := range values {
+				_, flags := subFlags(a, b)
+				if got := flags.holds(uint8(cond)); got != want(a, b) {
+					t.Errorf("B.%s after SUBS %d, %d is %v, want %v", name, a, b, got, want(a, b))
+				}
+			}
+		}
+	}
+}
+
+// letters returns the flags that are set, e.g. "NC"
+func letters(f Flags) string {
+	s := ""
+	for _, flag := range []struct {
+		set    bool
+		letter string
+	}{{f.N, "N"}, {f.Z, "Z"}, {f.C, "C"}, {f.V, "V"}} {
+		if flag.set {
+			s += flag.letter
+		}
+	}
+	return s
+}
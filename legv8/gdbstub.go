@@ -0,0 +1,429 @@
+package legv8
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// register numbers of the aarch64 register layout gdb uses
+const (
+	gdbSP   = 31 // sp, mapped onto the LEGv8 stack pointer X28
+	gdbPC   = 32
+	gdbCPSR = 33 // only the NZCV bits are used
+)
+
+// gdbTargetXML tells gdb the registers are the aarch64 core registers
+var gdbTargetXML = `<?xml version="1.0"?>
+<!DOCTYPE target SYSTEM "gdb-target.dtd">
+<target>
+<architecture>aarch64</architecture>
+<feature name="org.gnu.gdb.aarch64.core">
+` + gdbRegisterXML + `</feature>
+</target>`
+
+var gdbRegisterXML = func() string {
+	var str strings.Builder
+	for i := 0; i <= 30; i++ {
+		fmt.Fprintf(&str, "<reg name=\"x%d\" bitsize=\"64\"/>\n", i)
+	}
+	str.WriteString("<reg name=\"sp\" bitsize=\"64\" type=\"data_ptr\"/>\n")
+	str.WriteString("<reg name=\"pc\" bitsize=\"64\" type=\"code_ptr\"/>\n")
+	str.WriteString("<reg name=\"cpsr\" bitsize=\"32\"/>\n")
+	return str.String()
+}()
+
+// GDBStub serves the GDB Remote Serial Protocol for one machine, so gdb
+// (target remote) and other frontends can drive the simulator.
+type GDBStub struct {
+	Machine     *Machine
+	Breakpoints map[int]bool
+
+	conn  io.ReadWriter
+	input chan byte // bytes from the connection, read in the background
+	err   error     // read error that closed input
+}
+
+// NewGDBStub returns a stub for a loaded machine.
+func NewGDBStub(m *Machine) *GDBStub {
+	return &GDBStub{Machine: m, Breakpoints: make(map[int]bool)}
+}
+
+// Serve handles packets on conn until gdb detaches, kills the program or
+// closes the connection.
+func (g *GDBStub) Serve(conn io.ReadWriter) error {
+	g.conn = conn
+	g.input = make(chan byte, 4096)
+	go func() {
+		reader := bufio.NewReader(conn)
+		for {
+			b, err := reader.ReadByte()
+			if err != nil {
+				g.err = err
+				close(g.input)
+				return
+			}
+			g.input <- b
+		}
+	}()
+
+	for {
+		packet, err := g.readPacket()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		reply, done := g.handle(packet)
+		if err := g.writePacket(reply); err != nil {
+			return err
+		}
+		if done {
+			return nil
+		}
+	}
+}
+
+// readPacket returns the data of the next $data#checksum packet, a lone
+// interrupt byte outside of a packet is ignored since the machine is stopped
+func (g *GDBStub) readPacket() (string, error) {
+	for {
+		b, ok := <-g.input
+		if !ok {
+			return "", g.err
+		}
+		if b != '$' {
+			continue // acks and interrupts
+		}
+		var data []byte
+		for {
+			b, ok = <-g.input
+			if !ok {
+				return "", g.err
+			}
+			if b == '#' {
+				break
+			}
+			data = append(data, b)
+		}
+		sum := make([]byte, 2)
+		for i := range sum {
+			if sum[i], ok = <-g.input; !ok {
+				return "", g.err
+			}
+		}
+		if want, err := strconv.ParseUint(string(sum), 16, 8); err != nil || byte(want) != checksum(data) {
+			if _, err := g.conn.Write([]byte("-")); err != nil {
+				return "", err
+			}
+			continue
+		}
+		if _, err := g.conn.Write([]byte("+")); err != nil {
+			return "", err
+		}
+		return string(data), nil
+	}
+}
+
+// writePacket frames and sends a reply
+func (g *GDBStub) writePacket(data string) error {
+	_, err := fmt.Fprintf(g.conn, "$%s#%02x", data, checksum([]byte(data)))
+	return err
+}
+
+func checksum(data []byte) byte {
+	var sum byte
+	for _, b := range data {
+		sum += b
+	}
+	return sum
+}
+
+// handle runs one packet and returns the reply, done is true once the
+// session is over
+func (g *GDBStub) handle(packet string) (reply string, done bool) {
+	m := g.Machine
+	switch {
+	case packet == "?":
+		return g.stopReply(nil), false
+	case strings.HasPrefix(packet, "qSupported"):
+		if m.History != nil {
+			return "PacketSize=4000;qXfer:features:read+;ReverseStep+;ReverseContinue+", false
+		}
+		return "PacketSize=4000;qXfer:features:read+", false
+	case strings.HasPrefix(packet, "qXfer:features:read:target.xml:"):
+		return g.xfer(strings.TrimPrefix(packet, "qXfer:features:read:target.xml:")), false
+	case packet == "qAttached":
+		return "1", false
+	case packet == "qC":
+		return "QC1", false
+	case packet == "qfThreadInfo":
+		return "m1", false
+	case packet == "qsThreadInfo":
+		return "l", false
+	case strings.HasPrefix(packet, "H"), strings.HasPrefix(packet, "T"):
+		return "OK", false
+	case packet == "g":
+		var str strings.Builder
+		for r := 0; r <= gdbCPSR; r++ {
+			str.WriteString(g.readRegister(r))
+		}
+		return str.String(), false
+	case strings.HasPrefix(packet, "G"):
+		data := packet[1:]
+		for r := 0; r <= gdbCPSR && len(data) > 0; r++ {
+			size := 16
+			if r == gdbCPSR {
+				size = 8
+			}
+			if len(data) < size || g.writeRegister(r, data[:size]) != nil {
+				return "E01", false
+			}
+			data = data[size:]
+		}
+		return "OK", false
+	case strings.HasPrefix(packet, "p"):
+		r, err := strconv.ParseUint(packet[1:], 16, 8)
+		if err != nil || r > gdbCPSR {
+			return "E01", false
+		}
+		return g.readRegister(int(r)), false
+	case strings.HasPrefix(packet, "P"):
+		reg, value, ok := strings.Cut(packet[1:], "=")
+		r, err := strconv.ParseUint(reg, 16, 8)
+		if !ok || err != nil || r > gdbCPSR || g.writeRegister(int(r), value) != nil {
+			return "E01", false
+		}
+		return "OK", false
+	case strings.HasPrefix(packet, "m"):
+		addr, length, ok := g.addrLength(packet[1:])
+		if !ok {
+			return "E01", false
+		}
+		data := make([]byte, length)
+		for i := range data {
+			b, err := g.readByte(addr + i)
+			if err != nil {
+				return "E01", false
+			}
+			data[i] = b
+		}
+		return hex.EncodeToString(data), false
+	case strings.HasPrefix(packet, "M"):
+		header, payload, _ := strings.Cut(packet[1:], ":")
+		addr, length, ok := g.addrLength(header)
+		data, err := hex.DecodeString(payload)
+		if !ok || err != nil || len(data) != length {
+			return "E01", false
+		}
+		for i, b := range data {
+			if m.Memory.Store(addr+i, 1, uint64(b)) != nil {
+				return "E01", false
+			}
+		}
+		return "OK", false
+	case strings.HasPrefix(packet, "Z0,"), strings.HasPrefix(packet, "Z1,"),
+		strings.HasPrefix(packet, "z0,"), strings.HasPrefix(packet, "z1,"):
+		fields := strings.Split(packet[3:], ",")
+		addr, err := strconv.ParseUint(fields[0], 16, 64)
+		if err != nil {
+			return "E01", false
+		}
+		if packet[0] == 'Z' {
+			g.Breakpoints[int(addr)] = true
+		} else {
+			delete(g.Breakpoints, int(addr))
+		}
+		return "OK", false
+	case strings.HasPrefix(packet, "s"):
+		if len(packet) > 1 && g.setPC(packet[1:]) != nil {
+			return "E01", false
+		}
+		return g.stopReply(g.step()), m.Halted
+	case strings.HasPrefix(packet, "c"):
+		if len(packet) > 1 && g.setPC(packet[1:]) != nil {
+			return "E01", false
+		}
+		return g.stopReply(g.cont()), m.Halted
+	case packet == "bs", packet == "bc":
+		if m.History == nil {
+			return "E01", false
+		}
+		if err := g.back(packet == "bc"); err != nil {
+			return "T05replaylog:begin;", false
+		}
+		return "S05", false
+	case packet == "D":
+		return "OK", true
+	case packet == "k":
+		return "OK", true
+	}
+	return "", false // not supported
+}
+
+// xfer answers a qXfer read of the target description
+func (g *GDBStub) xfer(window string) string {
+	offset, length, ok := g.addrLength(window)
+	if !ok {
+		return "E01"
+	}
+	if offset >= len(gdbTargetXML) {
+		return "l"
+	}
+	end := offset + length
+	if end >= len(gdbTargetXML) {
+		return "l" + gdbTargetXML[offset:]
+	}
+	return "m" + gdbTargetXML[offset:end]
+}
+
+// addrLength reads the "addr,length" hex pair used by several packets
+func (g *GDBStub) addrLength(text string) (int, int, bool) {
+	a, l, ok := strings.Cut(text, ",")
+	addr, err1 := strconv.ParseUint(a, 16, 64)
+	length, err2 := strconv.ParseUint(l, 16, 32)
+	return int(addr), int(length), ok && err1 == nil && err2 == nil
+}
+
+// readByte reads program or data memory. Addresses that hold instructions
+// read back the instruction encoding, so gdb can disassemble them.
+func (g *GDBStub) readByte(addr int) (byte, error) {
+	m := g.Machine
+	word := addr &^ 3
+	if inst, err := m.Fetch(word); err == nil && !g.isData(word) {
+		return byte(inst.LineValue >> (8 * (addr - word))), nil
+	}
+	value, err := m.Memory.Load(addr, 1)
+	return byte(value), err
+}
+
+// isData reports whether a program address is one of the data words after BREAK
+func (g *GDBStub) isData(addr int) bool {
+	for _, inst := range g.Machine.Program {
+		if inst.Op == "BREAK" {
+			return addr > inst.ProgramCnt
+		}
+	}
+	return false
+}
+
+// readRegister returns a register as little endian hex
+func (g *GDBStub) readRegister(r int) string {
+	m := g.Machine
+	var buf [8]byte
+	switch {
+	case r == gdbCPSR:
+		var cpsr uint32
+		for i, set := range []bool{m.Flags.N, m.Flags.Z, m.Flags.C, m.Flags.V} {
+			if set {
+				cpsr |= 1 << (31 - i)
+			}
+		}
+		binary.LittleEndian.PutUint32(buf[:4], cpsr)
+		return hex.EncodeToString(buf[:4])
+	case r == gdbPC:
+		binary.LittleEndian.PutUint64(buf[:], uint64(m.PC))
+	case r == gdbSP:
+		binary.LittleEndian.PutUint64(buf[:], uint64(m.Registers[SP]))
+	default:
+		binary.LittleEndian.PutUint64(buf[:], uint64(m.Registers[r]))
+	}
+	return hex.EncodeToString(buf[:])
+}
+
+// writeRegister sets a register from little endian hex
+func (g *GDBStub) writeRegister(r int, text string) error {
+	m := g.Machine
+	data, err := hex.DecodeString(text)
+	if err != nil {
+		return err
+	}
+	var buf [8]byte
+	copy(buf[:], data)
+	value := binary.LittleEndian.Uint64(buf[:])
+	switch {
+	case r == gdbCPSR:
+		m.Flags = Flags{N: value&(1<<31) != 0, Z: value&(1<<30) != 0, C: value&(1<<29) != 0, V: value&(1<<28) != 0}
+	case r == gdbPC:
+		m.PC = int(value)
+	case r == gdbSP:
+		m.Registers[SP] = int64(value)
+	case r == XZR:
+		// XZR can't be written
+	default:
+		m.Registers[r] = int64(value)
+	}
+	return nil
+}
+
+// setPC handles the optional resume address of s and c
+func (g *GDBStub) setPC(text string) error {
+	addr, err := strconv.ParseUint(text, 16, 64)
+	if err == nil {
+		g.Machine.PC = int(addr)
+	}
+	return err
+}
+
+// step runs one instruction
+func (g *GDBStub) step() error {
+	if g.Machine.Halted {
+		return ErrHalted
+	}
+	_, err := g.Machine.Step()
+	return err
+}
+
+// cont runs until a breakpoint, BREAK, a fault or an interrupt from gdb
+func (g *GDBStub) cont() error {
+	for first := true; ; first = false {
+		if !first && g.Breakpoints[g.Machine.PC] {
+			return nil
+		}
+		if err := g.step(); err != nil || g.Machine.Halted {
+			return err
+		}
+		select {
+		case b, ok := <-g.input:
+			if !ok || b == 0x03 {
+				return nil
+			}
+		default:
+		}
+	}
+}
+
+// back runs backwards one instruction, or to a breakpoint when cont is set.
+// It returns an error once the start of the history is reached.
+func (g *GDBStub) back(cont bool) error {
+	for {
+		if err := g.Machine.StepBack(); err != nil {
+			return err
+		}
+		if !cont || g.Breakpoints[g.Machine.PC] {
+			return nil
+		}
+	}
+}
+
+// stopReply says why the machine stopped: exited once BREAK or the exit
+// syscall retires,
+// SIGSEGV for memory faults and SIGTRAP for everything else
+func (g *GDBStub) stopReply(err error) string {
+	var fault *MemoryFault
+	switch {
+	case g.Machine.Halted:
+		return fmt.Sprintf("W%02x", uint8(g.Machine.ExitCode))
+	case errors.As(err, &fault):
+		return "S0b"
+	case err != nil:
+		return "S04"
+	}
+	return "S05"
+}
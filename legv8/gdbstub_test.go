@@ -0,0 +1,121 @@
+package legv8
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+)
+
+// gdbClient sends packets to a stub over a pipe and reads the replies
+type gdbClient struct {
+	t      *testing.T
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// request sends one packet and returns the data of the reply
+func (c *gdbClient) request(packet string) string {
+	c.t.Helper()
+	if _, err := fmt.Fprintf(c.conn, "$%s#%02x", packet, checksum([]byte(packet))); err != nil {
+		c.t.Fatal(err)
+	}
+	if ack, err := c.reader.ReadByte(); err != nil || ack != '+' {
+		c.t.Fatalf("%s: ack is %q, %v", packet, ack, err)
+	}
+	if _, err := c.reader.ReadString('$'); err != nil {
+		c.t.Fatal(err)
+	}
+	data, err := c.reader.ReadString('#')
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if _, err := c.reader.Discard(2); err != nil {
+		c.t.Fatal(err)
+	}
+	return strings.TrimSuffix(data, "#")
+}
+
+// le64 returns a value as the little endian hex gdb uses for registers
+func le64(value uint64) string {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], value)
+	return hex.EncodeToString(buf[:])
+}
+
+func TestGDBStub(t *testing.T) {
+	program := assemble(t, `
+	ADDI X1, XZR, #5
+	ADDI X2, X1, #1
+	STUR X2, [XZR, #50]
+	BREAK`)
+	m := NewMachine()
+	m.Load(program)
+
+	server, conn := net.Pipe()
+	done := make(chan error, 1)
+	go func() { done <- NewGDBStub(m).Serve(server) }()
+	c := &gdbClient{t, conn, bufio.NewReader(conn)}
+
+	var addi [4]byte
+	binary.LittleEndian.PutUint32(addi[:], uint32(program[0].LineValue))
+	for _, step := range []struct{ packet, reply string }{
+		{"?", "S05"},
+		{"p20", le64(96)}, // pc
+		{"m60,4", hex.EncodeToString(addi[:])},
+		{"Z0,64,4", "OK"},
+		{"c", "S05"},
+		{"p20", le64(100)},
+		{"p1", le64(5)},
+		{"P1=0900000000000000", "OK"},
+		{"p1", le64(9)},
+		{"Mc8,4:01000000", "OK"},
+		{"mc8,4", "01000000"},
+		{"z0,64,4", "OK"},
+		{"s", "S05"},
+		{"p2", le64(10)},
+		{"p21", "00000000"}, // cpsr
+		{"vMustReplyEmpty", ""},
+		{"c", "W00"},
+	} {
+		if got := c.request(step.packet); got != step.reply {
+			t.Fatalf("%s: reply is %q, want %q", step.packet, got, step.reply)
+		}
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if word, _ := m.Memory.Load(200, 4); word != 10 {
+		t.Errorf("STUR wrote %d, want 10", word)
+	}
+}
+
+func TestGDBStubChecksum(t *testing.T) {
+	m := NewMachine()
+	m.Load(assemble(t, "BREAK"))
+	server, conn := net.Pipe()
+	done := make(chan error, 1)
+	go func() { done <- NewGDBStub(m).Serve(server) }()
+	c := &gdbClient{t, conn, bufio.NewReader(conn)}
+
+	if _, err := fmt.Fprint(conn, "$?#00"); err != nil {
+		t.Fatal(err)
+	}
+	if nak, _ := c.reader.ReadByte(); nak != '-' {
+		t.Errorf("a bad checksum is answered with %q, want -", nak)
+	}
+	if got := c.request("g"); len(got) != 33*16+8 {
+		t.Errorf("g returns %d hex digits, want %d", len(got), 33*16+8)
+	}
+	if got := c.request("D"); got != "OK" {
+		t.Errorf("D returns %q", got)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+}
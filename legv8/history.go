@@ -0,0 +1,283 @@
+package legv8
+
+import (
+	"fmt"
+	"io"
+)
+
+// Write is one register or memory write made by a retired instruction.
+type Write struct {
+	Cycle int // cycle of the instruction that made the write
+	PC    int // address of that instruction
+	Reg   int // register written, -1 for a memory write
+	Addr  int // first byte of a memory write
+	Size  int // bytes of a memory write
+	Old   int64
+	New   int64
+
+	prior []memByte // the bytes a memory write replaced
+}
+
+func (w Write) String() string {
+	if w.Reg >= 0 {
+		return fmt.Sprintf("cycle %d, pc %d wrote R%d: %d -> %d", w.Cycle, w.PC, w.Reg, w.Old, w.New)
+	}
+	return fmt.Sprintf("cycle %d, pc %d wrote %d bytes at %d: %d -> %d", w.Cycle, w.PC, w.Size, w.Addr, w.Old, w.New)
+}
+
+// undoRecord is what it takes to put the machine back to before one
+// instruction: the state that is cheap to copy and the writes it made
+type undoRecord struct {
+	pc, cycle           int
+	flags               Flags
+	callStack           []Frame
+	halted              bool
+	exitCode            int
+	elr, esr, far, vbar int
+	spsr                Flags
+	masked              bool
+	writes              []Write
+}
+
+// History is an undo log of the instructions a machine retires, so the
+// machine can run backwards. Only the last Limit instructions are kept in
+// the log. Every Interval cycles a checkpoint is taken as well, going back
+// further than the log restores the closest checkpoint and runs forward
+// from it. Checkpoints are dropped once a later one is as old as the log,
+// so the history reaches back to the last checkpoint in front of the log.
+// Device state and input are not replayed.
+type History struct {
+	Limit    int
+	Interval int
+
+	records     []undoRecord // ring buffer of up to Limit records
+	first       int          // index of the oldest record
+	count       int          // records in the ring
+	checkpoints []*Checkpoint
+	current     *undoRecord // record of the instruction being run
+}
+
+// record returns the i-th record, counted from the oldest
+func (h *History) record(i int) *undoRecord {
+	return &h.records[(h.first+i)%len(h.records)]
+}
+
+// push adds a record, overwriting the oldest one once the log is full
+func (h *History) push(r undoRecord) {
+	if h.Limit <= 0 {
+		return
+	}
+	if len(h.records) != h.Limit {
+		// the limit changed or this is the first record, start a new ring
+		// with the newest records that still fit
+		ring := make([]undoRecord, h.Limit)
+		keep := h.count
+		if keep > h.Limit {
+			keep = h.Limit
+		}
+		for i := 0; i < keep; i++ {
+			ring[i] = *h.record(h.count - keep + i)
+		}
+		h.records, h.first, h.count = ring, 0, keep
+	}
+	if h.count < len(h.records) {
+		*h.record(h.count) = r
+		h.count++
+		return
+	}
+	h.records[h.first] = r
+	h.first = (h.first + 1) % len(h.records)
+}
+
+// pop removes and returns the newest record
+func (h *History) pop() undoRecord {
+	r := h.record(h.count - 1)
+	h.count--
+	popped := *r
+	*r = undoRecord{} // let go of the writes
+	return popped
+}
+
+// clear empties the log
+func (h *History) clear() {
+	h.records, h.first, h.count = nil, 0, 0
+}
+
+// NewHistory returns an empty history, limit and interval are in cycles.
+func NewHistory(limit, interval int) *History {
+	return &History{Limit: limit, Interval: interval}
+}
+
+// Record starts keeping a history of the machine from its current state.
+func (m *Machine) Record(h *History) error {
+	m.History = h
+	h.clear()
+	h.checkpoints, h.current = nil, nil
+	c, err := m.Checkpoint()
+	if err != nil {
+		return err
+	}
+	h.checkpoints = append(h.checkpoints, c)
+	return nil
+}
+
+// begin saves the state in front of an instruction
+func (h *History) begin(m *Machine) {
+	h.current = &undoRecord{
+		pc: m.PC, cycle: m.Cycle, flags: m.Flags, callStack: append([]Frame(nil), m.CallStack...),
+		halted: m.Halted, exitCode: m.ExitCode,
+		elr: m.ELR, esr: m.ESR, far: m.FAR, vbar: m.VBAR, spsr: m.SPSR, masked: m.Masked,
+	}
+}
+
+// write adds a write to the record of the instruction being run
+func (h *History) write(w Write) {
+	if h.current != nil {
+		w.Cycle, w.PC = h.current.cycle+1, h.current.pc
+		h.current.writes = append(h.current.writes, w)
+	}
+}
+
+// abort drops the record of an instruction that faulted
+func (h *History) abort() {
+	h.current = nil
+}
+
+// end keeps the record of an instruction that retired
+func (h *History) end(m *Machine) {
+	if h.current == nil {
+		return
+	}
+	h.push(*h.current)
+	h.current = nil
+	if h.Interval > 0 && m.Cycle%h.Interval == 0 {
+		if c, err := m.Checkpoint(); err == nil {
+			h.checkpoints = append(h.checkpoints, c)
+		}
+	}
+	// a checkpoint is only needed to reach cycles in front of the log
+	oldest := m.Cycle - h.count
+	for len(h.checkpoints) > 1 && h.checkpoints[1].Cycle <= oldest {
+		h.checkpoints[0] = nil
+		h.checkpoints = h.checkpoints[1:]
+	}
+}
+
+// StepBack undoes the last retired instruction.
+func (m *Machine) StepBack() error {
+	h := m.History
+	if h == nil {
+		return fmt.Errorf("legv8: no history is being recorded")
+	}
+	if h.count == 0 {
+		if m.Cycle == 0 || len(h.checkpoints) == 0 || h.checkpoints[0].Cycle >= m.Cycle {
+			return fmt.Errorf("legv8: at the start of the history")
+		}
+		return m.Rewind(m.Cycle - 1)
+	}
+	r := h.pop()
+	for i := len(r.writes) - 1; i >= 0; i-- {
+		w := r.writes[i]
+		if w.Reg >= 0 {
+			m.Registers[w.Reg] = w.Old
+		} else {
+			m.Memory.restore(w.Addr, w.prior)
+		}
+	}
+	m.PC, m.Cycle, m.Flags, m.CallStack = r.pc, r.cycle, r.flags, r.callStack
+	m.Halted, m.ExitCode = r.halted, r.exitCode
+	m.ELR, m.ESR, m.FAR, m.VBAR, m.SPSR, m.Masked = r.elr, r.esr, r.far, r.vbar, r.spsr, r.masked
+	m.Exception = nil
+	if m.Loops != nil {
+		m.Loops.reset()
+	}
+	for len(h.checkpoints) > 1 && h.checkpoints[len(h.checkpoints)-1].Cycle > m.Cycle {
+		h.checkpoints = h.checkpoints[:len(h.checkpoints)-1]
+	}
+	return nil
+}
+
+// Rewind puts the machine back to the state it had after cycle retired.
+func (m *Machine) Rewind(cycle int) error {
+	h := m.History
+	if h == nil {
+		return fmt.Errorf("legv8: no history is being recorded")
+	}
+	if cycle > m.Cycle {
+		return fmt.Errorf("legv8: cycle %d has not run yet", cycle)
+	}
+	oldest := m.Cycle - h.count
+	if cycle >= oldest {
+		for m.Cycle > cycle {
+			if err := m.StepBack(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// further back than the log, start from a checkpoint and run forward
+	var from *Checkpoint
+	for _, c := range h.checkpoints {
+		if c.Cycle <= cycle {
+			from = c
+		}
+	}
+	if from == nil {
+		return fmt.Errorf("legv8: cycle %d is before the start of the history", cycle)
+	}
+	if err := m.Restore(from); err != nil {
+		return err
+	}
+	kept := h.checkpoints[:0]
+	for _, c := range h.checkpoints {
+		if c.Cycle <= from.Cycle {
+			kept = append(kept, c)
+		}
+	}
+	h.checkpoints = kept
+	h.clear()
+
+	// replaying must not print again or change the statistics
+	output, caches, branches := m.Output, m.Caches, m.Branches
+	m.Output, m.Caches, m.Branches = io.Discard, nil, nil
+	defer func() { m.Output, m.Caches, m.Branches = output, caches, branches }()
+	for m.Cycle < cycle {
+		if _, err := m.Step(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LastRegisterWrite returns the last write to register r still in the log.
+func (h *History) LastRegisterWrite(r int) (Write, bool) {
+	return h.last(func(w Write) bool { return w.Reg == r })
+}
+
+// LastMemoryWrite returns the last write still in the log that changed the
+// byte at addr.
+func (h *History) LastMemoryWrite(addr int) (Write, bool) {
+	return h.last(func(w Write) bool { return w.Reg < 0 && w.Addr <= addr && addr < w.Addr+w.Size })
+}
+
+func (h *History) last(match func(Write) bool) (Write, bool) {
+	for i := h.count - 1; i >= 0; i-- {
+		writes := h.record(i).writes
+		for j := len(writes) - 1; j >= 0; j-- {
+			if match(writes[j]) {
+				return writes[j], true
+			}
+		}
+	}
+	return Write{}, false
+}
+
+// Oldest returns the earliest cycle the log goes back to without using a
+// checkpoint.
+func (m *Machine) Oldest() int {
+	if m.History == nil {
+		return m.Cycle
+	}
+	return m.Cycle - m.History.count
+}
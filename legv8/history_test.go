@@ -0,0 +1,109 @@
+package legv8
+
+import (
+	"reflect"
+	"testing"
+)
+
+// machineState is what running backwards has to put back
+type machineState struct {
+	PC, Cycle int
+	Registers [32]int64
+	Flags     Flags
+	Memory    map[int]int32
+}
+
+func stateOf(m *Machine) machineState {
+	return machineState{m.PC, m.Cycle, m.Registers, m.Flags, memoryWords(m)}
+}
+
+// memoryWords returns the words of memory that are not zero
+func memoryWords(m *Machine) map[int]int32 {
+	words := make(map[int]int32)
+	for _, addr := range m.Memory.Words() {
+		if word := m.Memory.Word(addr); word != 0 {
+			words[addr] = word
+		}
+	}
+	return words
+}
+
+func TestHistory(t *testing.T) {
+	program := assemble(t, checkpointProgram)
+	tests := []struct {
+		name            string
+		limit, interval int
+	}{
+		{"log holds everything", 100, 0},
+		{"ring wraps", 7, 0},
+		{"ring wraps with checkpoints", 7, 5},
+		{"checkpoints only", 0, 4},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := deviceMachine(t, program)
+			h := NewHistory(test.limit, test.interval)
+			if err := m.Record(h); err != nil {
+				t.Fatal(err)
+			}
+			states := []machineState{stateOf(m)}
+			for {
+				inst, err := m.Step()
+				if err != nil {
+					t.Fatal(err)
+				}
+				states = append(states, stateOf(m))
+				if inst.Op == "BREAK" {
+					break
+				}
+			}
+			end := len(states) - 1
+
+			if want := end - minCount(end, test.limit); m.Oldest() != want {
+				t.Errorf("oldest cycle in the log is %d, want %d", m.Oldest(), want)
+			}
+			// the history reaches back to the last checkpoint in front of the log
+			first := h.checkpoints[0].Cycle
+			if first > m.Oldest() || test.interval > 0 && m.Oldest()-first >= test.interval {
+				t.Errorf("oldest checkpoint is at cycle %d, the log goes back to %d", first, m.Oldest())
+			}
+
+			for cycle := end - 1; cycle >= m.Oldest(); cycle-- {
+				if err := m.StepBack(); err != nil {
+					t.Fatalf("StepBack to cycle %d: %v", cycle, err)
+				}
+				if got := stateOf(m); !reflect.DeepEqual(got, states[cycle]) {
+					t.Fatalf("after StepBack to cycle %d the state is %+v, want %+v", cycle, got, states[cycle])
+				}
+			}
+			if err := m.Rewind(first); err != nil {
+				t.Fatalf("Rewind(%d): %v", first, err)
+			}
+			if got := stateOf(m); !reflect.DeepEqual(got, states[first]) {
+				t.Errorf("after Rewind(%d) the state is %+v, want %+v", first, got, states[first])
+			}
+			if first > 0 {
+				if err := m.Rewind(first - 1); err == nil {
+					t.Errorf("Rewind(%d) went past the oldest checkpoint", first-1)
+				}
+			}
+		})
+	}
+}
+
+func TestHistoryNeedsCheckpoints(t *testing.T) {
+	m := NewMachine()
+	if err := m.Map(0xFF00, plainDevice{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Record(NewHistory(10, 10)); err == nil {
+		t.Error("recording a history of a machine that cannot be checkpointed did not fail")
+	}
+}
+
+func minCount(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,91 @@
+package legv8
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// BaseAddress is the address of the first instruction of every program.
+const BaseAddress = 96
+
+type Instruction struct {
+	TypeOfInstruction string // instruction type "R", "I", etc..
+	RawInstruction    string // raw data (we need to run this through a function that figures out the OPcode)
+	LineValue         uint64 // linevalue = rawinstruction converted to uint64 so I could use mask and shift on it.
+	Field             uint64
+	Opcode            uint64 // once we know this we can figure out everything else
+	Op                string // what is it? ADD, SUB, LSL, etc...
+	Rd                uint8
+	Rn                uint8
+	Rm                uint8
+	Im                uint8
+	Rt                uint8
+	Address           uint8
+	Offset            int32
+	Conditional       uint8
+	Shamt             uint8
+	Op2               uint8
+	Cycle             int
+	ProgramCnt        int // program counter
+}
+
+// ReadFile reads the file and loads each line into the RawInstruction part of the Instruction
+func ReadFile(fileBeingRead io.Reader) (inputParsed []Instruction, err error) {
+	index := 0
+
+	// ^^ begins reading from the fileBeingRead (io.Reader is like an iostream) ...
+	// ... and prepares an array of Instructions that will be returned later
+	scanner := bufio.NewScanner(fileBeingRead) // "scanner" is a bufio.NewScanner of the file being read. ...
+
+	// ...By default, a "Scan" terminates at new line.
+	for scanner.Scan() { // for each Scan (each line to read) in "scanner"
+		newInstruction := Instruction{RawInstruction: scanner.Text()} // creates a new Instruction and assigns...
+		// ...scanner.Text() to rawInstruction (scanner.Text() is the text of one line of the input file)
+		inputParsed = append(inputParsed, newInstruction) // add the newInstruction (containing the raw data) to the...
+		// ...array of instructions
+
+		// set the current memory value then increment by 4
+		inputParsed[index].ProgramCnt = BaseAddress + (4 * index)
+		index++
+	}
+	err = scanner.Err()
+
+	return
+}
+
+// InitializeInstructions intializes all values of the struct array based on the line value,
+// the instruction table in opcodes.go says which bits hold which field.
+// Everything after BREAK is data and is left undecoded. A line that is not a
+// binary number of at most 32 digits is an error, instruction or data.
+func InitializeInstructions(instArray []Instruction) error {
+	decoding := true
+	for i := 0; i < len(instArray); i++ {
+		//the below converts 32 characters from a base 2 string to base 10 uint64
+		lineValue, err := strconv.ParseUint(instArray[i].RawInstruction, 2, 32)
+		if err != nil {
+			return fmt.Errorf("legv8: line %d: %q is not a 32 bit binary number", i+1, instArray[i].RawInstruction)
+		}
+		if !decoding {
+			// data words keep their bits, so a jump into the data reports them
+			instArray[i].LineValue = lineValue
+			continue
+		}
+		if lineValue > 335544320 || lineValue == 0 {
+			decode(&instArray[i], lineValue)
+		}
+		decoding = instArray[i].Op != "BREAK"
+	}
+	return nil
+}
+
+// check for signed variable to convert to negation using two's complement
+func signedVariable(value uint64, length int) int32 {
+	var temp = value >> (length - 1)
+
+	if temp == 1 {
+		value = value | (0xFFFFFFFF << length)
+	}
+	return int32(value)
+}
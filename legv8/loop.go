@@ -0,0 +1,117 @@
+package legv8
+
+import "fmt"
+
+// CycleLimitError is returned by Step once MaxCycles instructions have
+// retired.
+type CycleLimitError struct {
+	Limit int
+}
+
+func (e *CycleLimitError) Error() string {
+	return fmt.Sprintf("legv8: stopped at the limit of %d cycles", e.Limit)
+}
+
+// LoopError is returned by Step when the machine is in a state it has been
+// in before. Nothing outside of the machine can change what it does next, so
+// it would run the same Period cycles forever.
+type LoopError struct {
+	Cycle  int // cycle the repeated state was first seen after
+	Period int // cycles between repeats
+	Low    int // lowest address the loop runs
+	High   int // highest address the loop runs
+}
+
+func (e *LoopError) Error() string {
+	return fmt.Sprintf("legv8: infinite loop: the state after cycle %d repeats every %d cycles, running pc %d to %d",
+		e.Cycle, e.Period, e.Low, e.High)
+}
+
+// LoopDetector spots a machine going around a loop it can never leave, by
+// hashing the PC, registers, flags, memory and device state before every
+// instruction. It uses Brent's algorithm: the hash is saved at every power
+// of two cycles and each later hash compared to it, which finds any loop
+// within twice its length plus the cycles in front of it without keeping a
+// history. Reads from devices and the input start it over, since what comes
+// in can break the loop.
+type LoopDetector struct {
+	saved     uint64
+	savedAt   int // cycle the saved hash is from, -1 for none
+	power     int
+	low, high int // addresses run since savedAt
+}
+
+// NewLoopDetector returns a detector with nothing seen yet.
+func NewLoopDetector() *LoopDetector {
+	d := &LoopDetector{}
+	d.reset()
+	return d
+}
+
+// reset forgets everything seen so far
+func (d *LoopDetector) reset() {
+	d.savedAt, d.power = -1, 1
+}
+
+// check looks at the state in front of the next instruction
+func (d *LoopDetector) check(m *Machine) error {
+	hash := stateHash(m)
+	if d.savedAt >= 0 {
+		if m.PC < d.low {
+			d.low = m.PC
+		}
+		if m.PC > d.high {
+			d.high = m.PC
+		}
+		if hash == d.saved {
+			return &LoopError{Cycle: d.savedAt, Period: m.Cycle - d.savedAt, Low: d.low, High: d.high}
+		}
+		if m.Cycle-d.savedAt < d.power {
+			return nil
+		}
+		d.power *= 2
+	}
+	d.saved, d.savedAt, d.low, d.high = hash, m.Cycle, m.PC, m.PC
+	return nil
+}
+
+// stateHasher is implemented by devices whose state can change what the
+// program does without it reading them
+type stateHasher interface {
+	stateHash() uint64
+}
+
+// stateHash returns a hash of everything that decides what the machine does
+// next, except for device reads and input which reset the detector
+func stateHash(m *Machine) uint64 {
+	h := mix(uint64(m.PC))
+	for _, r := range m.Registers {
+		h = mix(h ^ uint64(r))
+	}
+	flags := uint64(0)
+	for i, set := range []bool{m.Flags.N, m.Flags.Z, m.Flags.C, m.Flags.V, m.Masked} {
+		if set {
+			flags |= 1 << i
+		}
+	}
+	h = mix(h ^ flags)
+	for _, v := range []int{m.ELR, m.ESR, m.FAR, m.VBAR, len(m.CallStack)} {
+		h = mix(h ^ uint64(v))
+	}
+	h = mix(h ^ m.Memory.hash)
+	for _, mp := range m.Memory.devices {
+		if s, ok := mp.device.(stateHasher); ok {
+			h = mix(h ^ s.stateHash())
+		}
+	}
+	return h
+}
+
+// stateHash only counts the timer while its interrupt is armed, until then
+// the count can't change anything without being read
+func (t *Timer) stateHash() uint64 {
+	if t.Compare == 0 {
+		return 0
+	}
+	return mix(t.Count ^ mix(t.Compare))
+}
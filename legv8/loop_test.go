@@ -0,0 +1,77 @@
+package legv8
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestCycleLimit(t *testing.T) {
+	m := NewMachine()
+	m.Load(assemble(t, "loop: NOP\nB loop\nBREAK"))
+	m.MaxCycles = 50
+	err := m.Run(nil)
+	var limit *CycleLimitError
+	if !errors.As(err, &limit) || limit.Limit != 50 || m.Cycle != 50 {
+		t.Errorf("error %v after %d cycles, want the limit of 50 to stop the program", err, m.Cycle)
+	}
+}
+
+func TestLoopDetected(t *testing.T) {
+	m := NewMachine()
+	m.Load(assemble(t, `
+	ADDI X1, XZR, #3
+loop:	SUBI X2, X1, #3
+	B    loop
+	BREAK`))
+	m.Loops = NewLoopDetector()
+	m.MaxCycles = 100
+	err := m.Run(nil)
+	var loop *LoopError
+	if !errors.As(err, &loop) {
+		t.Fatalf("error is %v, want a LoopError", err)
+	}
+	if loop.Period != 2 || loop.High-loop.Low != 4 || m.Cycle > 10 {
+		t.Errorf("found %+v after %d cycles, want a 2 cycle loop over two instructions found within 10", *loop, m.Cycle)
+	}
+}
+
+func TestLoopNotDetected(t *testing.T) {
+	for _, test := range []struct {
+		name, source, input, err string
+	}{
+		// every pass changes X1
+		{"counting", `
+	ADDI  X1, XZR, #40
+loop:	SUBIS X1, X1, #1
+	B.NE  loop
+	BREAK`, "", ""},
+		// every read can end the loop
+		{"input", `
+loop:	SVC  #3
+	ADDI X0, XZR, #0
+	B    loop
+	BREAK`, "1 2 3 4 5 6 7 8 9 10", "EOF"},
+		// the armed timer changes what happens next
+		{"timer", `
+	MOVZ X9, 0xFF10
+	ADDI X1, XZR, #255
+	STUR X1, [X9, #2]   // timer compare
+loop:	NOP
+	B    loop
+	BREAK`, "", "stopped at the limit"},
+	} {
+		m := NewMachine()
+		if err := m.MapDefaultDevices(); err != nil {
+			t.Fatal(err)
+		}
+		m.Load(assemble(t, test.source))
+		m.Input = strings.NewReader(test.input)
+		m.Loops = NewLoopDetector()
+		m.MaxCycles = 100
+		err := m.Run(nil)
+		if test.err == "" && err != nil || test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("%s: error is %v, want %q", test.name, err, test.err)
+		}
+	}
+}
@@ -0,0 +1,334 @@
+// Package legv8 disassembles and simulates LEGv8 programs stored as lines of
+// 32 character binary strings.
+package legv8
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// ErrHalted is returned by Step once the machine has retired BREAK.
+var ErrHalted = errors.New("legv8: machine is halted")
+
+// Machine holds the architectural state of one simulated program. Several
+// machines can run side by side since nothing is kept in package variables.
+type Machine struct {
+	Registers [32]int64    // X0 - X31, X31 is XZR and always reads as zero
+	Flags     Flags        // NZCV condition flags
+	Memory    *Memory      // byte addressable data memory
+	PC        int          // address of the next instruction to run
+	Cycle     int          // one per retired instruction, plus the misprediction penalties of Branches
+	Halted    bool         // set once BREAK or the exit syscall retires
+	ExitCode  int          // set by the exit syscall
+	CallStack []Frame      // shadow call stack, one frame per BL that has not returned
+	Caches    *CacheSystem // caches fetches and data accesses go through, nil for none
+	Branches  *BranchUnit  // predicts every branch, nil for none
+	Output    io.Writer    // where the print syscalls write, nil throws the output away
+	Input     io.Reader    // where the read syscall reads, nil for none
+
+	// exception state, see exceptions.go
+	ELR       int        // exception link register
+	ESR       int        // syndrome register
+	FAR       int        // fault address register
+	VBAR      int        // vector base, 0 means faults stop the machine instead
+	SPSR      Flags      // flags saved on exception entry
+	Masked    bool       // interrupts are masked, set on exception entry and cleared by ERET
+	Exception *Exception // exception taken by the last Step, nil if there was none
+
+	History *History        // undo log for running backwards, nil when not recording
+	Context context.Context // Step fails once it is done, checked every 1024 cycles, nil for never
+	Retire  Tracer          // called after every instruction retires, by Step or by the commit of the Tomasulo model, nil for none
+	Trace   TraceWriter     // gets a record of every instruction that retires, like Retire, nil for none
+
+	MaxCycles int           // Step fails with a CycleLimitError once this many have retired, 0 for no limit
+	Loops     *LoopDetector // Step fails with a LoopError when the machine state repeats, nil for no detection
+
+	Program []Instruction // decoded program the machine was loaded with
+	image   *Memory       // memory as it was at load time, used by Reset
+	initial *State        // state Reset starts from, nil for all zeros
+	input   *bufio.Reader // buffers Input between reads
+	nextPC  int           // address Step moves PC to once the instruction retires
+	fault   error         // set by an instruction that can't complete
+	record  *TraceRecord  // what the instruction in Step has done so far, only kept for Trace
+}
+
+// Tracer is called by Run after every retired instruction.
+type Tracer func(m *Machine, inst Instruction)
+
+// NewMachine returns an empty machine, use Load to give it a program.
+func NewMachine() *Machine {
+	m := &Machine{image: NewMemory(DefaultMemorySize)}
+	m.Reset()
+	return m
+}
+
+// Load gives the machine a decoded program. Every line after BREAK is a 32
+// bit data word and is copied into memory at its own address.
+func (m *Machine) Load(program []Instruction) {
+	m.Program = program
+	devices := m.image.devices
+	m.image = NewMemory(m.image.Size)
+	m.image.devices = devices
+
+	i := 0
+	for i < len(program) && program[i].TypeOfInstruction != "BREAK" {
+		i++
+	}
+	for i = i + 1; i < len(program); i++ {
+		lineValue, _ := strconv.ParseUint(program[i].RawInstruction, 2, 32)
+		_ = m.image.Store(program[i].ProgramCnt, 4, lineValue)
+	}
+	m.Reset()
+}
+
+// Reset puts the machine back into the state it had right after Load, or
+// into the state given to SetInitialState.
+func (m *Machine) Reset() {
+	m.Registers = [32]int64{}
+	m.Flags = Flags{}
+	m.CallStack = nil
+	m.Memory = m.image.Clone()
+	m.PC = BaseAddress
+	m.Cycle = 0
+	m.Halted = false
+	m.ExitCode = 0
+	if m.Caches != nil {
+		m.Caches.reset()
+	}
+	m.ELR, m.ESR, m.FAR, m.VBAR, m.SPSR, m.Masked, m.Exception = 0, 0, 0, 0, Flags{}, false, nil
+	for _, mp := range m.Memory.devices {
+		if r, ok := mp.device.(interface{ Reset() }); ok {
+			r.Reset()
+		}
+	}
+	_ = m.apply(m.initial) // checked by SetInitialState
+	if m.Loops != nil {
+		m.Loops.reset()
+	}
+}
+
+// Fetch returns the instruction stored at the given address.
+func (m *Machine) Fetch(pc int) (*Instruction, error) {
+	index := (pc - BaseAddress) / 4
+	if pc < BaseAddress || (pc-BaseAddress)%4 != 0 || index >= len(m.Program) {
+		return nil, fmt.Errorf("legv8: pc %d is outside the program", pc)
+	}
+	return &m.Program[index], nil
+}
+
+// Step runs the instruction at PC and returns it with its Cycle filled in.
+// With a vector base set, a pending interrupt is taken first and a fault
+// takes an exception instead of being returned, Exception says which.
+func (m *Machine) Step() (Instruction, error) {
+	if m.Halted {
+		return Instruction{}, ErrHalted
+	}
+	if m.Context != nil && m.Cycle%1024 == 0 {
+		if err := m.Context.Err(); err != nil {
+			return Instruction{}, fmt.Errorf("legv8: stopped after %d cycles: %w", m.Cycle, err)
+		}
+	}
+	if m.MaxCycles > 0 && m.Cycle >= m.MaxCycles {
+		return Instruction{}, &CycleLimitError{m.MaxCycles}
+	}
+	if m.Loops != nil {
+		if err := m.Loops.check(m); err != nil {
+			return Instruction{}, err
+		}
+	}
+	m.Exception = nil
+	if m.History != nil {
+		m.History.begin(m)
+	}
+	if m.Trace != nil {
+		m.record = &TraceRecord{}
+	}
+	if m.interrupting() {
+		m.enter(Exception{Class: ExcInterrupt, PC: m.PC})
+	}
+	inst, err := m.Fetch(m.PC)
+	if err != nil {
+		m.record = nil
+		return Instruction{}, err
+	}
+	if m.Caches != nil {
+		m.Caches.fetch(m.PC)
+	}
+
+	m.nextPC = m.PC + 4
+	if enc := Lookup(inst.Op); enc != nil {
+		enc.exec(m, inst)
+	} else if m.VBAR != 0 {
+		// without a vector base an undefined line retires as a no-op, as
+		// it always has
+		m.fault = &UndefinedInstruction{m.PC, inst.LineValue}
+	}
+	if m.fault != nil {
+		err := m.fault
+		m.fault = nil
+		exc, ok := m.exception(err)
+		if !ok || m.VBAR == 0 {
+			if m.History != nil {
+				m.History.abort()
+			}
+			m.record = nil
+			return Instruction{}, err
+		}
+		// the instruction does not retire, taking the exception uses its cycle.
+		// It is not a branch either, so nothing the last branch left in the
+		// branch unit applies to it.
+		m.enter(exc)
+		if m.Branches != nil {
+			m.Branches.Mispredicted, m.Branches.Fetched = false, m.PC
+		}
+		m.Cycle++
+		inst.Cycle = m.Cycle
+		m.tick()
+		if m.History != nil {
+			m.History.end(m)
+		}
+		if m.Trace != nil {
+			m.traceRetired(*inst, true)
+		}
+		if m.Retire != nil {
+			m.Retire(m, *inst)
+		}
+		return *inst, nil
+	}
+
+	if m.Branches != nil && inst.IsBranch() {
+		taken := inst.TypeOfInstruction != "CB" || m.nextPC != m.PC+4
+		m.Branches.record(*inst, taken, m.nextPC)
+		if m.Branches.Mispredicted {
+			m.Cycle += m.Branches.Config.Penalty
+		}
+	}
+
+	m.Cycle++
+	inst.Cycle = m.Cycle
+	m.tick()
+	m.PC = m.nextPC
+	if m.History != nil {
+		m.History.end(m)
+	}
+	if m.Trace != nil {
+		m.traceRetired(*inst, false)
+	}
+	if m.Retire != nil {
+		m.Retire(m, *inst)
+	}
+	return *inst, nil
+}
+
+// reg returns the value of register r
+func (m *Machine) reg(r uint8) int64 {
+	if r == XZR {
+		return 0
+	}
+	return m.Registers[r]
+}
+
+// setReg writes value to register r, writes to XZR are thrown away. Nothing
+// is written once the instruction has faulted.
+func (m *Machine) setReg(r uint8, value int64) {
+	if r == XZR || m.fault != nil {
+		return
+	}
+	if m.History != nil {
+		m.History.write(Write{Reg: int(r), Old: m.Registers[r], New: value})
+	}
+	if m.record != nil {
+		m.record.Registers = append(m.record.Registers, RegisterWrite{fmt.Sprintf("X%d", r), value})
+	}
+	m.Registers[r] = value
+}
+
+// setFlags writes the NZCV flags
+func (m *Machine) setFlags(flags Flags) {
+	m.Flags = flags
+}
+
+// branch makes the next instruction the one offset instructions away from
+// the current one
+func (m *Machine) branch(offset int32) {
+	m.nextPC = m.PC + 4*int(offset)
+}
+
+// address returns the byte address a D format instruction accesses
+func (m *Machine) address(inst *Instruction) int {
+	return int(m.reg(inst.Rn)) + int(inst.Address)
+}
+
+// wordAddress returns the address STUR and LDUR access, their offset counts
+// words
+func (m *Machine) wordAddress(inst *Instruction) int {
+	return int(m.reg(inst.Rn)) + int(inst.Address)*4
+}
+
+// dataAddress returns the address a load or store accesses
+func (m *Machine) dataAddress(inst *Instruction) int {
+	if inst.Op == "STUR" || inst.Op == "LDUR" {
+		return m.wordAddress(inst)
+	}
+	return m.address(inst)
+}
+
+// load reads size bytes of memory, a failed access faults the instruction.
+// Devices are not cached.
+func (m *Machine) load(addr int, size int) uint64 {
+	value, err := m.Memory.Load(addr, size)
+	if err != nil {
+		m.fault = err
+	} else if m.record != nil {
+		m.record.Reads = append(m.record.Reads, MemoryAccess{addr, size, int64(value)})
+	}
+	if err == nil && m.Caches != nil && !m.Memory.IsDevice(addr) {
+		m.Caches.data(addr, false)
+	}
+	if m.Loops != nil && m.Memory.IsDevice(addr) {
+		m.Loops.reset() // the device can change what happens next
+	}
+	return value
+}
+
+// store writes size bytes of memory, a failed access faults the instruction
+func (m *Machine) store(addr int, size int, value int64) {
+	if m.History != nil && m.Memory.check(addr, size) == nil && !m.Memory.IsDevice(addr) {
+		old, _ := m.Memory.Load(addr, size)
+		m.History.write(Write{Reg: -1, Addr: addr, Size: size, Old: int64(old), New: value, prior: m.Memory.save(addr, size)})
+	}
+	if err := m.Memory.Store(addr, size, uint64(value)); err != nil {
+		m.fault = err
+		return
+	}
+	if m.Caches != nil && !m.Memory.IsDevice(addr) {
+		m.Caches.data(addr, true)
+	}
+	if m.record != nil {
+		m.record.Writes = append(m.record.Writes, MemoryAccess{addr, size, value})
+	}
+}
+
+// jump makes the next instruction the one at address target
+func (m *Machine) jump(target int) {
+	m.nextPC = target
+}
+
+// Run steps the machine until BREAK retires, calling trace after each cycle.
+// trace may be nil.
+func (m *Machine) Run(trace Tracer) error {
+	for !m.Halted {
+		inst, err := m.Step()
+		if err != nil {
+			return err
+		}
+		if trace != nil {
+			trace(m, inst)
+		}
+	}
+	return nil
+}
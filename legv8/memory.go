@@ -0,0 +1,171 @@
+package legv8
+
+import (
+	"fmt"
+	"sort"
+)
+
+// DefaultMemorySize is the number of bytes a new machine can address.
+const DefaultMemorySize = 1 << 16
+
+// Memory is byte addressable little endian memory. Only bytes that have been
+// written are kept, everything else reads as zero. Address ranges can be
+// mapped to devices with Map.
+type Memory struct {
+	Size    int // addresses run from 0 to Size-1
+	bytes   map[int]byte
+	devices []mapping
+	hash    uint64 // hash of the contents, kept up to date by set so memories compare cheaply
+}
+
+// MemoryFault is the error returned for an access that is misaligned or
+// falls outside of memory.
+type MemoryFault struct {
+	Addr   int
+	Size   int    // access size in bytes
+	Reason string // "misaligned" or "out of range"
+}
+
+func (e *MemoryFault) Error() string {
+	return fmt.Sprintf("legv8: %s %d byte access at address %d", e.Reason, e.Size, e.Addr)
+}
+
+// NewMemory returns size bytes of zeroed memory.
+func NewMemory(size int) *Memory {
+	return &Memory{Size: size, bytes: make(map[int]byte)}
+}
+
+// check returns the fault for an access of size bytes at addr, if any.
+// Accesses have to be aligned to their own size.
+func (mem *Memory) check(addr int, size int) error {
+	if addr < 0 || addr+size > mem.Size {
+		return &MemoryFault{addr, size, "out of range"}
+	}
+	if addr%size != 0 {
+		return &MemoryFault{addr, size, "misaligned"}
+	}
+	return nil
+}
+
+// Load reads size (1, 2, 4 or 8) bytes at addr, zero extended.
+func (mem *Memory) Load(addr int, size int) (uint64, error) {
+	if err := mem.check(addr, size); err != nil {
+		return 0, err
+	}
+	if mp, ok := mem.device(addr, size); ok {
+		return mp.device.Read(addr-mp.base, size)
+	}
+	var value uint64
+	for i := size - 1; i >= 0; i-- {
+		value = value<<8 | uint64(mem.bytes[addr+i])
+	}
+	return value, nil
+}
+
+// Store writes the low size (1, 2, 4 or 8) bytes of value at addr.
+func (mem *Memory) Store(addr int, size int, value uint64) error {
+	if err := mem.check(addr, size); err != nil {
+		return err
+	}
+	if mp, ok := mem.device(addr, size); ok {
+		return mp.device.Write(addr-mp.base, size, value)
+	}
+	for i := 0; i < size; i++ {
+		mem.set(addr+i, byte(value>>(8*i)))
+	}
+	return nil
+}
+
+// set writes one byte and updates the hash of the contents. A byte's share
+// of the hash is 0 when it is 0, so unwritten and zeroed memory hash the same.
+func (mem *Memory) set(addr int, b byte) {
+	mem.hash ^= byteHash(addr, mem.bytes[addr]) ^ byteHash(addr, b)
+	mem.bytes[addr] = b
+}
+
+func byteHash(addr int, b byte) uint64 {
+	if b == 0 {
+		return 0
+	}
+	return mix(uint64(addr)<<8 | uint64(b))
+}
+
+// mix scrambles the bits of x, it is the splitmix64 finalizer
+func mix(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	return x ^ x>>31
+}
+
+// Word returns the signed 32 bit word at addr without any checks, it is
+// used to print memory.
+func (mem *Memory) Word(addr int) int32 {
+	var value uint32
+	for i := 3; i >= 0; i-- {
+		value = value<<8 | uint32(mem.bytes[addr+i])
+	}
+	return int32(value)
+}
+
+// touch marks the word at addr as written without changing its value
+func (mem *Memory) touch(addr int) {
+	for i := addr; i < addr+4; i++ {
+		mem.bytes[i] = mem.bytes[i]
+	}
+}
+
+// Words returns the sorted addresses of every 4 byte word that has had at
+// least one of its bytes written.
+func (mem *Memory) Words() []int {
+	seen := make(map[int]bool)
+	var words []int
+	for addr := range mem.bytes {
+		word := addr &^ 3
+		if !seen[word] {
+			seen[word] = true
+			words = append(words, word)
+		}
+	}
+	sort.Ints(words)
+	return words
+}
+
+// Clone returns a copy of the memory, mapped to the same devices.
+func (mem *Memory) Clone() *Memory {
+	clone := NewMemory(mem.Size)
+	clone.devices = append(clone.devices, mem.devices...)
+	for addr, b := range mem.bytes {
+		clone.bytes[addr] = b
+	}
+	clone.hash = mem.hash
+	return clone
+}
+
+// memByte is one byte of memory and whether it had ever been written
+type memByte struct {
+	value byte
+	set   bool
+}
+
+// save returns the bytes an access of size bytes at addr covers
+func (mem *Memory) save(addr int, size int) []memByte {
+	saved := make([]memByte, size)
+	for i := range saved {
+		saved[i].value, saved[i].set = mem.bytes[addr+i]
+	}
+	return saved
+}
+
+// restore puts back bytes returned by save
+func (mem *Memory) restore(addr int, saved []memByte) {
+	for i, b := range saved {
+		if b.set {
+			mem.set(addr+i, b.value)
+		} else {
+			mem.set(addr+i, 0)
+			delete(mem.bytes, addr+i)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package legv8
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestMemoryLoadStore(t *testing.T) {
+	mem := NewMemory(64)
+	if err := mem.Store(8, 4, 0x11223344); err != nil {
+		t.Fatal(err)
+	}
+	if err := mem.Store(13, 1, 0x1ff); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		addr, size int
+		want       uint64
+	}{
+		{8, 1, 0x44}, // little endian
+		{9, 1, 0x33},
+		{10, 2, 0x1122},
+		{8, 8, 0x0000ff0011223344},
+		{12, 2, 0xff00},
+		{16, 4, 0}, // never written
+	}
+	for _, test := range tests {
+		got, err := mem.Load(test.addr, test.size)
+		if err != nil || got != test.want {
+			t.Errorf("Load(%d, %d) is %#x, %v, want %#x", test.addr, test.size, got, err, test.want)
+		}
+	}
+	if got := mem.Words(); !equalInts(got, []int{8, 12}) {
+		t.Errorf("written words are %v, want [8 12]", got)
+	}
+	if got := mem.Word(8); got != 0x11223344 {
+		t.Errorf("word at 8 is %#x, want 0x11223344", got)
+	}
+}
+
+func TestMemoryFaults(t *testing.T) {
+	mem := NewMemory(64)
+	tests := []struct {
+		addr, size int
+		reason     string
+	}{
+		{2, 4, "misaligned"},
+		{7, 2, "misaligned"},
+		{64, 1, "out of range"},
+		{60, 8, "out of range"},
+		{-4, 4, "out of range"},
+	}
+	for _, test := range tests {
+		err := mem.Store(test.addr, test.size, 1)
+		var fault *MemoryFault
+		if !errors.As(err, &fault) || fault.Reason != test.reason {
+			t.Errorf("Store(%d, %d) returns %v, want a %s fault", test.addr, test.size, err, test.reason)
+		}
+	}
+	if words := mem.Words(); words != nil {
+		t.Errorf("failed stores wrote %v", words)
+	}
+}
+
+// TestLoadDataSection loads the data word after BREAK with a word LDUR and
+// with byte and half word loads.
+func TestLoadDataSection(t *testing.T) {
+	m := loadLines(t,
+		"11111000010000011101001111100001", // LDUR R1, [R31, #29]
+		"00111000100001110100001111100010", // LDURSB R2, [R31, #116]
+		"00111000010001110100001111100011", // LDURB R3, [R31, #116]
+		"01111000010001110100001111100100", // LDURH R4, [R31, #116]
+		breakWord,
+		"11111111111111111111111111111110", // -2 at 116
+	)
+	if err := m.Run(nil); err != nil {
+		t.Fatal(err)
+	}
+	want := []int64{-2, -2, 254, 65534}
+	for i, w := range want {
+		if got := m.Registers[i+1]; got != w {
+			t.Errorf("X%d is %d, want %d", i+1, got, w)
+		}
+	}
+
+	m = loadLines(t, "01111000010001110101001111100100", breakWord) // LDURH R4, [R31, #117]
+	_, err := m.Step()
+	var fault *MemoryFault
+	if !errors.As(err, &fault) || fault.Addr != 117 || m.PC != BaseAddress {
+		t.Errorf("misaligned LDURH returns %v with the PC at %d", err, m.PC)
+	}
+}
@@ -0,0 +1,280 @@
+package legv8
+
+import (
+	"context"
+	"io"
+	"reflect"
+	"testing"
+)
+
+// models are the four execution models with the settings cosim uses
+var models = []Model{
+	{"single", func(m *Machine, w io.Writer) error { return SimInstructions(m, w, Full) }},
+	{"pipeline", func(m *Machine, w io.Writer) error {
+		return NewPipeline(m, PipelineConfig{Forwarding: true}).Run(w)
+	}},
+	{"ooo", func(m *Machine, w io.Writer) error { return NewTomasulo(m, DefaultTomasuloConfig).Run(w) }},
+	{"superscalar", func(m *Machine, w io.Writer) error {
+		return NewSuperscalar(m, SuperscalarConfig{Width: 2, MemoryPorts: 1, LoadLatency: 2}).Run(w)
+	}},
+}
+
+// programs run to BREAK on every model
+var programs = []struct {
+	name   string
+	source string
+}{
+	{"sum", `
+	ADDI X1, XZR, #10
+	ADDI X2, XZR, #200
+loop:	ADD  X3, X3, X1
+	STUR X3, [X2, #0]
+	LDUR X4, [X2, #0]
+	ADDI X2, X2, #8
+	SUBIS X1, X1, #1
+	B.NE loop
+	BREAK`},
+	{"call", `
+	ADDI SP, XZR, #248
+	ADDI X0, XZR, #5
+	BL   double
+	BL   double
+	ADDI X5, X0, #0
+	B    done
+double:	SUBI SP, SP, #8
+	STUR LR, [SP, #0]
+	ADD  X0, X0, X0
+	LDUR LR, [SP, #0]
+	ADDI SP, SP, #8
+	BR   LR
+done:	BREAK`},
+	{"flags", `
+	MOVZ X1, 0x8000, LSL 48
+	SUBI X2, X1, #1
+	ADDS X3, X2, X2
+	SUBS X4, X1, X2
+	B.VS overflow
+	ADDI X9, XZR, #1
+overflow:
+	CMP  X1, X2
+	B.LT less
+	ADDI X10, XZR, #1
+less:	CMPI X1, #0
+	B.LO below
+	ADDI X11, XZR, #1
+below:	ANDS X12, X1, X2
+	B.EQ zero
+	ADDI X13, XZR, #1
+zero:	EOR  X14, X1, X2
+	BREAK`},
+	{"data", `
+	ADDI X1, XZR, data
+	LDURSW X2, [X1, #0]
+	LDURSW X3, [X1, #4]
+	LDURW  X4, [X1, #4]
+	ADD  X5, X2, X3
+	STURB X5, [X1, #9]
+	STURH X3, [X1, #12]
+	LDURSB X6, [X1, #9]
+	LDURSH X7, [X1, #12]
+	LSL  X8, X3, #2       // shifts by X2
+	LSR  X9, X3, #2
+	ASR  X10, X2, #6      // by X6
+	MOVK X11, 0x1234, LSL 16
+	BREAK
+data:	.word 7, -3, 0, 0`},
+}
+
+// newModelMachine loads a copy of the program into a machine with the
+// default devices, the way the main program does
+func newModelMachine(program []Instruction) (*Machine, error) {
+	m := NewMachine()
+	if err := m.MapDefaultDevices(); err != nil {
+		return nil, err
+	}
+	m.Load(append([]Instruction(nil), program...))
+	return m, nil
+}
+
+func TestModelsAgree(t *testing.T) {
+	for _, prog := range programs {
+		t.Run(prog.name, func(t *testing.T) {
+			program := assemble(t, prog.source)
+			var want *Machine
+			for _, model := range models {
+				m, err := newModelMachine(program)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := model.Run(m, io.Discard); err != nil {
+					t.Fatalf("%s: %v", model.Name, err)
+				}
+				if want == nil {
+					want = m
+					continue
+				}
+				for r := range m.Registers {
+					if m.Registers[r] != want.Registers[r] {
+						t.Errorf("%s: X%d is %d, single has %d", model.Name, r, m.Registers[r], want.Registers[r])
+					}
+				}
+				if m.Flags != want.Flags {
+					t.Errorf("%s: flags are %v, single has %v", model.Name, m.Flags, want.Flags)
+				}
+				got, wantWords := memoryWords(m), memoryWords(want)
+				for addr, word := range wantWords {
+					if got[addr] != word {
+						t.Errorf("%s: memory %d is %d, single has %d", model.Name, addr, got[addr], word)
+					}
+				}
+				for addr, word := range got {
+					if _, ok := wantWords[addr]; !ok {
+						t.Errorf("%s: memory %d is %d, single has 0", model.Name, addr, word)
+					}
+				}
+			}
+		})
+	}
+}
+
+// TestModelResults checks what the single cycle model leaves behind, the
+// other models are held to it by TestModelsAgree
+func TestModelResults(t *testing.T) {
+	tests := []struct {
+		program string
+		reg     int
+		want    int64
+	}{
+		{"sum", 3, 55},
+		{"sum", 4, 55},
+		{"sum", 2, 280},
+		{"call", 5, 20},
+		{"call", 28, 248},
+		{"flags", 3, -2},
+		{"flags", 4, 1},
+		{"flags", 9, 0},  // SUBI made MaxInt64, doubling it overflows
+		{"flags", 10, 0}, // MinInt64 < MaxInt64
+		{"flags", 11, 1}, // MinInt64 is not below 0 unsigned
+		{"flags", 13, 0},
+		{"flags", 14, -1},
+		{"data", 2, 7},
+		{"data", 3, -3},
+		{"data", 4, 0xFFFFFFFD},
+		{"data", 5, 4},
+		{"data", 6, 4},
+		{"data", 7, -3},
+		{"data", 8, -384}, // -3 << 7
+		{"data", 9, -1},   // LSR is arithmetic
+		{"data", 10, 0},   // 7 >> 4
+		{"data", 11, 0x12340000},
+	}
+	for _, test := range tests {
+		var source string
+		for _, prog := range programs {
+			if prog.name == test.program {
+				source = prog.source
+			}
+		}
+		m, err := newModelMachine(assemble(t, source))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := SimInstructions(m, io.Discard, Full); err != nil {
+			t.Fatalf("%s: %v", test.program, err)
+		}
+		if got := m.Registers[test.reg]; got != test.want {
+			t.Errorf("%s: X%d is %d, want %d", test.program, test.reg, got, test.want)
+		}
+	}
+}
+
+func TestCoSimulate(t *testing.T) {
+	for _, prog := range programs {
+		program := assemble(t, prog.source)
+		for _, model := range models[1:] {
+			report, err := CoSimulate(context.Background(), models[0], model, func() (*Machine, error) {
+				return newModelMachine(program)
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(report.Mismatches) > 0 || report.ErrA != nil || report.ErrB != nil {
+				t.Errorf("%s: single and %s disagree after %d retirements: %v", prog.name, model.Name, report.Retired, report.Mismatches)
+			}
+		}
+	}
+}
+
+func TestCoSimulateMemoryMismatch(t *testing.T) {
+	program := assemble(t, programs[0].source)
+	// the same model, but one that stores 9 at 400 before it starts
+	poked := Model{"poked", func(m *Machine, w io.Writer) error {
+		m.store(400, 4, 9)
+		return SimInstructions(m, w, Full)
+	}}
+	report, err := CoSimulate(context.Background(), models[0], poked, func() (*Machine, error) {
+		return newModelMachine(program)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Retired != 0 || len(report.Mismatches) != 1 {
+		t.Fatalf("after %d retirements the mismatches are %v, want only memory 400 on the first", report.Retired, report.Mismatches)
+	}
+	if mm := report.Mismatches[0]; mm != (Mismatch{"memory 400", "0", "9"}) {
+		t.Errorf("mismatch is %v, want memory 400: 0, 9", mm)
+	}
+}
+
+// TestTomasuloRetiresAtCommit checks the out of order model hands Retire
+// the committed state, in program order, while its machine has already
+// issued further
+func TestTomasuloRetiresAtCommit(t *testing.T) {
+	program := assemble(t, programs[0].source)
+	type retirement struct {
+		PC        int
+		Registers [32]int64
+	}
+	record := func(m *Machine, run func(m *Machine) error) (got []retirement, ahead int) {
+		m.Retire = func(a *Machine, inst Instruction) {
+			got = append(got, retirement{a.PC, a.Registers})
+			if m.Registers != a.Registers {
+				ahead++
+			}
+		}
+		if err := run(m); err != nil {
+			t.Fatal(err)
+		}
+		return got, ahead
+	}
+	single, _ := record(NewMachine(), func(m *Machine) error {
+		m.Load(program)
+		return m.Run(nil)
+	})
+	ooo, ahead := record(NewMachine(), func(m *Machine) error {
+		m.Load(program)
+		return NewTomasulo(m, DefaultTomasuloConfig).Run(io.Discard)
+	})
+	if !reflect.DeepEqual(ooo, single) {
+		t.Errorf("the out of order retirements differ from the single cycle ones:\n%v\n%v", ooo, single)
+	}
+	if ahead == 0 {
+		t.Error("the issued state never ran ahead of the committed state")
+	}
+
+	// a store made before the run only shows in the committed state if
+	// commit starts from the machine's memory
+	poked := Model{"poked ooo", func(m *Machine, w io.Writer) error {
+		m.store(400, 4, 9)
+		return NewTomasulo(m, DefaultTomasuloConfig).Run(w)
+	}}
+	report, err := CoSimulate(context.Background(), models[0], poked, func() (*Machine, error) {
+		return newModelMachine(program)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Retired != 0 || len(report.Mismatches) != 1 || report.Mismatches[0] != (Mismatch{"memory 400", "0", "9"}) {
+		t.Errorf("after %d retirements the mismatches are %v, want memory 400 on the first", report.Retired, report.Mismatches)
+	}
+}
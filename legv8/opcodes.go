@@ -0,0 +1,447 @@
+package legv8
+
+import (
+	"strconv"
+	"strings"
+)
+
+// field is one bit range of an encoded instruction, hi and lo are inclusive
+// bit numbers with bit 31 being the leftmost character of the binary string.
+type field struct {
+	name   string // name used in operand syntax, Rd, Rn, Im, etc..
+	hi, lo int
+	signed bool // sign extend the value (two's complement)
+}
+
+// formats holds the fields below the opcode for each instruction format. The
+// order is the order the fields appear in the binary string.
+var formats = map[string][]field{
+	// | opcode | Rm | Shamt | Rn | Rd |
+	"R": {{"Rm", 20, 16, false}, {"Shamt", 15, 11, false}, {"Rn", 9, 5, false}, {"Rd", 4, 0, false}},
+	// | opcode | address | op2 | Rn | Rt |
+	"D": {{"Address", 20, 12, false}, {"Op2", 11, 10, false}, {"Rn", 9, 5, false}, {"Rt", 4, 0, false}},
+	// | opcode | immediate | Rn | Rd |
+	"I": {{"Im", 21, 10, false}, {"Rn", 9, 5, false}, {"Rd", 4, 0, false}},
+	// | opcode | offset |
+	"B": {{"Offset", 25, 0, true}},
+	// | opcode | offset | Rt |
+	"CB": {{"Offset", 23, 5, true}, {"Rt", 4, 0, false}},
+	// | opcode | shift | field | Rd |
+	"IM": {{"Shamt", 22, 21, false}, {"Field", 20, 5, false}, {"Rd", 4, 0, false}},
+	// | opcode | system register | Rt |
+	"SYS": {{"Field", 19, 5, false}, {"Rt", 4, 0, false}},
+	// | opcode | immediate | 00001 |
+	"EXC":   {{"Field", 20, 5, false}, {"Op2", 4, 0, false}},
+	"N/A":   {},
+	"BREAK": {},
+}
+
+// Encoding is one entry of the instruction table.
+type Encoding struct {
+	Mnemonic string // ADD, SUB, LSL, etc...
+	Format   string // instruction type "R", "I", etc..
+	Opcode   uint64 // value of the leftmost Width bits
+	Width    int    // number of opcode bits
+	Syntax   string // operands as they are written in assembly, using the field names
+	exec     func(m *Machine, inst *Instruction)
+}
+
+// encodings is the instruction table. Adding an instruction means adding an
+// entry here, decoding, disassembly and simulation all work off of it.
+var encodings = []Encoding{
+	// R format instructions
+	{"AND", "R", 0b10001010000, 11, "Rd, Rm, Rn", func(m *Machine, inst *Instruction) {
+		m.setReg(inst.Rd, m.reg(inst.Rn)&m.reg(inst.Rm))
+	}},
+	{"ADD", "R", 0b10001011000, 11, "Rd, Rm, Rn", func(m *Machine, inst *Instruction) {
+		m.setReg(inst.Rd, m.reg(inst.Rn)+m.reg(inst.Rm))
+	}},
+	{"ORR", "R", 0b10101010000, 11, "Rd, Rm, Rn", func(m *Machine, inst *Instruction) {
+		m.setReg(inst.Rd, m.reg(inst.Rn)|m.reg(inst.Rm))
+	}},
+	{"SUB", "R", 0b11001011000, 11, "Rd, Rm, Rn", func(m *Machine, inst *Instruction) {
+		m.setReg(inst.Rd, m.reg(inst.Rn)-m.reg(inst.Rm))
+	}},
+	{"EOR", "R", 0b11101010000, 11, "Rd, Rm, Rn", func(m *Machine, inst *Instruction) {
+		m.setReg(inst.Rd, m.reg(inst.Rn)^m.reg(inst.Rm))
+	}},
+	{"LSR", "R", 0b11010011010, 11, "Rd, Rm, #Shamt", func(m *Machine, inst *Instruction) {
+		m.setReg(inst.Rd, m.reg(inst.Rn)>>m.reg(inst.Shamt))
+	}},
+	{"LSL", "R", 0b11010011011, 11, "Rd, Rm, #Shamt", func(m *Machine, inst *Instruction) {
+		m.setReg(inst.Rd, m.reg(inst.Rn)<<m.reg(inst.Shamt))
+	}},
+	{"ASR", "R", 0b11010011100, 11, "Rd, Rm, #Shamt", func(m *Machine, inst *Instruction) {
+		m.setReg(inst.Rd, m.reg(inst.Rn)>>m.reg(inst.Shamt))
+	}},
+	// flag setting variants. ANDS is moved off of its green card opcode
+	// 11101010000 since this instruction set already uses that one for EOR
+	{"ADDS", "R", 0b10101011000, 11, "Rd, Rn, Rm", func(m *Machine, inst *Instruction) {
+		result, flags := addFlags(m.reg(inst.Rn), m.reg(inst.Rm))
+		m.setReg(inst.Rd, result)
+		m.setFlags(flags)
+	}},
+	{"SUBS", "R", 0b11101011000, 11, "Rd, Rn, Rm", func(m *Machine, inst *Instruction) {
+		result, flags := subFlags(m.reg(inst.Rn), m.reg(inst.Rm))
+		m.setReg(inst.Rd, result)
+		m.setFlags(flags)
+	}},
+	{"ANDS", "R", 0b11101010001, 11, "Rd, Rn, Rm", func(m *Machine, inst *Instruction) {
+		result := m.reg(inst.Rn) & m.reg(inst.Rm)
+		m.setReg(inst.Rd, result)
+		m.setFlags(logicFlags(result))
+	}},
+
+	// D format instructions. STUR and LDUR move the 4 byte data words the
+	// program is loaded with and count their offset in words, the others
+	// count it in bytes and the suffix gives the access size: W 32, H 16 and
+	// B 8 bits. S loads sign extend.
+	{"STUR", "D", 0b11111000000, 11, "Rt, [Rn, #Address]", func(m *Machine, inst *Instruction) {
+		m.store(m.wordAddress(inst), 4, m.reg(inst.Rt))
+	}},
+	{"LDUR", "D", 0b11111000010, 11, "Rt, [Rn, #Address]", func(m *Machine, inst *Instruction) {
+		m.setReg(inst.Rt, int64(int32(m.load(m.wordAddress(inst), 4))))
+	}},
+	{"STURW", "D", 0b10111000000, 11, "Rt, [Rn, #Address]", func(m *Machine, inst *Instruction) {
+		m.store(m.address(inst), 4, m.reg(inst.Rt))
+	}},
+	{"LDURW", "D", 0b10111000010, 11, "Rt, [Rn, #Address]", func(m *Machine, inst *Instruction) {
+		m.setReg(inst.Rt, int64(m.load(m.address(inst), 4)))
+	}},
+	{"LDURSW", "D", 0b10111000100, 11, "Rt, [Rn, #Address]", func(m *Machine, inst *Instruction) {
+		m.setReg(inst.Rt, int64(int32(m.load(m.address(inst), 4))))
+	}},
+	{"STURH", "D", 0b01111000000, 11, "Rt, [Rn, #Address]", func(m *Machine, inst *Instruction) {
+		m.store(m.address(inst), 2, m.reg(inst.Rt))
+	}},
+	{"LDURH", "D", 0b01111000010, 11, "Rt, [Rn, #Address]", func(m *Machine, inst *Instruction) {
+		m.setReg(inst.Rt, int64(m.load(m.address(inst), 2)))
+	}},
+	{"LDURSH", "D", 0b01111000100, 11, "Rt, [Rn, #Address]", func(m *Machine, inst *Instruction) {
+		m.setReg(inst.Rt, int64(int16(m.load(m.address(inst), 2))))
+	}},
+	{"STURB", "D", 0b00111000000, 11, "Rt, [Rn, #Address]", func(m *Machine, inst *Instruction) {
+		m.store(m.address(inst), 1, m.reg(inst.Rt))
+	}},
+	{"LDURB", "D", 0b00111000010, 11, "Rt, [Rn, #Address]", func(m *Machine, inst *Instruction) {
+		m.setReg(inst.Rt, int64(m.load(m.address(inst), 1)))
+	}},
+	{"LDURSB", "D", 0b00111000100, 11, "Rt, [Rn, #Address]", func(m *Machine, inst *Instruction) {
+		m.setReg(inst.Rt, int64(int8(m.load(m.address(inst), 1))))
+	}},
+
+	// I format instructions
+	{"ADDI", "I", 0b1001000100, 10, "Rd, Rn, #Im", func(m *Machine, inst *Instruction) {
+		m.setReg(inst.Rd, m.reg(inst.Rn)+int64(inst.Im))
+	}},
+	{"SUBI", "I", 0b1101000100, 10, "Rd, Rn, #Im", func(m *Machine, inst *Instruction) {
+		m.setReg(inst.Rd, m.reg(inst.Rn)-int64(inst.Im))
+	}},
+	{"ADDIS", "I", 0b1011000100, 10, "Rd, Rn, #Im", func(m *Machine, inst *Instruction) {
+		result, flags := addFlags(m.reg(inst.Rn), int64(inst.Im))
+		m.setReg(inst.Rd, result)
+		m.setFlags(flags)
+	}},
+	{"SUBIS", "I", 0b1111000100, 10, "Rd, Rn, #Im", func(m *Machine, inst *Instruction) {
+		result, flags := subFlags(m.reg(inst.Rn), int64(inst.Im))
+		m.setReg(inst.Rd, result)
+		m.setFlags(flags)
+	}},
+
+	// B and CB format instructions
+	{"B", "B", 0b000101, 6, " #Offset", func(m *Machine, inst *Instruction) {
+		m.branch(inst.Offset)
+	}},
+	{"BL", "B", 0b100101, 6, "#Offset", func(m *Machine, inst *Instruction) {
+		m.setReg(LR, int64(m.PC+4))
+		m.call(m.PC + 4*int(inst.Offset))
+		m.branch(inst.Offset)
+	}},
+	{"BR", "R", 0b11010110000, 11, "Rn", func(m *Machine, inst *Instruction) {
+		target := int(m.reg(inst.Rn))
+		m.ret(target)
+		m.jump(target)
+	}},
+	{"CBZ", "CB", 0b10110100, 8, "Rt, #Offset", func(m *Machine, inst *Instruction) {
+		if inst.Rt == 0 {
+			m.branch(inst.Offset)
+		}
+	}},
+	{"CBNZ", "CB", 0b10110101, 8, "Rt, #Offset", func(m *Machine, inst *Instruction) {
+		if inst.Rt != 0 {
+			m.branch(inst.Offset)
+		}
+	}},
+
+	// IM format instructions, the shift code picks which 16 bit slice of Rd is written
+	{"MOVZ", "IM", 0b110100101, 9, "Rd, Field, LSL Shift", func(m *Machine, inst *Instruction) {
+		m.setReg(inst.Rd, int64(inst.Field<<(inst.Shamt*16)))
+	}},
+	{"MOVK", "IM", 0b111100101, 9, "Rd, Field, LSL Shift", func(m *Machine, inst *Instruction) {
+		m.setReg(inst.Rd, m.reg(inst.Rd)+int64(inst.Field<<(inst.Shamt*16)))
+	}},
+
+	// supervisor call, the immediate is the syscall number
+	{"SVC", "EXC", 0b11010100000, 11, "#Field", func(m *Machine, inst *Instruction) {
+		m.svc(inst.Field)
+	}},
+
+	// system register moves and the return from an exception handler
+	{"MRS", "SYS", 0b110101010011, 12, "Rt, Sysreg", func(m *Machine, inst *Instruction) {
+		m.setReg(inst.Rt, m.sysreg(inst.Field))
+	}},
+	{"MSR", "SYS", 0b110101010001, 12, "Sysreg, Rt", func(m *Machine, inst *Instruction) {
+		m.setSysreg(inst.Field, m.reg(inst.Rt))
+	}},
+	{"ERET", "N/A", 0b11010110100111110000001111100000, 32, "", func(m *Machine, inst *Instruction) {
+		m.jump(m.ELR)
+		m.setFlags(m.SPSR)
+		m.Masked = false
+	}},
+
+	{"NOP", "N/A", 0, 32, "", func(m *Machine, inst *Instruction) {}},
+	{"BREAK", "BREAK", 0b11111110110, 11, "", func(m *Machine, inst *Instruction) {
+		m.Halted = true
+	}},
+}
+
+// bCondOpcode is the opcode shared by the B.cond instructions, the condition
+// code sits in the Rt field
+const bCondOpcode = 0b01010100
+
+func init() {
+	// one B.cond entry per condition code, B.EQ, B.NE, etc...
+	for i, name := range conditions {
+		cond := uint8(i)
+		encodings = append(encodings, Encoding{"B." + name, "CB", bCondOpcode, 8, "#Offset",
+			func(m *Machine, inst *Instruction) {
+				if m.Flags.holds(cond) {
+					m.branch(inst.Offset)
+				}
+			}})
+	}
+	for i := range encodings {
+		byMnemonic[encodings[i].Mnemonic] = &encodings[i]
+	}
+}
+
+// alias is another name an instruction is written with when one of its
+// registers is XZR, e.g. CMP is SUBS with the result thrown away
+type alias struct {
+	of       string // mnemonic of the real instruction
+	mnemonic string
+	syntax   string
+}
+
+var aliases = []alias{
+	{"SUBS", "CMP", "Rn, Rm"},
+	{"SUBIS", "CMPI", "Rn, #Im"},
+}
+
+// XZR is the register number that always reads as zero.
+const XZR = 31
+
+// byMnemonic indexes the instruction table by mnemonic, it is filled in by init
+var byMnemonic = make(map[string]*Encoding)
+
+// Lookup returns the table entry for a mnemonic, or nil if there is none.
+func Lookup(mnemonic string) *Encoding {
+	return byMnemonic[mnemonic]
+}
+
+// match returns the table entry whose opcode bits match lineValue
+func match(lineValue uint64) *Encoding {
+	for i := range encodings {
+		enc := &encodings[i]
+		if lineValue>>(32-enc.Width) != enc.Opcode {
+			continue
+		}
+		if cond, ok := enc.condition(); ok && uint8(lineValue&0x1F) != cond {
+			continue
+		}
+		return enc
+	}
+	return nil
+}
+
+// condition returns the condition code of a B.cond entry
+func (enc *Encoding) condition() (uint8, bool) {
+	for i, name := range conditions {
+		if enc.Mnemonic == "B."+name {
+			return uint8(i), true
+		}
+	}
+	return 0, false
+}
+
+// decode fills in the Instruction from its line value using the table
+func decode(inst *Instruction, lineValue uint64) {
+	inst.LineValue = lineValue
+
+	enc := match(lineValue)
+	if enc == nil {
+		return
+	}
+	inst.Op = enc.Mnemonic
+	inst.TypeOfInstruction = enc.Format
+	inst.Opcode = lineValue >> (32 - enc.Width)
+
+	for _, f := range formats[enc.Format] {
+		length := f.hi - f.lo + 1
+		value := lineValue >> f.lo & (1<<length - 1)
+		if f.signed {
+			inst.set(f.name, int64(signedVariable(value, length)))
+		} else {
+			inst.set(f.name, int64(value))
+		}
+	}
+	if cond, ok := enc.condition(); ok {
+		inst.Conditional = cond
+	}
+}
+
+// set assigns the decoded value to the Instruction field named by a format
+func (inst *Instruction) set(name string, value int64) {
+	switch name {
+	case "Rd":
+		inst.Rd = uint8(value)
+	case "Rn":
+		inst.Rn = uint8(value)
+	case "Rm":
+		inst.Rm = uint8(value)
+	case "Rt":
+		inst.Rt = uint8(value)
+	case "Shamt":
+		inst.Shamt = uint8(value)
+	case "Op2":
+		inst.Op2 = uint8(value)
+	case "Im":
+		inst.Im = uint8(value)
+	case "Address":
+		inst.Address = uint8(value)
+	case "Offset":
+		inst.Offset = int32(value)
+	case "Field":
+		inst.Field = uint64(value)
+	}
+}
+
+// operand returns the text of one operand name used in a Syntax string
+func (inst Instruction) operand(name string) (string, bool) {
+	switch name {
+	case "Rd":
+		return "R" + strconv.Itoa(int(inst.Rd)), true
+	case "Rn":
+		return "R" + strconv.Itoa(int(inst.Rn)), true
+	case "Rm":
+		return "R" + strconv.Itoa(int(inst.Rm)), true
+	case "Rt":
+		return "R" + strconv.Itoa(int(inst.Rt)), true
+	case "Shamt":
+		return strconv.Itoa(int(inst.Shamt)), true
+	case "Im":
+		return strconv.Itoa(int(inst.Im)), true
+	case "Address":
+		return strconv.Itoa(int(inst.Address)), true
+	case "Offset":
+		return strconv.Itoa(int(inst.Offset)), true
+	case "Field":
+		return strconv.FormatUint(inst.Field, 10), true
+	case "Shift": // IM shift code is in units of 16 bits
+		return strconv.Itoa(int(inst.Shamt) * 16), true
+	case "Sysreg":
+		return sysregName(inst.Field), true
+	}
+	return "", false
+}
+
+// disassemblySyntax is the operand syntax of the disassembly for the
+// instructions it writes differently from the cycle trace
+var disassemblySyntax = map[string]string{
+	"AND":  "Rd, Rn, Rm",
+	"ADD":  "Rd, Rn, Rm",
+	"ORR":  "Rd, Rn, Rm",
+	"SUB":  "Rd, Rn, Rm",
+	"EOR":  "Rd, Rn, Rm",
+	"LSR":  "Rd, Rn, Rm",
+	"LSL":  "Rd, Rn, Rm",
+	"ASR":  "Rd, Rn, Rm",
+	"B":    "#Offset",
+	"CBZ":  "Rt, Offset",
+	"CBNZ": "Rt, Offset",
+	"MOVZ": "Rd, Field, LSL Shamt",
+	"MOVK": "Rd, Field, LSL Shamt",
+}
+
+// alias returns the alias the instruction is written as, if it has one
+func (inst Instruction) alias() (alias, bool) {
+	for _, a := range aliases {
+		if a.of == inst.Op && inst.Rd == XZR {
+			return a, true
+		}
+	}
+	return alias{}, false
+}
+
+// Mnemonic returns the name the instruction is written with, this is Op
+// unless the instruction is an alias such as CMP
+func (inst Instruction) Mnemonic() string {
+	if a, ok := inst.alias(); ok {
+		return a.mnemonic
+	}
+	return inst.Op
+}
+
+// Operands returns the operand text of the instruction built from its Syntax
+func (inst Instruction) Operands() string {
+	enc := Lookup(inst.Op)
+	if enc == nil {
+		return ""
+	}
+	if a, ok := inst.alias(); ok {
+		return inst.operands(a.syntax)
+	}
+	return inst.operands(enc.Syntax)
+}
+
+// disassembly returns the operand text of the disassembly file
+func (inst Instruction) disassembly() string {
+	if syntax, ok := disassemblySyntax[inst.Op]; ok {
+		return inst.operands(syntax)
+	}
+	return inst.Operands()
+}
+
+// operands fills in the operand names used in a syntax string
+func (inst Instruction) operands(syntax string) string {
+	var str strings.Builder
+	word := ""
+	flush := func() {
+		if text, ok := inst.operand(word); ok {
+			str.WriteString(text)
+		} else {
+			str.WriteString(word)
+		}
+		word = ""
+	}
+	for _, c := range syntax {
+		if c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' {
+			word += string(c)
+			continue
+		}
+		flush()
+		str.WriteRune(c)
+	}
+	flush()
+	return str.String()
+}
+
+// boundaries returns the bit positions (counted from the left of the
+// binary string) where each field of the format begins
+func boundaries(format string) []int {
+	var breaks []int
+	for _, f := range formats[format] {
+		breaks = append(breaks, 31-f.hi)
+	}
+	return breaks
+}
@@ -0,0 +1,122 @@
+package legv8
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestDecodeOpcodes checks the instruction table against the ranges of the
+// top 11 bits the switch of the first version decoded.
+func TestDecodeOpcodes(t *testing.T) {
+	ranges := []struct {
+		lo, hi     uint64
+		op, format string
+	}{
+		{161, 191, "B", "B"}, // 160 is B #0, which is below the line value check
+		{1104, 1104, "AND", "R"},
+		{1112, 1112, "ADD", "R"},
+		{1160, 1161, "ADDI", "I"},
+		{1360, 1360, "ORR", "R"},
+		{1440, 1447, "CBZ", "CB"},
+		{1448, 1455, "CBNZ", "CB"},
+		{1624, 1624, "SUB", "R"},
+		{1672, 1673, "SUBI", "I"},
+		{1684, 1687, "MOVZ", "IM"},
+		{1940, 1943, "MOVK", "IM"},
+		{1690, 1690, "LSR", "R"},
+		{1691, 1691, "LSL", "R"},
+		{1984, 1984, "STUR", "D"},
+		{1986, 1986, "LDUR", "D"},
+		{1692, 1692, "ASR", "R"},
+		{1872, 1872, "EOR", "R"},
+		{2038, 2038, "BREAK", "BREAK"},
+	}
+	for _, r := range ranges {
+		for opcode := r.lo; opcode <= r.hi; opcode++ {
+			var inst Instruction
+			decode(&inst, opcode<<21)
+			if inst.Op != r.op || inst.TypeOfInstruction != r.format {
+				t.Errorf("opcode %d decodes to %s (%s), want %s (%s)", opcode, inst.Op, inst.TypeOfInstruction, r.op, r.format)
+			}
+		}
+	}
+
+	var nop Instruction
+	decode(&nop, 0)
+	if nop.Op != "NOP" {
+		t.Errorf("0 decodes to %q, want NOP", nop.Op)
+	}
+}
+
+func TestDecodeFields(t *testing.T) {
+	tests := []struct {
+		bits string
+		want Instruction
+	}{
+		{"10001011000000100000000000100011", Instruction{Op: "ADD", Rm: 2, Rn: 1, Rd: 3}},
+		{"11010011011000000001110000000011", Instruction{Op: "LSL", Shamt: 3, Rd: 3}},
+		{"10010001000001001011000000100001", Instruction{Op: "ADDI", Im: 44, Rn: 1, Rd: 1}},
+		{"11111000000110110000110110001101", Instruction{Op: "STUR", Address: 176, Rn: 12, Rt: 13}},
+		{"10110100000000000000000001001100", Instruction{Op: "CBZ", Offset: 2, Rt: 12}},
+		{"00010111111111111111111111111101", Instruction{Op: "B", Offset: -3}},
+		{"11110010101000000000000000100000", Instruction{Op: "MOVK", Shamt: 1, Field: 1, Rd: 0}},
+		{"11101010000000100000000000100011", Instruction{Op: "EOR", Rm: 2, Rn: 1, Rd: 3}},
+		{"11101010001000100000000000100011", Instruction{Op: "ANDS", Rm: 2, Rn: 1, Rd: 3}},
+		{"01010100111111111111111111101011", Instruction{Op: "B.LT", Offset: -1, Rt: 11}},
+		{"00010100000000000000000000000000", Instruction{}}, // B #0 is not decoded
+	}
+	for _, test := range tests {
+		program, _ := ReadFile(strings.NewReader(test.bits))
+		if err := InitializeInstructions(program); err != nil {
+			t.Fatal(err)
+		}
+		got := program[0]
+		w := test.want
+		if got.Op != w.Op || got.Rm != w.Rm || got.Rn != w.Rn || got.Rd != w.Rd || got.Rt != w.Rt || got.Im != w.Im ||
+			got.Address != w.Address || got.Offset != w.Offset || got.Shamt != w.Shamt || got.Field != w.Field {
+			t.Errorf("%s decodes to %+v, want %+v", test.bits, got, w)
+		}
+	}
+}
+
+func TestInitializeInstructionsErrors(t *testing.T) {
+	tests := []struct {
+		lines []string
+		err   string
+	}{
+		{[]string{"10001011000000100000000000100011", "1000101100000010000000000010001x"}, "line 2:"},
+		{[]string{"11111110110111101111111111100111", "", "0"}, "line 2:"},
+		{[]string{"100010110000001000000000001000110"}, "line 1:"},
+	}
+	for _, test := range tests {
+		program, _ := ReadFile(strings.NewReader(strings.Join(test.lines, "\n")))
+		err := InitializeInstructions(program)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%q: error is %v, want one with %q", test.lines, err, test.err)
+		}
+	}
+}
+
+func TestDisassembleFlagInstructions(t *testing.T) {
+	tests := []struct {
+		bits, want string
+	}{
+		{"11101011000000100000000000111111", "11101011000 00010 000000 00001 11111 96 CMP R1, R2"},
+		{"11110001000000000010100001011111", "1111000100 000000001010 00010 11111 96 CMPI R2, #10"},
+		{"10101011000000100000000000100011", "10101011000 00010 000000 00001 00011 96 ADDS R3, R1, R2"},
+		{"01010100111111111111111111101011", "01010100 1111111111111111111 01011 96 B.LT #-1"},
+	}
+	for _, test := range tests {
+		program, _ := ReadFile(strings.NewReader(test.bits + "\n11111110110111101111111111100111"))
+		if err := InitializeInstructions(program); err != nil {
+			t.Fatal(err)
+		}
+		var out strings.Builder
+		if err := PrintResults(&out, program); err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.SplitN(out.String(), "\n", 2)[0]; got != test.want {
+			t.Errorf("%s disassembles to %q, want %q", test.bits, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package legv8
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// PrintResults writes the disassembly of the program to w, one line per
+// instruction followed by the data words after BREAK.
+func PrintResults(w io.Writer, instrArray []Instruction) (err error) {
+	i := 0
+	for i < len(instrArray) && instrArray[i].TypeOfInstruction != "BREAK" { // loop through each array of structs
+		inst := instrArray[i]
+		switch inst.TypeOfInstruction {
+		case "":
+			// keep going so the rest of the program still gets disassembled
+			if err == nil {
+				err = fmt.Errorf("legv8: invalid value on line %d", i)
+			}
+		case "N/A":
+			fmt.Fprint(w, inst.RawInstruction+" "+strconv.Itoa(inst.ProgramCnt)+" "+inst.Op)
+		default:
+			// print the binary separated into the fields of the instruction format, then pc, op and operands
+			fmt.Fprint(w, groupBits(inst.RawInstruction, boundaries(inst.TypeOfInstruction)...))
+			fmt.Fprint(w, " "+strconv.Itoa(inst.ProgramCnt)+" "+inst.Mnemonic()+" "+inst.disassembly())
+		}
+
+		fmt.Fprint(w, "\n")
+		i++
+	}
+	if i == len(instrArray) {
+		return
+	}
+	fmt.Fprint(w, instrArray[i].RawInstruction+" "+strconv.Itoa(instrArray[i].ProgramCnt)+" BREAK\n")
+	for i = i + 1; i < len(instrArray); i++ {
+		lineValue, _ := strconv.ParseUint(instrArray[i].RawInstruction, 2, 32)
+		fmt.Fprint(w, instrArray[i].RawInstruction+" "+strconv.Itoa(instrArray[i].ProgramCnt)+
+			" "+strconv.Itoa(int(signedVariable(lineValue, 32)))+"\n")
+	}
+	return
+}
+
+// groupBits returns the raw binary string with a space before each of the
+// given bit positions so the fields of the instruction line up
+func groupBits(raw string, breaks ...int) string {
+	str := ""
+	for j := 0; j < len(raw); j++ {
+		for _, b := range breaks {
+			if j == b { // print spaces to separate
+				str = str + " "
+			}
+		}
+		str = str + string(raw[j])
+	}
+	return str
+}
+
+// Format picks what SimInstructions writes.
+type Format int
+
+const (
+	// Full writes everything: the flags, call depth, exceptions and cache
+	// accesses of each cycle, then the backtrace and statistics at the end
+	Full Format = iota
+	// Reference writes only the instruction, registers and data of each
+	// cycle, exactly as the reference simulator does, so its _sim.txt files
+	// can be diffed against
+	Reference
+)
+
+// SimInstructions runs the machine until BREAK and writes every cycle to w
+// in the given format. In Full format a backtrace of the call stack follows
+// once BREAK retires or the machine faults. Each of also is called after a
+// cycle is written.
+func SimInstructions(m *Machine, w io.Writer, format Format, also ...Tracer) error {
+	err := m.Run(func(m *Machine, inst Instruction) {
+		PrintSimulation(w, m, inst, format)
+		for _, trace := range also {
+			trace(m, inst)
+		}
+	})
+	if format == Reference {
+		return err
+	}
+	if err != nil {
+		fmt.Fprintf(w, "====================\nFault:\t%v\n", err)
+		PrintBacktrace(w, m, m.PC)
+		return err
+	}
+	fmt.Fprintln(w, "====================")
+	if m.ExitCode != 0 {
+		fmt.Fprintf(w, "Exit code:\t%d\n", m.ExitCode)
+	}
+	PrintBacktrace(w, m, m.PC-4)
+	if m.Caches != nil {
+		PrintCacheStats(w, m.Caches)
+	}
+	if m.Branches != nil {
+		PrintBranchStats(w, m.Branches, m, m.Cycle)
+	}
+	return nil
+}
+
+// PrintSimulation writes one cycle of the simulation: the instruction that
+// just retired followed by the registers and data of the machine.
+func PrintSimulation(f io.Writer, m *Machine, sim Instruction, format Format) {
+	fmt.Fprintln(f, "====================")
+	fmt.Fprintf(f, "Cycle:%d\t%d\t%s\n", sim.Cycle, sim.ProgramCnt, InstructionString(sim))
+	if format == Full && m.Exception != nil {
+		fmt.Fprintf(f, "Exception:\t%s\n", m.Exception)
+	}
+	if format == Full && m.Caches != nil {
+		fmt.Fprint(f, "\nCache:\n")
+		for _, access := range m.Caches.Log {
+			fmt.Fprintf(f, "\t%s\n", access)
+		}
+	}
+	printState(f, m, format)
+}
+
+// printState writes the registers and data of the machine, in Full format
+// with the flags and call depth
+func printState(f io.Writer, m *Machine, format Format) {
+	// print current register
+	fmt.Fprint(f, "\nRegisters:\n")
+	fmt.Fprintf(f, "r00:\t%s", registerString(m, 8))
+	fmt.Fprintf(f, "\nr08:\t%s", registerString(m, 16))
+	fmt.Fprintf(f, "\nr16:\t%s", registerString(m, 24))
+	fmt.Fprintf(f, "\nr24:\t%s\n", registerString(m, 32))
+	if format == Full {
+		fmt.Fprintf(f, "flags:\t%s\n", m.Flags)
+		fmt.Fprintf(f, "depth:\t%d\n", m.Depth())
+	}
+
+	// print data
+	fmt.Fprintf(f, "\nData:")
+	keys := m.Memory.Words() // addresses of every word that has been written
+	// iterate through the index array and use to print data
+	if keys != nil {
+		last := keys[len(keys)-1]
+		for key := keys[0]; key <= last; key = key + 32 {
+			fmt.Fprintf(f, "\n%d:\t", key)
+			for i := 0; i < 32; i = i + 4 {
+				// the missing words of a row count as written from then on,
+				// so they stay in the rows printed later
+				m.Memory.touch(key + i)
+				fmt.Fprintf(f, "%d\t", m.Memory.Word(key+i))
+			}
+		}
+	}
+
+	fmt.Fprintf(f, "\n")
+}
+
+// InstructionString returns the assembly text of the instruction
+func InstructionString(sim Instruction) string {
+	return sim.Mnemonic() + "\t" + sim.Operands()
+}
+
+// registerString returns the 8 registers below highValue separated by tabs
+func registerString(m *Machine, highValue int) string {
+	var str = ""
+	for i := highValue - 8; i < highValue; i++ {
+		str = str + strconv.FormatInt(m.Registers[i], 10) + "\t"
+	}
+	return str
+}
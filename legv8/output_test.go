@@ -0,0 +1,65 @@
+package legv8
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// load reads and decodes a program from testdata
+func load(t *testing.T, name string) []Instruction {
+	t.Helper()
+	f, err := os.Open(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	program, err := ReadFile(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := InitializeInstructions(program); err != nil {
+		t.Fatal(err)
+	}
+	return program
+}
+
+// TestOutput compares the disassembly and the cycle trace with what the
+// first version of this tool wrote for the same programs, the _reference.sim
+// files are its output unchanged. quirks shows how this ISA decodes: ADDI
+// keeps 8 bits of 300, STUR and LDUR count words, LSL shifts by R0 and CBZ
+// tests the number 31.
+func TestOutput(t *testing.T) {
+	for _, name := range []string{"addtest1", "quirks"} {
+		t.Run(name, func(t *testing.T) {
+			program := load(t, name+"_bin.txt")
+
+			var dis, sim, reference bytes.Buffer
+			if err := PrintResults(&dis, program); err != nil {
+				t.Fatalf("PrintResults: %v", err)
+			}
+			m := NewMachine()
+			m.Load(program)
+			if err := SimInstructions(m, &sim, Full); err != nil {
+				t.Fatalf("SimInstructions: %v", err)
+			}
+			m.Reset()
+			if err := SimInstructions(m, &reference, Reference); err != nil {
+				t.Fatalf("SimInstructions: %v", err)
+			}
+			for _, out := range []struct {
+				file string
+				got  []byte
+			}{{".dis", dis.Bytes()}, {".sim", sim.Bytes()}, {"_reference.sim", reference.Bytes()}} {
+				want, err := os.ReadFile(filepath.Join("testdata", name+out.file))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(out.got, want) {
+					t.Errorf("output differs from testdata/%s%s", name, out.file)
+				}
+			}
+		})
+	}
+}
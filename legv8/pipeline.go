@@ -0,0 +1,262 @@
+package legv8
+
+import (
+	"fmt"
+	"io"
+)
+
+// PipelineConfig picks how the five stage pipeline handles hazards.
+type PipelineConfig struct {
+	Forwarding  bool // forward results into EX (and into ID for branches) instead of waiting for WB
+	ResolveInID bool // branches resolve in ID and flush one instruction instead of two in EX
+}
+
+// PipelineStats counts what happened over a pipelined run.
+type PipelineStats struct {
+	Cycles       int
+	Instructions int // retired instructions
+	Stalls       int // cycles an instruction waited in ID on a data hazard
+	MemoryStalls int // extra cycles spent in IF and MEM waiting on the caches
+	Flushes      int // taken or mispredicted branches that squashed the instructions behind them
+	Flushed      int // wrong path instructions that were squashed
+}
+
+// slot is one instruction going through the pipeline. It sits in IF from
+// fetch until decode-1, in ID from decode until execute-1, in EX for one
+// cycle, in MEM until memEnd (more than one cycle when the caches miss) and
+// in WB the cycle after. A wrong path slot is squashed at the end of the
+// cycle its branch resolves in.
+type slot struct {
+	inst    Instruction
+	fetch   int
+	decode  int
+	execute int
+	memEnd  int
+	squash  int // cycle the slot is thrown away in, 0 if it is on the right path
+}
+
+// producer is the last instruction to write a register
+type producer struct {
+	execute int
+	memEnd  int
+	load    bool // the value is not there until the end of MEM
+}
+
+// Pipeline is a five stage IF/ID/EX/MEM/WB timing model. The Machine does
+// the actual work one instruction at a time, the pipeline only works out the
+// cycle each instruction goes through each stage in, so the final state is
+// always the same as a single cycle run.
+type Pipeline struct {
+	Config  PipelineConfig
+	Machine *Machine
+	Stats   PipelineStats
+
+	slots    []slot // slots that still show up in a cycle that has not been printed
+	last     slot   // last right path instruction scheduled
+	written  map[uint8]producer
+	redirect int // earliest fetch of the instruction after a taken branch
+	printed  int // last cycle written out
+}
+
+// NewPipeline returns a pipeline that runs the machine.
+func NewPipeline(m *Machine, config PipelineConfig) *Pipeline {
+	return &Pipeline{Config: config, Machine: m, written: make(map[uint8]producer)}
+}
+
+// Run steps the machine until BREAK, writing which instruction is in each
+// stage for every cycle to w, followed by a summary and the final state.
+func (p *Pipeline) Run(w io.Writer) error {
+	for !p.Machine.Halted {
+		inst, err := p.Machine.Step()
+		if err != nil {
+			p.print(w, p.last.memEnd+1)
+			fmt.Fprintf(w, "====================\nFault:\t%v\n", err)
+			return err
+		}
+		// without a predictor every taken branch flushes what was fetched
+		// behind it, an exception is not a branch
+		flush, wrongPC := !p.Machine.faulted() && p.Machine.PC != inst.ProgramCnt+4, inst.ProgramCnt+4
+		if bu := p.Machine.Branches; bu != nil {
+			flush, wrongPC = inst.IsBranch() && bu.Mispredicted, bu.Fetched
+		}
+		p.schedule(inst, flush, wrongPC)
+		p.print(w, p.last.fetch-1) // nothing later can land in an earlier cycle
+	}
+	p.Stats.Cycles = p.last.memEnd + 1
+	p.print(w, p.Stats.Cycles)
+	p.printSummary(w)
+	if p.Machine.Caches != nil {
+		PrintCacheStats(w, p.Machine.Caches)
+	}
+	if p.Machine.Branches != nil {
+		PrintBranchStats(w, p.Machine.Branches, p.Machine, 0)
+	}
+	return nil
+}
+
+// schedule works out the cycles of the next retired instruction, flush is
+// set when the front end fetched from wrongPC instead of where inst went
+func (p *Pipeline) schedule(inst Instruction, flush bool, wrongPC int) {
+	fetchCycles, memCycles := 1, 1
+	if cs := p.Machine.Caches; cs != nil {
+		fetchCycles = maxInt(cs.FetchCycles, 1)
+		memCycles = maxInt(cs.DataCycles, 1)
+	}
+	p.Stats.MemoryStalls += fetchCycles - 1 + memCycles - 1
+
+	s := slot{inst: inst}
+	s.fetch = maxInt(p.last.fetch+1, p.last.decode, p.redirect)     // IF is free once the one ahead moved to ID
+	s.decode = maxInt(s.fetch+fetchCycles, p.last.execute)          // ID is free once the one ahead moved to EX
+	earliest := maxInt(s.decode+1, p.last.execute+1, p.last.memEnd) // MEM has to be free after EX
+	s.execute = earliest
+	for _, r := range inst.Reads() {
+		if w, ok := p.written[r]; ok {
+			s.execute = maxInt(s.execute, p.ready(w, inst))
+		}
+	}
+	s.memEnd = s.execute + memCycles
+	p.Stats.Stalls += s.execute - earliest
+	p.Stats.Instructions++
+
+	for _, r := range inst.Writes() {
+		p.written[r] = producer{s.execute, s.memEnd, inst.IsLoad()}
+	}
+	p.slots = append(p.slots, s)
+	p.last = s
+
+	if flush {
+		resolve := s.execute
+		if p.Config.ResolveInID {
+			resolve = s.execute - 1
+		}
+		p.redirect = resolve + 1
+		p.Stats.Flushes++
+		p.wrongPath(s, resolve, wrongPC)
+	}
+}
+
+// ready returns the earliest cycle inst can be in EX and still get the
+// value w writes
+func (p *Pipeline) ready(w producer, inst Instruction) int {
+	if !p.Config.Forwarding {
+		return w.memEnd + 2 // read in ID during the WB of the producer
+	}
+	ready := w.execute + 1 // EX/MEM forwarding
+	if w.load {
+		ready = w.memEnd + 1 // MEM/WB forwarding, the load-use stall
+	}
+	if p.Config.ResolveInID && inst.IsBranch() {
+		ready++ // branches compare in ID so they need the value a cycle sooner
+	}
+	return ready
+}
+
+// wrongPath adds the instructions fetched from pc behind a branch before it
+// resolved, they get squashed at the end of the resolve cycle
+func (p *Pipeline) wrongPath(branch slot, resolve int, pc int) {
+	fetch, decode := branch.decode, branch.execute
+	for fetch <= resolve {
+		s := slot{fetch: fetch, decode: decode, execute: decode + 1, memEnd: decode + 2, squash: resolve}
+		if inst, err := p.Machine.Fetch(pc); err == nil {
+			s.inst = *inst
+		}
+		p.slots = append(p.slots, s)
+		p.Stats.Flushed++
+		fetch, decode = decode, decode+1
+		pc += 4
+	}
+}
+
+// print writes every cycle after the last one printed up to and including last
+func (p *Pipeline) print(w io.Writer, last int) {
+	for cycle := p.printed + 1; cycle <= last; cycle++ {
+		fmt.Fprintln(w, "====================")
+		fmt.Fprintf(w, "Cycle:%d\n", cycle)
+		for _, stage := range []string{"IF", "ID", "EX", "MEM", "WB"} {
+			fmt.Fprintf(w, "%s:\t%s\n", stage, p.stage(stage, cycle))
+		}
+	}
+	if last > p.printed {
+		p.printed = last
+	}
+
+	// drop slots that are done
+	kept := p.slots[:0]
+	for _, s := range p.slots {
+		if s.squash == 0 && s.memEnd+1 > p.printed || s.squash > p.printed {
+			kept = append(kept, s)
+		}
+	}
+	p.slots = kept
+}
+
+// stage returns the text for the instruction in a stage during a cycle
+func (p *Pipeline) stage(stage string, cycle int) string {
+	for _, s := range p.slots {
+		if s.squash != 0 && cycle > s.squash {
+			continue
+		}
+		first := 0
+		switch {
+		case stage == "IF" && s.fetch <= cycle && cycle < s.decode:
+			first = s.fetch
+		case stage == "ID" && s.decode <= cycle && cycle < s.execute:
+			first = s.decode
+		case stage == "EX" && s.execute == cycle && s.squash == 0,
+			stage == "WB" && s.memEnd+1 == cycle && s.squash == 0:
+			first = cycle
+		case stage == "MEM" && s.execute < cycle && cycle <= s.memEnd && s.squash == 0:
+			first = s.execute + 1
+		default:
+			continue
+		}
+		text := fmt.Sprintf("%d\t%s", s.inst.ProgramCnt, InstructionString(s.inst))
+		if s.inst.Op == "" {
+			text = "?"
+		}
+		if cycle > first {
+			text += "\t(stall)"
+		}
+		if s.squash != 0 {
+			text += "\t(flushed)"
+		}
+		return text
+	}
+	return "-"
+}
+
+// printSummary writes the pipeline statistics and the final machine state
+func (p *Pipeline) printSummary(w io.Writer) {
+	forwarding, resolve := "off", "EX"
+	if p.Config.Forwarding {
+		forwarding = "on"
+	}
+	if p.Config.ResolveInID {
+		resolve = "ID"
+	}
+	fmt.Fprintln(w, "====================")
+	fmt.Fprintf(w, "Pipeline:\tforwarding %s, branches resolve in %s\n", forwarding, resolve)
+	fmt.Fprintf(w, "Instructions:\t%d\n", p.Stats.Instructions)
+	fmt.Fprintf(w, "Cycles:\t%d\n", p.Stats.Cycles)
+	fmt.Fprintf(w, "CPI:\t%.2f\n", float64(p.Stats.Cycles)/float64(p.Stats.Instructions))
+	fmt.Fprintf(w, "Stall cycles:\t%d\n", p.Stats.Stalls)
+	fmt.Fprintf(w, "Memory stall cycles:\t%d\n", p.Stats.MemoryStalls)
+	if p.Machine.Branches != nil {
+		fmt.Fprintf(w, "Mispredicted branches:\t%d\n", p.Stats.Flushes)
+	} else {
+		fmt.Fprintf(w, "Taken branches:\t%d\n", p.Stats.Flushes)
+	}
+	fmt.Fprintf(w, "Flushed instructions:\t%d\n", p.Stats.Flushed)
+	printState(w, p.Machine, Full)
+}
+
+// maxInt returns the largest of the values
+func maxInt(values ...int) int {
+	largest := values[0]
+	for _, v := range values[1:] {
+		if v > largest {
+			largest = v
+		}
+	}
+	return largest
+}
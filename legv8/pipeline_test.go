@@ -0,0 +1,65 @@
+package legv8
+
+import (
+	"io"
+	"testing"
+)
+
+func TestPipelineHazards(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		config PipelineConfig
+		want   PipelineStats
+	}{
+		{"alu forwarded", "ADDI X1, XZR, #1\nADD X3, X1, X1\nBREAK",
+			PipelineConfig{Forwarding: true}, PipelineStats{Cycles: 7, Instructions: 3}},
+		{"alu waits for WB", "ADDI X1, XZR, #1\nADD X3, X1, X1\nBREAK",
+			PipelineConfig{}, PipelineStats{Cycles: 9, Instructions: 3, Stalls: 2}},
+		{"load use", "LDUR X1, [XZR, #0]\nADD X3, X1, X1\nBREAK",
+			PipelineConfig{Forwarding: true}, PipelineStats{Cycles: 8, Instructions: 3, Stalls: 1}},
+		{"branch in EX", "B #2\nADDI X1, XZR, #1\nBREAK",
+			PipelineConfig{Forwarding: true}, PipelineStats{Cycles: 8, Instructions: 2, Flushes: 1, Flushed: 2}},
+		{"branch in ID", "B #2\nADDI X1, XZR, #1\nBREAK",
+			PipelineConfig{Forwarding: true, ResolveInID: true}, PipelineStats{Cycles: 7, Instructions: 2, Flushes: 1, Flushed: 1}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := NewMachine()
+			m.Load(assemble(t, test.source))
+			p := NewPipeline(m, test.config)
+			if err := p.Run(io.Discard); err != nil {
+				t.Fatal(err)
+			}
+			if p.Stats != test.want {
+				t.Errorf("stats are %+v, want %+v", p.Stats, test.want)
+			}
+		})
+	}
+}
+
+// TestPipelineState runs a loop both ways, the pipeline only adds timing so
+// the registers have to come out the same
+func TestPipelineState(t *testing.T) {
+	program := assemble(t, `
+	ADDI X1, XZR, #10
+loop:	ADD  X3, X3, X1
+	STUR X3, [XZR, #50]
+	LDUR X4, [XZR, #50]
+	SUBIS X1, X1, #1
+	B.NE loop
+	BREAK`)
+	single := NewMachine()
+	single.Load(program)
+	if err := single.Run(nil); err != nil {
+		t.Fatal(err)
+	}
+	m := NewMachine()
+	m.Load(program)
+	if err := NewPipeline(m, PipelineConfig{Forwarding: true}).Run(io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	if m.Registers != single.Registers || m.Registers[4] != 55 {
+		t.Errorf("pipelined registers are %v, single cycle %v", m.Registers, single.Registers)
+	}
+}
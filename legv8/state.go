@@ -0,0 +1,174 @@
+package legv8
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// State is a machine state to start a program from instead of all zeros, so
+// one program can be run on different inputs. It is read from JSON:
+//
+//	{
+//		"registers": {"X1": 10, "SP": 2048},
+//		"flags": "Z",
+//		"memory": [
+//			{"addr": 200, "values": [1, 2, 3]},
+//			{"addr": 400, "size": 8, "values": [-1]}
+//		]
+//	}
+type State struct {
+	Registers map[string]int64 `json:"registers,omitempty"` // by assembler name: X1, R1, SP, LR... but only one name per register
+	Flags     string           `json:"flags,omitempty"`     // the flags that are set, such as "NZ"
+	Memory    []MemoryRange    `json:"memory,omitempty"`
+}
+
+// MemoryRange is a run of values stored one after another from Addr.
+type MemoryRange struct {
+	Addr   int     `json:"addr"`
+	Size   int     `json:"size,omitempty"` // bytes per value, 4 if left out like the data words after BREAK
+	Values []int64 `json:"values"`
+}
+
+// SetRegister adds a register setting such as "X1=10" to the state.
+func (s *State) SetRegister(spec string) error {
+	name, text, ok := strings.Cut(spec, "=")
+	value, err := strconv.ParseInt(strings.TrimSpace(text), 0, 64)
+	if !ok || err != nil {
+		return fmt.Errorf("legv8: register setting %q is not register=value", spec)
+	}
+	if s.Registers == nil {
+		s.Registers = make(map[string]int64)
+	}
+	s.Registers[strings.TrimSpace(name)] = value
+	return nil
+}
+
+// AddMemory adds a memory range such as "200=1,2,3" or "400:8=-1" to the
+// state, the optional :size gives the bytes per value.
+func (s *State) AddMemory(spec string) error {
+	bad := fmt.Errorf("legv8: memory setting %q is not addr[:size]=value,value...", spec)
+	at, list, ok := strings.Cut(spec, "=")
+	if !ok {
+		return bad
+	}
+	r := MemoryRange{Size: 4}
+	addr, size, sized := strings.Cut(at, ":")
+	var err error
+	if r.Addr, err = strconv.Atoi(strings.TrimSpace(addr)); err != nil {
+		return bad
+	}
+	if sized {
+		if r.Size, err = strconv.Atoi(strings.TrimSpace(size)); err != nil {
+			return bad
+		}
+	}
+	for _, text := range strings.Split(list, ",") {
+		value, err := strconv.ParseInt(strings.TrimSpace(text), 0, 64)
+		if err != nil {
+			return bad
+		}
+		r.Values = append(r.Values, value)
+	}
+	s.Memory = append(s.Memory, r)
+	return nil
+}
+
+// SetInitialState makes Reset start the machine from s, and resets it.
+func (m *Machine) SetInitialState(s *State) error {
+	m.initial = nil
+	m.Reset()
+	if err := m.apply(s); err != nil {
+		m.Reset()
+		return err
+	}
+	m.initial = s
+	return nil
+}
+
+// apply sets the registers, flags and memory of a state
+func (m *Machine) apply(s *State) error {
+	if s == nil {
+		return nil
+	}
+	// in name order, so an error is always about the same register
+	var names []string
+	for name := range s.Registers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	named := make(map[int64]string)
+	for _, name := range names {
+		r, err := asmLine{}.register(token{text: name})
+		if err != nil {
+			return fmt.Errorf("legv8: state: %q is not a register", name)
+		}
+		if r == XZR {
+			return fmt.Errorf("legv8: state: XZR is always zero")
+		}
+		if other, ok := named[r]; ok {
+			return fmt.Errorf("legv8: state: %s and %s are the same register", other, name)
+		}
+		named[r] = name
+		m.Registers[r] = s.Registers[name]
+	}
+	flags, err := parseFlags(s.Flags)
+	if err != nil {
+		return fmt.Errorf("legv8: state: %v", err)
+	}
+	m.Flags = flags
+	for _, r := range s.Memory {
+		size := r.Size
+		if size == 0 {
+			size = 4
+		}
+		if size != 1 && size != 2 && size != 4 && size != 8 {
+			return fmt.Errorf("legv8: state: memory at %d: size must be 1, 2, 4 or 8", r.Addr)
+		}
+		for i, value := range r.Values {
+			if err := m.Memory.Store(r.Addr+i*size, size, uint64(value)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// parseFlags reads the letters of the flags that are set, such as "NZ"
+func parseFlags(letters string) (Flags, error) {
+	var f Flags
+	for _, c := range strings.ToUpper(letters) {
+		switch c {
+		case 'N':
+			f.N = true
+		case 'Z':
+			f.Z = true
+		case 'C':
+			f.C = true
+		case 'V':
+			f.V = true
+		default:
+			return f, fmt.Errorf("flags %q can only hold N, Z, C and V", letters)
+		}
+	}
+	return f, nil
+}
+
+// ReadState reads a JSON state file.
+func ReadState(r io.Reader) (*State, error) {
+	var s State
+	if err := decodeFile(r, &s); err != nil {
+		return nil, fmt.Errorf("legv8: state: %v", err)
+	}
+	return &s, nil
+}
+
+// decodeFile reads a JSON file into v, fields v does not have are an error
+func decodeFile(r io.Reader, v interface{}) error {
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(v)
+}
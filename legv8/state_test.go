@@ -0,0 +1,106 @@
+package legv8
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestReadState(t *testing.T) {
+	s, err := ReadState(strings.NewReader(`{
+		"registers": {"X1": 10, "SP": 2048, "LR": -1},
+		"flags": "nz",
+		"memory": [
+			{"addr": 200, "values": [1, 2, 3]},
+			{"addr": 400, "size": 8, "values": [-1]},
+			{"addr": 500, "size": 1, "values": [255, 1]}
+		]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewMachine()
+	if err := m.SetInitialState(s); err != nil {
+		t.Fatal(err)
+	}
+	if m.Registers[1] != 10 || m.Registers[SP] != 2048 || m.Registers[LR] != -1 {
+		t.Errorf("registers are %v", m.Registers)
+	}
+	if m.Flags != (Flags{N: true, Z: true}) {
+		t.Errorf("flags are %v", m.Flags)
+	}
+	words := []struct {
+		addr int
+		want int32
+	}{{200, 1}, {204, 2}, {208, 3}, {400, -1}, {404, -1}, {500, 0x1FF}}
+	for _, w := range words {
+		if got := m.Memory.Word(w.addr); got != w.want {
+			t.Errorf("memory %d is %d, want %d", w.addr, got, w.want)
+		}
+	}
+
+	// Reset goes back to the state, not to zeros
+	m.Registers[1] = 0
+	m.Reset()
+	if m.Registers[1] != 10 {
+		t.Errorf("after Reset X1 is %d, want 10", m.Registers[1])
+	}
+}
+
+func TestStateErrors(t *testing.T) {
+	tests := []struct {
+		json string
+		err  string
+	}{
+		{`{"registers": {"X1": 1}, "stack": 5}`, `unknown field "stack"`},
+		{`registers: {X1: 1}`, "invalid character"},
+		{`{"registers": {"X32": 1}}`, `"X32" is not a register`},
+		{`{"registers": {"XZR": 1}}`, "XZR is always zero"},
+		{`{"registers": {"SP": 1, "X28": 2}}`, "SP and X28 are the same register"},
+		{`{"registers": {"LR": 1, "R30": 2}}`, "LR and R30 are the same register"},
+		{`{"flags": "NQ"}`, `flags "NQ" can only hold N, Z, C and V`},
+		{`{"memory": [{"addr": 200, "size": 3, "values": [1]}]}`, "size must be 1, 2, 4 or 8"},
+	}
+	for _, test := range tests {
+		s, err := ReadState(strings.NewReader(test.json))
+		if err == nil {
+			m := NewMachine()
+			err = m.SetInitialState(s)
+			if err != nil && (m.Registers != [32]int64{} || m.Flags != Flags{}) {
+				t.Errorf("%s: a state that failed was left in the machine", test.json)
+			}
+		}
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: error is %v, want one with %q", test.json, err, test.err)
+		}
+	}
+}
+
+func TestStateSpecs(t *testing.T) {
+	var s State
+	for _, spec := range []string{"X1=10", " SP = 0x100"} {
+		if err := s.SetRegister(spec); err != nil {
+			t.Errorf("SetRegister(%q): %v", spec, err)
+		}
+	}
+	for _, spec := range []string{"200=1,2,3", "400:8=-1"} {
+		if err := s.AddMemory(spec); err != nil {
+			t.Errorf("AddMemory(%q): %v", spec, err)
+		}
+	}
+	if s.Registers["X1"] != 10 || s.Registers["SP"] != 256 {
+		t.Errorf("registers are %v", s.Registers)
+	}
+	if len(s.Memory) != 2 || s.Memory[0].Size != 4 || len(s.Memory[0].Values) != 3 || s.Memory[1].Size != 8 {
+		t.Errorf("memory is %+v", s.Memory)
+	}
+	for _, spec := range []string{"X1", "X1=ten"} {
+		if err := s.SetRegister(spec); err == nil {
+			t.Errorf("SetRegister(%q) did not fail", spec)
+		}
+	}
+	for _, spec := range []string{"200", "x=1", "200:y=1", "200=1,,2"} {
+		if err := s.AddMemory(spec); err == nil {
+			t.Errorf("AddMemory(%q) did not fail", spec)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package legv8
+
+import (
+	"fmt"
+	"io"
+)
+
+// SuperscalarConfig describes an in order machine that issues several
+// instructions a cycle.
+type SuperscalarConfig struct {
+	Width       int // most instructions issued in one cycle
+	MemoryPorts int // most loads and stores issued in one cycle
+	LoadLatency int // cycles after a load issues before its value can be used
+}
+
+// SuperscalarStats counts what happened over a superscalar run.
+type SuperscalarStats struct {
+	Cycles           int
+	Instructions     int
+	DataStalls       int   // cycles an instruction waited for an operand
+	StructuralStalls int   // cycles an instruction waited for a memory port
+	BranchStalls     int   // cycles lost to mispredicted branches
+	GroupSizes       []int // cycles that issued n instructions, by n
+}
+
+// Superscalar is an in order multiple issue timing model. Like Pipeline, the
+// Machine does the actual work one instruction at a time and the model only
+// works out the cycle each instruction issues in. An instruction joins the
+// group of the cycle if the group has room, a memory port is free for loads
+// and stores, and all its operands are ready, using the registers Reads and
+// Writes get from the decoded fields. A branch is always the last
+// instruction of its group.
+type Superscalar struct {
+	Config  SuperscalarConfig
+	Machine *Machine
+	Stats   SuperscalarStats
+
+	groups  map[int][]Instruction // instructions issued in each cycle
+	stalls  map[int]string        // why the next instruction did not issue in a cycle
+	readyAt map[uint8]int         // first cycle each register can be read in
+	next    int                   // earliest cycle the next instruction can issue in
+	printed int                   // last cycle written out
+}
+
+// NewSuperscalar returns a superscalar model that runs the machine.
+func NewSuperscalar(m *Machine, config SuperscalarConfig) *Superscalar {
+	return &Superscalar{
+		Config:  config,
+		Machine: m,
+		Stats:   SuperscalarStats{GroupSizes: make([]int, config.Width+1)},
+		groups:  make(map[int][]Instruction),
+		stalls:  make(map[int]string),
+		readyAt: make(map[uint8]int),
+		next:    1,
+	}
+}
+
+// Run steps the machine until BREAK, writing the issue group of every cycle
+// to w, followed by a summary and the final state.
+func (s *Superscalar) Run(w io.Writer) error {
+	for !s.Machine.Halted {
+		inst, err := s.Machine.Step()
+		if err != nil {
+			s.print(w, s.next)
+			fmt.Fprintf(w, "====================\nFault:\t%v\n", err)
+			return err
+		}
+		s.schedule(inst)
+		s.print(w, s.next-1) // nothing later can issue in an earlier cycle
+	}
+	s.print(w, s.Stats.Cycles)
+	s.printSummary(w)
+	if s.Machine.Caches != nil {
+		PrintCacheStats(w, s.Machine.Caches)
+	}
+	if s.Machine.Branches != nil {
+		PrintBranchStats(w, s.Machine.Branches, s.Machine, 0)
+	}
+	return nil
+}
+
+// schedule finds the first cycle inst can issue in
+func (s *Superscalar) schedule(inst Instruction) {
+	cycle := s.next
+	for {
+		reason, counter := s.hazard(inst, cycle)
+		if reason == "" {
+			break
+		}
+		if counter != nil { // a full group is not a stall
+			*counter++
+			s.stalls[cycle] = fmt.Sprintf("%d\t%s\t(%s)", inst.ProgramCnt, InstructionString(inst), reason)
+		}
+		cycle++
+	}
+	s.groups[cycle] = append(s.groups[cycle], inst)
+	s.Stats.Instructions++
+	s.Stats.Cycles = cycle
+
+	latency := 1
+	if inst.IsLoad() {
+		latency = s.Config.LoadLatency
+		if cs := s.Machine.Caches; cs != nil {
+			latency += maxInt(cs.DataCycles, 1) - 1
+		}
+	}
+	for _, r := range inst.Writes() {
+		s.readyAt[r] = cycle + latency
+	}
+
+	s.next = cycle
+	if inst.IsBranch() && !s.Machine.faulted() {
+		s.next = cycle + 1
+		if bu := s.Machine.Branches; bu != nil && bu.Mispredicted {
+			s.next += bu.Config.Penalty
+			s.Stats.BranchStalls += bu.Config.Penalty
+		}
+	}
+}
+
+// hazard returns why inst can't issue in cycle and the stall counter it
+// goes in, or "" if it can
+func (s *Superscalar) hazard(inst Instruction, cycle int) (string, *int) {
+	group := s.groups[cycle]
+	if len(group) == s.Config.Width {
+		return "group full", nil
+	}
+	for _, r := range inst.Reads() {
+		if s.readyAt[r] > cycle {
+			return "data hazard on " + registerName(r), &s.Stats.DataStalls
+		}
+	}
+	for _, earlier := range group {
+		for _, r := range earlier.Writes() {
+			for _, w := range inst.Writes() {
+				if r == w {
+					return "data hazard, both write " + registerName(r), &s.Stats.DataStalls
+				}
+			}
+		}
+	}
+	if inst.IsLoad() || inst.IsStore() {
+		ports := 0
+		for _, earlier := range group {
+			if earlier.IsLoad() || earlier.IsStore() {
+				ports++
+			}
+		}
+		if ports == s.Config.MemoryPorts {
+			return "structural hazard on the memory ports", &s.Stats.StructuralStalls
+		}
+	}
+	return "", nil
+}
+
+// registerName names a register in hazard messages
+func registerName(r uint8) string {
+	if r == FlagsReg {
+		return "NZCV"
+	}
+	return fmt.Sprintf("X%d", r)
+}
+
+// print writes every cycle after the last one printed up to and including last
+func (s *Superscalar) print(w io.Writer, last int) {
+	for cycle := s.printed + 1; cycle <= last; cycle++ {
+		group := s.groups[cycle]
+		s.Stats.GroupSizes[len(group)]++
+		fmt.Fprintln(w, "====================")
+		fmt.Fprintf(w, "Cycle:%d\tissued %d\n", cycle, len(group))
+		for _, inst := range group {
+			fmt.Fprintf(w, "\t%d\t%s\n", inst.ProgramCnt, InstructionString(inst))
+		}
+		if stall, ok := s.stalls[cycle]; ok {
+			fmt.Fprintf(w, "stall:\t%s\n", stall)
+		}
+		delete(s.groups, cycle)
+		delete(s.stalls, cycle)
+	}
+	if last > s.printed {
+		s.printed = last
+	}
+}
+
+// printSummary writes the statistics and the final machine state
+func (s *Superscalar) printSummary(w io.Writer) {
+	fmt.Fprintln(w, "====================")
+	fmt.Fprintf(w, "Superscalar:\t%d wide, %d memory ports, %d cycle loads\n",
+		s.Config.Width, s.Config.MemoryPorts, s.Config.LoadLatency)
+	fmt.Fprintf(w, "Instructions:\t%d\n", s.Stats.Instructions)
+	fmt.Fprintf(w, "Cycles:\t%d\n", s.Stats.Cycles)
+	fmt.Fprintf(w, "IPC:\t%.2f\n", float64(s.Stats.Instructions)/float64(s.Stats.Cycles))
+	fmt.Fprintf(w, "Data hazard stalls:\t%d\n", s.Stats.DataStalls)
+	fmt.Fprintf(w, "Structural hazard stalls:\t%d\n", s.Stats.StructuralStalls)
+	fmt.Fprintf(w, "Branch stall cycles:\t%d\n", s.Stats.BranchStalls)
+	for n, cycles := range s.Stats.GroupSizes {
+		fmt.Fprintf(w, "Cycles issuing %d:\t%d\n", n, cycles)
+	}
+	printState(w, s.Machine, Full)
+}
@@ -0,0 +1,59 @@
+package legv8
+
+import (
+	"io"
+	"testing"
+)
+
+// runSuperscalar runs a program on an in order machine of the given width
+// with one memory port and 2 cycle loads
+func runSuperscalar(t *testing.T, source string, width int) *Superscalar {
+	t.Helper()
+	m := NewMachine()
+	m.Load(assemble(t, source))
+	s := NewSuperscalar(m, SuperscalarConfig{Width: width, MemoryPorts: 1, LoadLatency: 2})
+	if err := s.Run(io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestSuperscalarWidth(t *testing.T) {
+	source := "ADDI X1, XZR, #1\nADDI X2, XZR, #2\nADDI X3, XZR, #3\nADDI X4, XZR, #4\nBREAK"
+	for _, test := range []struct {
+		width, cycles int
+		groups        []int
+	}{
+		{1, 5, []int{0, 5}},
+		{2, 3, []int{0, 1, 2}},
+		{4, 2, []int{0, 1, 0, 0, 1}},
+	} {
+		s := runSuperscalar(t, source, test.width)
+		if s.Stats.Cycles != test.cycles || !equalInts(s.Stats.GroupSizes, test.groups) {
+			t.Errorf("%d wide: %d cycles with group sizes %v, want %d and %v",
+				test.width, s.Stats.Cycles, s.Stats.GroupSizes, test.cycles, test.groups)
+		}
+	}
+}
+
+func TestSuperscalarHazards(t *testing.T) {
+	chain := runSuperscalar(t, "ADDI X1, XZR, #1\nADDI X2, X1, #2\nBREAK", 2)
+	if chain.Stats.Cycles != 2 || chain.Stats.DataStalls != 1 {
+		t.Errorf("dependent adds: %d cycles and %d data stalls, want 2 and 1", chain.Stats.Cycles, chain.Stats.DataStalls)
+	}
+
+	loads := runSuperscalar(t, "LDUR X1, [XZR, #0]\nLDUR X2, [XZR, #1]\nADD X3, X2, X2\nBREAK", 4)
+	// the second load waits for the port, the add waits 2 cycles for it
+	if loads.Stats.StructuralStalls != 1 || loads.Stats.DataStalls != 2 || loads.Stats.Cycles != 4 {
+		t.Errorf("loads: %+v, want 1 structural and 2 data stalls over 4 cycles", loads.Stats)
+	}
+}
+
+func TestSuperscalarBranches(t *testing.T) {
+	// a branch ends its group and SUBIS and B.NE depend on each other, so
+	// every instruction of the loop gets a cycle of its own
+	s := runSuperscalar(t, loopProgram, 4)
+	if s.Stats.Instructions != 10 || s.Stats.Cycles != 10 || s.Machine.Registers[1] != 0 {
+		t.Errorf("ran %d instructions in %d cycles leaving X1 at %d", s.Stats.Instructions, s.Stats.Cycles, s.Machine.Registers[1])
+	}
+}
@@ -0,0 +1,67 @@
+package legv8
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+// Syscall numbers, the immediate of SVC picks the call. Arguments and
+// results go in X0.
+const (
+	SysPrintInt    = 1 // print X0 as a signed decimal number
+	SysPrintString = 2 // print the zero terminated string at address X0
+	SysReadInt     = 3 // read a decimal number from the input into X0
+	SysExit        = 4 // stop the program with exit code X0
+	SysCycles      = 5 // put the cycle count into X0
+)
+
+// svc runs the syscall an SVC instruction asks for
+func (m *Machine) svc(number uint64) {
+	out := m.Output
+	if out == nil {
+		out = io.Discard
+	}
+	switch number {
+	case SysPrintInt:
+		fmt.Fprint(out, m.reg(0))
+	case SysPrintString:
+		var text []byte
+		for addr := int(m.reg(0)); ; addr++ {
+			b, err := m.Memory.Load(addr, 1)
+			if err != nil {
+				m.fault = err
+				return
+			}
+			if b == 0 {
+				break
+			}
+			text = append(text, byte(b))
+		}
+		out.Write(text)
+	case SysReadInt:
+		if m.Loops != nil {
+			m.Loops.reset()
+		}
+		if m.Input == nil {
+			m.fault = fmt.Errorf("legv8: read integer: there is no input")
+			return
+		}
+		if m.input == nil {
+			m.input = bufio.NewReader(m.Input)
+		}
+		var value int64
+		if _, err := fmt.Fscan(m.input, &value); err != nil {
+			m.fault = fmt.Errorf("legv8: read integer: %v", err)
+			return
+		}
+		m.setReg(0, value)
+	case SysExit:
+		m.ExitCode = int(m.reg(0))
+		m.Halted = true
+	case SysCycles:
+		m.setReg(0, int64(m.Cycle))
+	default:
+		m.fault = fmt.Errorf("legv8: SVC #%d is not a syscall", number)
+	}
+}
@@ -0,0 +1,56 @@
+package legv8
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSyscalls(t *testing.T) {
+	m := NewMachine()
+	m.Load(assemble(t, `
+	MOVZ X0, 42
+	SVC  #1
+	ADDI X0, XZR, msg
+	SVC  #2
+	SVC  #3
+	ADDI X0, X0, #1
+	SVC  #1
+	SVC  #5
+	ADDI X9, X0, #0
+	ADDI X0, XZR, #3
+	SVC  #4
+	BREAK
+msg:	.word 0x000A6948 // "Hi\n"`))
+	var out strings.Builder
+	m.Output = &out
+	m.Input = strings.NewReader(" 41\n")
+	if err := m.Run(nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := out.String(); got != "42Hi\n42" {
+		t.Errorf("output is %q, want %q", got, "42Hi\n42")
+	}
+	if m.Registers[9] != 7 {
+		t.Errorf("the cycle count is %d, want 7", m.Registers[9])
+	}
+	if !m.Halted || m.ExitCode != 3 || m.PC != 140 {
+		t.Errorf("halted %v with exit code %d at %d, want the exit syscall to stop the program with 3", m.Halted, m.ExitCode, m.PC)
+	}
+}
+
+func TestSyscallFaults(t *testing.T) {
+	for _, test := range []struct {
+		source, err string
+	}{
+		{"SVC #9\nBREAK", "SVC #9 is not a syscall"},
+		{"SVC #3\nBREAK", "there is no input"},
+		{"SUBI X0, XZR, #1\nSVC #2\nBREAK", "out of range"},
+	} {
+		m := NewMachine()
+		m.Load(assemble(t, test.source))
+		err := m.Run(nil)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%q: error is %v, want one with %q", test.source, err, test.err)
+		}
+	}
+}
@@ -0,0 +1,479 @@
+package legv8
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// op classes, each has its own reservation stations and functional units
+const (
+	classALU = iota
+	classLoadStore
+	classBranch
+)
+
+var classNames = []string{"ALU", "LS", "BR"}
+
+// UnitConfig sizes the reservation stations and functional units of one op
+// class.
+type UnitConfig struct {
+	Stations int // reservation stations instructions wait in for their operands
+	Units    int // functional units, each runs one instruction at a time
+	Latency  int // cycles an instruction spends executing
+}
+
+// TomasuloConfig describes the out of order machine.
+type TomasuloConfig struct {
+	ROBSize   int // reorder buffer entries
+	ALU       UnitConfig
+	LoadStore UnitConfig
+	Branch    UnitConfig
+}
+
+// DefaultTomasuloConfig is a 16 entry ROB with three ALU stations and two
+// ALUs, three load/store stations in front of one 2 cycle unit and two
+// branch stations in front of one branch unit.
+var DefaultTomasuloConfig = TomasuloConfig{
+	ROBSize:   16,
+	ALU:       UnitConfig{Stations: 3, Units: 2, Latency: 1},
+	LoadStore: UnitConfig{Stations: 3, Units: 1, Latency: 2},
+	Branch:    UnitConfig{Stations: 2, Units: 1, Latency: 1},
+}
+
+// TomasuloStats counts what happened over an out of order run.
+type TomasuloStats struct {
+	Cycles       int
+	Instructions int // committed instructions
+	ROBFull      int // cycles issue waited for a free ROB entry
+	StationsFull int // cycles issue waited for a free reservation station
+	BranchStalls int // cycles issue waited for a branch the front end got wrong
+	Flushes      int // branches issue had to wait for
+}
+
+// robEntry is one instruction between issue and commit. Its tag is the ROB
+// slot it sits in, which is the name its result is renamed to.
+type robEntry struct {
+	inst    Instruction
+	tag     int
+	class   int
+	station int         // index into the stations of the class, -1 once the result is written
+	waits   []*robEntry // producers of the operands, in issue order
+	addr    int         // byte address of a load or store
+	latency int
+	flush   bool // issue waits for this branch to resolve
+
+	// what the instruction did, commit applies it to the committed state
+	values   []int64 // the value of each register inst.Writes() names, 0 for the flags
+	flags    Flags
+	stored   bool // whether value was stored at addr
+	value    int64
+	nextPC   int
+	halted   bool
+	exitCode int
+	record   *TraceRecord // for the Trace of the committed state, nil without one
+
+	issue, start, finish, write int // cycles, 0 until they happen
+}
+
+// ready reports whether the operands of e are on hand in cycle
+func (e *robEntry) ready(cycle int) bool {
+	for _, w := range e.waits {
+		if w.write == 0 || w.write >= cycle {
+			return false
+		}
+	}
+	return true
+}
+
+// Tomasulo is an out of order timing model using Tomasulo's algorithm with a
+// reorder buffer. Like Pipeline, the Machine runs the program in order (at
+// issue) and the model works out when each instruction issues, executes,
+// writes its result on the common data bus and commits. Each ROB entry keeps
+// the results of its instruction and commit applies them to a state of its
+// own, so the state Retire sees is that of the committed instructions, not
+// of the issued ones.
+type Tomasulo struct {
+	Config  TomasuloConfig
+	Machine *Machine
+	Stats   TomasuloStats
+
+	stations [3][]*robEntry // busy reservation stations by class, nil when free
+	units    [3][]int       // cycle each functional unit is busy until
+	rob      []*robEntry    // oldest first
+	renamed  map[uint8]*robEntry
+	blocked  *robEntry // branch issue waits for
+	issued   int       // instructions issued so far, picks the ROB slot of the next one
+	done     bool      // BREAK has issued
+	arch     *Machine  // the committed state, Machine runs ahead of it
+}
+
+// NewTomasulo returns an out of order model that runs the machine.
+func NewTomasulo(m *Machine, config TomasuloConfig) *Tomasulo {
+	t := &Tomasulo{Config: config, Machine: m, renamed: make(map[uint8]*robEntry)}
+	for class, unit := range t.unitConfigs() {
+		t.stations[class] = make([]*robEntry, unit.Stations)
+		t.units[class] = make([]int, unit.Units)
+	}
+	return t
+}
+
+func (t *Tomasulo) unitConfigs() []UnitConfig {
+	return []UnitConfig{t.Config.ALU, t.Config.LoadStore, t.Config.Branch}
+}
+
+// Run steps the machine until BREAK commits, writing the reservation
+// stations and reorder buffer for every cycle to w, followed by a summary
+// and the final state.
+func (t *Tomasulo) Run(w io.Writer) error {
+	// Retire and Trace get the instructions at commit, with the committed state
+	m := t.Machine
+	arch := *m
+	arch.Memory = m.Memory.Clone()
+	t.arch = &arch
+	m.Retire = nil
+	if m.Trace != nil {
+		m.Trace = &lastRecord{}
+	}
+	defer func() { m.Retire, m.Trace = arch.Retire, arch.Trace }()
+
+	for cycle := 1; !t.done || len(t.rob) > 0; cycle++ {
+		committed := t.commit(cycle)
+		written := t.writeResult(cycle)
+		t.execute(cycle)
+		issued, err := t.issue(cycle)
+		if err != nil {
+			fmt.Fprintf(w, "====================\nFault:\t%v\n", err)
+			return err
+		}
+		t.print(w, cycle, issued, written, committed)
+		t.Stats.Cycles = cycle
+	}
+	t.printSummary(w)
+	if t.Machine.Caches != nil {
+		PrintCacheStats(w, t.Machine.Caches)
+	}
+	if t.Machine.Branches != nil {
+		PrintBranchStats(w, t.Machine.Branches, t.Machine, 0)
+	}
+	return nil
+}
+
+// commit retires the oldest instruction once its result is written
+func (t *Tomasulo) commit(cycle int) *robEntry {
+	if len(t.rob) == 0 || t.rob[0].write == 0 || t.rob[0].write >= cycle {
+		return nil
+	}
+	e := t.rob[0]
+	t.rob = t.rob[1:]
+	for _, r := range e.inst.Writes() {
+		if t.renamed[r] == e {
+			delete(t.renamed, r)
+		}
+	}
+	t.Stats.Instructions++
+	t.retire(e)
+	return e
+}
+
+// retire applies what a committed instruction did to the committed state
+// and hands it to Retire. Device accesses already happened at issue.
+func (t *Tomasulo) retire(e *robEntry) {
+	a := t.arch
+	for i, r := range e.inst.Writes() {
+		if r == FlagsReg {
+			a.Flags = e.flags
+		} else {
+			a.Registers[r] = e.values[i]
+		}
+	}
+	if e.stored && !a.Memory.IsDevice(e.addr) {
+		_ = a.Memory.Store(e.addr, e.inst.accessSize(), uint64(e.value))
+	}
+	a.PC, a.Halted, a.ExitCode = e.nextPC, e.halted, e.exitCode
+	if e.record != nil {
+		a.Trace.Write(*e.record)
+	}
+	if a.Retire != nil {
+		a.Retire(a, e.inst)
+	}
+}
+
+// lastRecord keeps the trace record of the instruction that just issued,
+// until it commits
+type lastRecord struct {
+	record *TraceRecord
+}
+
+func (l *lastRecord) Write(r TraceRecord) { l.record = &r }
+func (l *lastRecord) Flush() error        { return nil }
+
+// writeResult puts the oldest finished result on the common data bus, which
+// hands it to every station waiting on it and frees its own station
+func (t *Tomasulo) writeResult(cycle int) *robEntry {
+	for _, e := range t.rob {
+		if e.finish != 0 && e.finish < cycle && e.write == 0 {
+			e.write = cycle
+			t.stations[e.class][e.station] = nil
+			e.station = -1
+			return e
+		}
+	}
+	return nil
+}
+
+// execute starts every waiting instruction whose operands are ready on a
+// free functional unit, oldest first
+func (t *Tomasulo) execute(cycle int) {
+	for _, e := range t.rob {
+		if e.start != 0 || e.issue >= cycle || !e.ready(cycle) || !t.memoryReady(e, cycle) {
+			continue
+		}
+		units := t.units[e.class]
+		for u := range units {
+			if units[u] < cycle {
+				e.start = cycle
+				e.finish = cycle + e.latency - 1
+				units[u] = e.finish
+				break
+			}
+		}
+	}
+}
+
+// memoryReady keeps a load behind every older store to the same address
+// until the store has its data
+func (t *Tomasulo) memoryReady(e *robEntry, cycle int) bool {
+	return t.storeBefore(e, cycle) == nil
+}
+
+// storeBefore returns an older store to the address a load reads that has
+// not written by cycle, or nil if there is none
+func (t *Tomasulo) storeBefore(e *robEntry, cycle int) *robEntry {
+	if !e.inst.IsLoad() {
+		return nil
+	}
+	for _, older := range t.rob {
+		if older == e {
+			break
+		}
+		if older.inst.IsStore() && older.addr-8 < e.addr && e.addr < older.addr+8 &&
+			(older.write == 0 || older.write >= cycle) {
+			return older
+		}
+	}
+	return nil
+}
+
+// issue steps the machine and puts the instruction into a reservation
+// station and the ROB, renaming the registers it writes to its ROB entry
+func (t *Tomasulo) issue(cycle int) (*robEntry, error) {
+	m := t.Machine
+	if t.done {
+		return nil, nil
+	}
+	if t.blocked != nil {
+		if t.blocked.write == 0 || t.blocked.write >= cycle {
+			t.Stats.BranchStalls++
+			return nil, nil
+		}
+		t.blocked = nil
+	}
+	if len(t.rob) == t.Config.ROBSize {
+		t.Stats.ROBFull++
+		return nil, nil
+	}
+	next, err := m.Fetch(m.PC)
+	if err != nil {
+		return nil, err
+	}
+	class := classALU
+	switch {
+	case next.IsLoad() || next.IsStore():
+		class = classLoadStore
+	case next.IsBranch():
+		class = classBranch
+	}
+	station := -1
+	for i, busy := range t.stations[class] {
+		if busy == nil {
+			station = i
+			break
+		}
+	}
+	if station < 0 {
+		t.Stats.StationsFull++
+		return nil, nil
+	}
+
+	e := &robEntry{class: class, station: station, issue: cycle, latency: t.unitConfigs()[class].Latency}
+	if class == classLoadStore {
+		e.addr = m.dataAddress(next)
+	}
+	inst, err := m.Step()
+	if err != nil {
+		return nil, err
+	}
+	e.inst = inst
+	for _, r := range inst.Writes() {
+		var value int64
+		if r != FlagsReg {
+			value = m.Registers[r]
+		}
+		e.values = append(e.values, value)
+	}
+	e.flags = m.Flags
+	e.stored = inst.IsStore() && !m.faulted()
+	e.value = m.reg(inst.Rt)
+	e.nextPC, e.halted, e.exitCode = m.PC, m.Halted, m.ExitCode
+	if last, ok := m.Trace.(*lastRecord); ok {
+		e.record, last.record = last.record, nil
+	}
+	if cs := m.Caches; cs != nil && class == classLoadStore {
+		e.latency += maxInt(cs.DataCycles, 1) - 1
+	}
+	seen := make(map[*robEntry]bool)
+	for _, r := range inst.Reads() {
+		if producer, ok := t.renamed[r]; ok && !seen[producer] {
+			e.waits = append(e.waits, producer)
+			seen[producer] = true
+		}
+	}
+	for _, r := range inst.Writes() {
+		t.renamed[r] = e
+	}
+	e.tag = t.issued%t.Config.ROBSize + 1 // the ROB is a circular buffer
+	t.issued++
+
+	e.flush = !m.faulted() && m.PC != inst.ProgramCnt+4 // without a predictor every taken branch stalls issue
+	if bu := m.Branches; bu != nil {
+		e.flush = inst.IsBranch() && bu.Mispredicted
+	}
+	if e.flush {
+		t.blocked = e
+		t.Stats.Flushes++
+	}
+	t.done = m.Halted
+	t.stations[class][station] = e
+	t.rob = append(t.rob, e)
+	return e, nil
+}
+
+// state describes where an instruction is at the end of cycle
+func (t *Tomasulo) state(e *robEntry, cycle int) string {
+	switch {
+	case e.write != 0:
+		return "written"
+	case e.finish != 0 && e.finish < cycle:
+		return "done, waiting for the CDB"
+	case e.start != 0:
+		return fmt.Sprintf("executing %d/%d", cycle-e.start+1, e.latency)
+	}
+	var tags []string
+	for _, w := range e.waits {
+		if w.write == 0 || w.write > cycle {
+			tags = append(tags, "#"+strconv.Itoa(w.tag))
+		}
+	}
+	if store := t.storeBefore(e, cycle+1); store != nil {
+		tags = append(tags, "store #"+strconv.Itoa(store.tag))
+	}
+	if len(tags) > 0 {
+		return "waiting for " + strings.Join(tags, " ")
+	}
+	return "ready"
+}
+
+// dest names what an instruction writes
+func (e *robEntry) dest() string {
+	var names []string
+	for _, r := range e.inst.Writes() {
+		names = append(names, registerName(r))
+	}
+	if e.inst.IsStore() {
+		names = append(names, "M["+strconv.Itoa(e.addr)+"]")
+	}
+	if len(names) == 0 {
+		return "-"
+	}
+	return strings.Join(names, " ")
+}
+
+// print writes the stations and the ROB at the end of a cycle
+func (t *Tomasulo) print(w io.Writer, cycle int, issued, written, committed *robEntry) {
+	text := func(e *robEntry) string {
+		if e == nil {
+			return "-"
+		}
+		return fmt.Sprintf("#%d\t%d\t%s", e.tag, e.inst.ProgramCnt, InstructionString(e.inst))
+	}
+	fmt.Fprintln(w, "====================")
+	fmt.Fprintf(w, "Cycle:%d\n", cycle)
+	fmt.Fprintf(w, "Issue:\t%s\n", text(issued))
+	fmt.Fprintf(w, "CDB:\t%s\n", text(written))
+	fmt.Fprintf(w, "Commit:\t%s\n", text(committed))
+	fmt.Fprintln(w, "Stations:")
+	for class, stations := range t.stations {
+		for i, e := range stations {
+			if e == nil {
+				fmt.Fprintf(w, "%s%d:\t-\n", classNames[class], i+1)
+			} else {
+				fmt.Fprintf(w, "%s%d:\t%s\t%s\n", classNames[class], i+1, text(e), t.state(e, cycle))
+			}
+		}
+	}
+	fmt.Fprintln(w, "ROB:")
+	for _, e := range t.rob {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", text(e), e.dest(), t.state(e, cycle))
+	}
+}
+
+// printSummary writes the statistics and the final machine state
+func (t *Tomasulo) printSummary(w io.Writer) {
+	fmt.Fprintln(w, "====================")
+	fmt.Fprintf(w, "Tomasulo:\t%d entry ROB", t.Config.ROBSize)
+	for class, unit := range t.unitConfigs() {
+		fmt.Fprintf(w, ", %s %d stations %d units latency %d", classNames[class], unit.Stations, unit.Units, unit.Latency)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "Instructions:\t%d\n", t.Stats.Instructions)
+	fmt.Fprintf(w, "Cycles:\t%d\n", t.Stats.Cycles)
+	fmt.Fprintf(w, "IPC:\t%.2f\n", float64(t.Stats.Instructions)/float64(t.Stats.Cycles))
+	fmt.Fprintf(w, "ROB full cycles:\t%d\n", t.Stats.ROBFull)
+	fmt.Fprintf(w, "Stations full cycles:\t%d\n", t.Stats.StationsFull)
+	fmt.Fprintf(w, "Branch stall cycles:\t%d\n", t.Stats.BranchStalls)
+	printState(w, t.Machine, Full)
+}
+
+// ParseUnitConfig reads the settings of one op class such as
+// "stations=3,units=2,latency=1", anything left out keeps its value in config.
+func ParseUnitConfig(name string, spec string, config UnitConfig) (UnitConfig, error) {
+	if strings.TrimSpace(spec) == "" {
+		return config, nil
+	}
+	for _, part := range strings.Split(spec, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return config, fmt.Errorf("legv8: %s: %q is not key=value", name, part)
+		}
+		n, err := strconv.Atoi(value)
+		if err == nil && n < 1 {
+			err = fmt.Errorf("must be at least 1")
+		}
+		switch key {
+		case "stations":
+			config.Stations = n
+		case "units":
+			config.Units = n
+		case "latency":
+			config.Latency = n
+		default:
+			err = fmt.Errorf("unknown setting")
+		}
+		if err != nil {
+			return config, fmt.Errorf("legv8: %s: %s: %v", name, part, err)
+		}
+	}
+	return config, nil
+}
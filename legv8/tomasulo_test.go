@@ -0,0 +1,88 @@
+package legv8
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+// runTomasulo runs a program out of order and returns the model and its output
+func runTomasulo(t *testing.T, source string, config TomasuloConfig) (*Tomasulo, string) {
+	t.Helper()
+	m := NewMachine()
+	m.Load(assemble(t, source))
+	tm := NewTomasulo(m, config)
+	var out strings.Builder
+	if err := tm.Run(&out); err != nil {
+		t.Fatal(err)
+	}
+	return tm, out.String()
+}
+
+func TestTomasuloDependences(t *testing.T) {
+	independent, _ := runTomasulo(t, "ADDI X1, XZR, #1\nADDI X2, XZR, #2\nADDI X3, XZR, #3\nADDI X4, XZR, #4\nBREAK", DefaultTomasuloConfig)
+	chain, _ := runTomasulo(t, "ADDI X1, XZR, #1\nADDI X1, X1, #2\nADDI X1, X1, #3\nADDI X1, X1, #4\nBREAK", DefaultTomasuloConfig)
+	if independent.Stats.Instructions != 5 || chain.Stats.Instructions != 5 {
+		t.Fatalf("committed %d and %d instructions, want 5", independent.Stats.Instructions, chain.Stats.Instructions)
+	}
+	if chain.Stats.Cycles <= independent.Stats.Cycles {
+		t.Errorf("a dependent chain took %d cycles and independent adds %d, want the chain to be slower",
+			chain.Stats.Cycles, independent.Stats.Cycles)
+	}
+	if chain.Machine.Registers[1] != 10 {
+		t.Errorf("X1 is %d, want 10", chain.Machine.Registers[1])
+	}
+}
+
+func TestTomasuloStructuralStalls(t *testing.T) {
+	source := "ADDI X1, XZR, #1\nADDI X2, XZR, #2\nADDI X3, XZR, #3\nADDI X4, XZR, #4\nBREAK"
+	config := DefaultTomasuloConfig
+	config.ROBSize = 1
+	small, _ := runTomasulo(t, source, config)
+	if small.Stats.ROBFull == 0 {
+		t.Errorf("a 1 entry ROB never filled up")
+	}
+
+	config = DefaultTomasuloConfig
+	config.ALU = UnitConfig{Stations: 1, Units: 1, Latency: 3}
+	slow, _ := runTomasulo(t, source, config)
+	if slow.Stats.StationsFull == 0 {
+		t.Errorf("a single 3 cycle ALU station never filled up")
+	}
+}
+
+func TestTomasuloMemoryOrder(t *testing.T) {
+	tm, out := runTomasulo(t, `
+	ADDI X1, XZR, #7
+	STUR X1, [XZR, #10]
+	LDUR X2, [XZR, #10]
+	BREAK`, DefaultTomasuloConfig)
+	if !strings.Contains(out, "#3\t104\tLDUR\tR2, [R31, #10]\tX2\twaiting for store #2\n") {
+		t.Errorf("the load never waits for the store:\n%s", out)
+	}
+	if tm.Machine.Registers[2] != 7 {
+		t.Errorf("X2 is %d, want 7", tm.Machine.Registers[2])
+	}
+}
+
+func TestTomasuloBranchStalls(t *testing.T) {
+	tm, _ := runTomasulo(t, loopProgram, DefaultTomasuloConfig)
+	if tm.Stats.Flushes != 3 || tm.Stats.BranchStalls == 0 {
+		t.Errorf("%d flushes and %d branch stall cycles, want 3 taken branches to stall issue", tm.Stats.Flushes, tm.Stats.BranchStalls)
+	}
+	if err := NewTomasulo(tm.Machine, DefaultTomasuloConfig).Run(io.Discard); err == nil {
+		t.Errorf("running a halted machine again is not an error")
+	}
+}
+
+func TestParseUnitConfig(t *testing.T) {
+	got, err := ParseUnitConfig("alu", "units=4,latency=2", DefaultTomasuloConfig.ALU)
+	if err != nil || got != (UnitConfig{Stations: 3, Units: 4, Latency: 2}) {
+		t.Errorf("config is %+v, %v", got, err)
+	}
+	for _, spec := range []string{"units", "units=0", "speed=2"} {
+		if _, err := ParseUnitConfig("alu", spec, DefaultTomasuloConfig.ALU); err == nil {
+			t.Errorf("%q parsed without an error", spec)
+		}
+	}
+}
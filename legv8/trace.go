@@ -0,0 +1,165 @@
+package legv8
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// TraceRecord is what one retired instruction did, for machine readable
+// traces.
+type TraceRecord struct {
+	Cycle       int             `json:"cycle"`
+	PC          int             `json:"pc"`
+	Disassembly string          `json:"disassembly"`
+	Registers   []RegisterWrite `json:"registers"`       // in the order they were written
+	Flags       *string         `json:"flags,omitempty"` // the flags set afterwards, only for instructions that set them
+	Reads       []MemoryAccess  `json:"reads"`
+	Writes      []MemoryAccess  `json:"writes"`
+	Branch      *BranchOutcome  `json:"branch,omitempty"`    // only for branches
+	Exception   string          `json:"exception,omitempty"` // set when an exception was taken in the same cycle
+}
+
+// RegisterWrite is a register and the value written to it.
+type RegisterWrite struct {
+	Reg   string `json:"reg"`
+	Value int64  `json:"value"`
+}
+
+// MemoryAccess is one load or store.
+type MemoryAccess struct {
+	Addr  int   `json:"addr"`
+	Size  int   `json:"size"`
+	Value int64 `json:"value"`
+}
+
+// BranchOutcome is where a branch went. Target is the address a conditional
+// branch would have gone to when it is not taken.
+type BranchOutcome struct {
+	Taken  bool `json:"taken"`
+	Target int  `json:"target"`
+}
+
+// TraceWriter writes one record per retired instruction. Write errors are
+// kept and returned by Flush, so a trace never stops the machine.
+type TraceWriter interface {
+	Write(r TraceRecord)
+	Flush() error
+}
+
+// TraceFormats lists the formats NewTraceWriter knows.
+var TraceFormats = []string{"jsonl", "csv"}
+
+// NewTraceWriter returns a writer for one of the TraceFormats: JSON Lines,
+// one object per line, or CSV with a header row.
+func NewTraceWriter(format string, w io.Writer) (TraceWriter, error) {
+	switch format {
+	case "jsonl":
+		buf := bufio.NewWriter(w)
+		return &jsonlTrace{buf: buf, encoder: json.NewEncoder(buf)}, nil
+	case "csv":
+		return &csvTrace{out: csv.NewWriter(w)}, nil
+	}
+	return nil, fmt.Errorf("legv8: trace format %q is not one of %s", format, strings.Join(TraceFormats, ", "))
+}
+
+type jsonlTrace struct {
+	buf     *bufio.Writer
+	encoder *json.Encoder
+	err     error
+}
+
+// Write writes empty lists as [] rather than null, so jq can iterate them
+func (t *jsonlTrace) Write(r TraceRecord) {
+	if r.Registers == nil {
+		r.Registers = []RegisterWrite{}
+	}
+	if r.Reads == nil {
+		r.Reads = []MemoryAccess{}
+	}
+	if r.Writes == nil {
+		r.Writes = []MemoryAccess{}
+	}
+	if t.err == nil {
+		t.err = t.encoder.Encode(r)
+	}
+}
+
+func (t *jsonlTrace) Flush() error {
+	if t.err != nil {
+		return t.err
+	}
+	return t.buf.Flush()
+}
+
+// csvTrace flattens the lists of a record into space separated cells such
+// as "X1=5 X2=7" and "200:8=5"
+type csvTrace struct {
+	out    *csv.Writer
+	header bool
+}
+
+func (t *csvTrace) Write(r TraceRecord) {
+	if !t.header {
+		_ = t.out.Write([]string{"cycle", "pc", "disassembly", "registers", "flags", "reads", "writes",
+			"branch_taken", "branch_target", "exception"})
+		t.header = true
+	}
+	var regs []string
+	for _, w := range r.Registers {
+		regs = append(regs, w.Reg+"="+strconv.FormatInt(w.Value, 10))
+	}
+	accesses := func(list []MemoryAccess) string {
+		var cells []string
+		for _, a := range list {
+			cells = append(cells, fmt.Sprintf("%d:%d=%d", a.Addr, a.Size, a.Value))
+		}
+		return strings.Join(cells, " ")
+	}
+	flags, taken, target := "", "", ""
+	if r.Flags != nil {
+		flags = *r.Flags
+	}
+	if r.Branch != nil {
+		taken, target = strconv.FormatBool(r.Branch.Taken), strconv.Itoa(r.Branch.Target)
+	}
+	_ = t.out.Write([]string{strconv.Itoa(r.Cycle), strconv.Itoa(r.PC), r.Disassembly,
+		strings.Join(regs, " "), flags, accesses(r.Reads), accesses(r.Writes), taken, target, r.Exception})
+}
+
+func (t *csvTrace) Flush() error {
+	t.out.Flush()
+	return t.out.Error()
+}
+
+// traceRetired finishes the record of an instruction that just retired, or
+// faulted into a handler, and writes it. PC has already moved on.
+func (m *Machine) traceRetired(inst Instruction, faulted bool) {
+	r := m.record
+	m.record = nil
+	r.Cycle, r.PC, r.Disassembly = inst.Cycle, inst.ProgramCnt, normalize(InstructionString(inst))
+	if m.Exception != nil {
+		r.Exception = m.Exception.String()
+	}
+	if faulted {
+		m.Trace.Write(*r)
+		return
+	}
+	if inst.IsBranch() {
+		taken := inst.TypeOfInstruction != "CB" || m.PC != inst.ProgramCnt+4
+		target := m.PC
+		if !taken {
+			target = inst.ProgramCnt + 4*int(inst.Offset)
+		}
+		r.Branch = &BranchOutcome{taken, target}
+	}
+	if flagSetters[inst.Op] {
+		flags := flagLetters(m.Flags)
+		r.Flags = &flags
+	}
+	m.Trace.Write(*r)
+}
@@ -0,0 +1,116 @@
+package legv8
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// recordTrace keeps the records it is given
+type recordTrace struct {
+	records []TraceRecord
+}
+
+func (r *recordTrace) Write(record TraceRecord) { r.records = append(r.records, record) }
+func (r *recordTrace) Flush() error             { return nil }
+
+const traceProgram = `
+	ADDI  X1, XZR, #200
+	ADDI  X2, XZR, #7
+	STUR  X2, [X1, #1]
+	LDUR  X3, [X1, #1]
+	SUBIS X4, X3, #7
+	B.NE  skip
+	B     done
+skip:	NOP
+done:	BREAK`
+
+// traceRun runs traceProgram with run and returns its trace
+func traceRun(t *testing.T, run func(m *Machine) error) []TraceRecord {
+	t.Helper()
+	m := NewMachine()
+	m.Load(assemble(t, traceProgram))
+	trace := &recordTrace{}
+	m.Trace = trace
+	if err := run(m); err != nil {
+		t.Fatal(err)
+	}
+	if m.Trace != trace {
+		t.Errorf("the model left Trace set to %v", m.Trace)
+	}
+	return trace.records
+}
+
+func TestTraceRecords(t *testing.T) {
+	records := traceRun(t, func(m *Machine) error { return m.Run(nil) })
+	if len(records) != 8 {
+		t.Fatalf("%d records, want 8", len(records))
+	}
+	zc := "ZC"
+	want := []TraceRecord{
+		{Cycle: 1, PC: 96, Registers: []RegisterWrite{{"X1", 200}}},
+		{Cycle: 2, PC: 100, Registers: []RegisterWrite{{"X2", 7}}},
+		{Cycle: 3, PC: 104, Writes: []MemoryAccess{{204, 4, 7}}},
+		{Cycle: 4, PC: 108, Registers: []RegisterWrite{{"X3", 7}}, Reads: []MemoryAccess{{204, 4, 7}}},
+		{Cycle: 5, PC: 112, Registers: []RegisterWrite{{"X4", 0}}, Flags: &zc},
+		{Cycle: 6, PC: 116, Branch: &BranchOutcome{false, 124}},
+		{Cycle: 7, PC: 120, Branch: &BranchOutcome{true, 128}},
+		{Cycle: 8, PC: 128},
+	}
+	for i, w := range want {
+		r := records[i]
+		r.Disassembly = ""
+		if !reflect.DeepEqual(r, w) {
+			t.Errorf("record of cycle %d is %+v, want %+v", w.Cycle, r, w)
+		}
+	}
+}
+
+// TestTraceAtCommit checks that the out of order model traces the same
+// instructions, in the same order, as running the program in order
+func TestTraceAtCommit(t *testing.T) {
+	want := traceRun(t, func(m *Machine) error { return m.Run(nil) })
+	got := traceRun(t, func(m *Machine) error { return NewTomasulo(m, DefaultTomasuloConfig).Run(io.Discard) })
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("the out of order trace is\n%+v\nwant\n%+v", got, want)
+	}
+}
+
+func TestTraceFormats(t *testing.T) {
+	zc := "ZC"
+	records := []TraceRecord{
+		{Cycle: 1, PC: 96, Disassembly: "ADDI X1, XZR, #200", Registers: []RegisterWrite{{"X1", 200}}},
+		{Cycle: 2, PC: 100, Disassembly: "SUBIS X4, X3, #7", Registers: []RegisterWrite{{"X4", 0}}, Flags: &zc,
+			Reads: []MemoryAccess{{204, 4, 7}}, Branch: &BranchOutcome{true, 128}},
+	}
+	for _, test := range []struct {
+		format, want string
+	}{
+		{"jsonl", `{"cycle":1,"pc":96,"disassembly":"ADDI X1, XZR, #200","registers":[{"reg":"X1","value":200}],"reads":[],"writes":[]}
+{"cycle":2,"pc":100,"disassembly":"SUBIS X4, X3, #7","registers":[{"reg":"X4","value":0}],"flags":"ZC","reads":[{"addr":204,"size":4,"value":7}],"writes":[],"branch":{"taken":true,"target":128}}
+`},
+		{"csv", `cycle,pc,disassembly,registers,flags,reads,writes,branch_taken,branch_target,exception
+1,96,"ADDI X1, XZR, #200",X1=200,,,,,,
+2,100,"SUBIS X4, X3, #7",X4=0,ZC,204:4=7,,true,128,
+`},
+	} {
+		var out strings.Builder
+		trace, err := NewTraceWriter(test.format, &out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, r := range records {
+			trace.Write(r)
+		}
+		if err := trace.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		if out.String() != test.want {
+			t.Errorf("%s trace is\n%s\nwant\n%s", test.format, out.String(), test.want)
+		}
+	}
+	if _, err := NewTraceWriter("xml", io.Discard); err == nil {
+		t.Error("NewTraceWriter accepted the xml format")
+	}
+}
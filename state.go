@@ -0,0 +1,71 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"Project2_Team10/legv8"
+)
+
+// stringList is a flag that can be given more than once
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, " ") }
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// stateFlags are the flags that give the machine an initial state
+type stateFlags struct {
+	file      *string
+	registers stringList
+	memory    stringList
+}
+
+// addStateFlags adds -state, -reg and -mem to a flag set
+func addStateFlags(flags *flag.FlagSet) *stateFlags {
+	sf := &stateFlags{}
+	sf.file = flags.String("state", "", "-state [file] JSON file with registers, flags and memory to start from")
+	flags.Var(&sf.registers, "reg", "-reg [register=value] start a register at a value, can be repeated, e.g. -reg X1=10")
+	flags.Var(&sf.memory, "mem", "-mem [addr[:size]=value,...] store values from addr, 4 bytes each by default, can be repeated")
+	return sf
+}
+
+// set reports whether any state was asked for
+func (sf *stateFlags) set() bool {
+	return *sf.file != "" || len(sf.registers) > 0 || len(sf.memory) > 0
+}
+
+// apply gives the machine the state file with the -reg and -mem flags on top
+func (sf *stateFlags) apply(m *legv8.Machine) error {
+	if !sf.set() {
+		return nil
+	}
+	state := &legv8.State{}
+	if *sf.file != "" {
+		file, err := os.Open(*sf.file)
+		if err != nil {
+			return err
+		}
+		state, err = legv8.ReadState(file)
+		file.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %v", *sf.file, err)
+		}
+	}
+	for _, spec := range sf.registers {
+		if err := state.SetRegister(spec); err != nil {
+			return err
+		}
+	}
+	for _, spec := range sf.memory {
+		if err := state.AddMemory(spec); err != nil {
+			return err
+		}
+	}
+	return m.SetInitialState(state)
+}